@@ -2,30 +2,423 @@ package main
 
 // nolint: lll
 import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/badges"
 	"github.com/brigadecore/badgr/internal/badges/redis"
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/brigadecore/badgr/internal/tracing"
 	"github.com/brigadecore/brigade-foundations/http"
-	"github.com/brigadecore/brigade-foundations/os"
+	"github.com/pkg/errors"
 )
 
+// startupConfig is the configuration of components that are created only once,
+// when Badgr starts. Changes to it take effect only when Badgr is restarted.
+type startupConfig struct {
+	accessLog      logging.AccessLogConfig
+	server         http.ServerConfig
+	metricsServer  http.ServerConfig
+	tracing        tracing.Config
+	cache          redis.CacheConfig
+	popularity     redis.PopularityConfig
+	prewarm        badges.PrewarmConfig
+	circuitBreaker badges.CircuitBreakerConfig
+	apiKeys        badges.APIKeysConfig
+}
+
+// loadStartupConfig populates the configuration of components that are
+// created only once, when Badgr starts, from environment variables.
+func loadStartupConfig(s settings) (startupConfig, error) {
+	config := startupConfig{apiKeys: apiKeysConfig(s)}
+	var err error
+	if config.accessLog, err = accessLogConfig(s); err != nil {
+		return config, err
+	}
+	if config.server, err = serverConfig(s); err != nil {
+		return config, err
+	}
+	if config.metricsServer, err = metricsServerConfig(s); err != nil {
+		return config, err
+	}
+	if config.tracing, err = tracingConfig(s); err != nil {
+		return config, err
+	}
+	if config.cache, err = redisCacheConfig(s); err != nil {
+		return config, err
+	}
+	if config.popularity, err = popularityConfig(s); err != nil {
+		return config, err
+	}
+	if config.prewarm, err = prewarmConfig(s); err != nil {
+		return config, err
+	}
+	config.circuitBreaker, err = circuitBreakerConfig(s)
+	return config, err
+}
+
+// changes returns the names of the areas of configuration, as they are named
+// in the config file, that differ between this and another startupConfig.
+func (c startupConfig) changes(other startupConfig) []string {
+	var changes []string
+	for _, area := range []struct {
+		name       string
+		this, that interface{}
+	}{
+		{"accessLog", c.accessLog, other.accessLog},
+		{"server", c.server, other.server},
+		{"metrics", c.metricsServer, other.metricsServer},
+		{"tracing", c.tracing, other.tracing},
+		{"cache", c.cache, other.cache},
+		// The capacity of popularity tallies follows from the prewarm settings
+		{
+			"prewarm.popularityWindow",
+			c.popularity.Window,
+			other.popularity.Window,
+		},
+		{"prewarm", c.prewarm, other.prewarm},
+		{"github.breaker", c.circuitBreaker, other.circuitBreaker},
+		{"adminToken", c.apiKeys, other.apiKeys},
+	} {
+		if !reflect.DeepEqual(area.this, area.that) {
+			changes = append(changes, area.name)
+		}
+	}
+	return changes
+}
+
+// loggingConfig populates configuration for logging from environment
+// variables.
+func loggingConfig(s settings) (logging.Config, error) {
+	config := logging.Config{}
+	levelStr := s.get("LOG_LEVEL", "info")
+	if err := config.Level.UnmarshalText([]byte(levelStr)); err != nil {
+		return config, errors.Wrapf(
+			err,
+			"value %q for environment variable LOG_LEVEL was not parsable as a "+
+				"log level",
+			levelStr,
+		)
+	}
+	return config, nil
+}
+
+// accessLogConfig populates configuration for the access log from environment
+// variables.
+func accessLogConfig(s settings) (logging.AccessLogConfig, error) {
+	config := logging.AccessLogConfig{}
+	var err error
+	sampleRateStr := s.get("ACCESS_LOG_SAMPLE_RATE", "1")
+	config.SampleRate, err = strconv.ParseFloat(sampleRateStr, 64)
+	if err != nil || config.SampleRate < 0 || config.SampleRate > 1 {
+		return config, errors.Errorf(
+			"value %q for environment variable ACCESS_LOG_SAMPLE_RATE was not "+
+				"parsable as a number between 0 and 1",
+			sampleRateStr,
+		)
+	}
+	config.LogHealthz, err = s.getBool("ACCESS_LOG_HEALTHZ", false)
+	if err != nil {
+		return config, err
+	}
+	config.TrustedProxies, err =
+		s.getIPNetSlice("ACCESS_LOG_TRUSTED_PROXIES", nil)
+	return config, err
+}
+
+// readinessConfig populates configuration for the readiness endpoint from
+// environment variables.
+func readinessConfig(s settings) (badges.ReadinessConfig, error) {
+	config := badges.ReadinessConfig{}
+	var err error
+	config.CheckGitHub, err = s.getBool("READYZ_CHECK_GITHUB", false)
+	if err != nil {
+		return config, err
+	}
+	config.CacheTTL, err = s.getDuration("READYZ_CACHE_TTL", 5*time.Second)
+	return config, err
+}
+
+// handlerConfig populates configuration for the handlers that serve badges
+// from every provider from environment variables.
+func handlerConfig(s settings) (badges.HandlerConfig, error) {
+	config := badges.HandlerConfig{}
+	var err error
+	config.Deadline, err = s.getDuration("BADGE_DEADLINE", 5*time.Second)
+	return config, err
+}
+
+// serviceConfig populates configuration for the badge service from environment
+// variables.
+func serviceConfig(s settings) (badges.ServiceConfig, error) {
+	config := badges.ServiceConfig{
+		Backend: s.get("GITHUB_BACKEND", badges.BackendREST),
+		Token:   s.get("GITHUB_TOKEN", ""),
+	}
+	var err error
+	if config.BaseURL, err = s.getURL("GITHUB_BASE_URL"); err != nil {
+		return config, err
+	}
+	switch config.Backend {
+	case badges.BackendREST:
+	case badges.BackendGraphQL:
+		if config.Token == "" {
+			return config, errors.New(
+				"environment variable GITHUB_TOKEN must be set when GITHUB_BACKEND " +
+					"is \"graphql\"",
+			)
+		}
+	default:
+		return config, errors.Errorf(
+			"value %q for environment variable GITHUB_BACKEND is invalid; valid "+
+				"values are %q and %q",
+			config.Backend,
+			badges.BackendREST,
+			badges.BackendGraphQL,
+		)
+	}
+	config.Concurrency, err = s.getInt("GITHUB_PAGE_CONCURRENCY", 4)
+	if err != nil {
+		return config, err
+	}
+	config.BatchWindow, err = s.getDuration(
+		"GITHUB_GRAPHQL_BATCH_WINDOW",
+		10*time.Millisecond,
+	)
+	return config, err
+}
+
+// circuitBreakerConfig populates configuration for the circuit breaker guarding
+// requests to GitHub from environment variables.
+func circuitBreakerConfig(s settings) (badges.CircuitBreakerConfig, error) {
+	config := badges.CircuitBreakerConfig{}
+	var err error
+	config.FailureThreshold, err = s.getInt("GITHUB_BREAKER_FAILURE_THRESHOLD", 5)
+	if err != nil {
+		return config, err
+	}
+	config.LatencyThreshold, err =
+		s.getDuration("GITHUB_BREAKER_LATENCY_THRESHOLD", 5*time.Second)
+	if err != nil {
+		return config, err
+	}
+	config.OpenDuration, err =
+		s.getDuration("GITHUB_BREAKER_OPEN_DURATION", 30*time.Second)
+	return config, err
+}
+
+// accessConfig populates rules restricting which repositories Badgr serves
+// badges for from environment variables and, optionally, a file.
+func accessConfig(s settings) (badges.AccessConfig, error) {
+	config := badges.AccessConfig{}
+	if rulesFile := s.get("REPO_RULES_FILE", ""); rulesFile != "" {
+		var err error
+		if config, err = badges.LoadAccessRules(rulesFile); err != nil {
+			return config, err
+		}
+	}
+	config.Allow = append(
+		config.Allow,
+		s.getStringSlice("REPO_ALLOWLIST", nil)...,
+	)
+	config.Deny = append(
+		config.Deny,
+		s.getStringSlice("REPO_DENYLIST", nil)...,
+	)
+	return config, config.Validate()
+}
+
+// signingConfig populates configuration for requiring signed badge URLs from
+// environment variables.
+func signingConfig(s settings) (badges.SigningConfig, error) {
+	config := badges.SigningConfig{
+		Key:    []byte(s.get("SIGNING_KEY", "")),
+		Owners: s.getStringSlice("SIGNED_OWNERS", nil),
+	}
+	if len(config.Owners) > 0 && len(config.Key) == 0 {
+		return config, errors.New(
+			"environment variable SIGNING_KEY must be set when SIGNED_OWNERS is set",
+		)
+	}
+	var err error
+	config.VisibilityTTL, err =
+		s.getDuration("SIGNING_VISIBILITY_TTL", 10*time.Minute)
+	return config, err
+}
+
+// aliasConfig populates badge aliases from environment variables. Each alias
+// is given as name=URL, where the URL is the path, and optionally the query
+// string, of a badge served by Badgr.
+func aliasConfig(s settings) (badges.AliasConfig, error) {
+	config := badges.AliasConfig{Aliases: map[string]*url.URL{}}
+	for _, alias := range s.getStringSlice("BADGE_ALIASES", nil) {
+		name, target, ok := strings.Cut(alias, "=")
+		if !ok {
+			return config, errors.Errorf(
+				"alias %q in environment variable BADGE_ALIASES is not of the form "+
+					"name=URL",
+				alias,
+			)
+		}
+		name = strings.TrimSpace(name)
+		if _, exists := config.Aliases[name]; exists {
+			return config, errors.Errorf("alias %q is defined more than once", name)
+		}
+		u, err := url.Parse(strings.TrimSpace(target))
+		if err != nil {
+			return config, errors.Wrapf(err, "error parsing URL of alias %q", name)
+		}
+		config.Aliases[name] = u
+	}
+	return config, config.Validate()
+}
+
+// themeConfig populates the theme badges are rendered in from environment
+// variables. Replacement colors are given as color=replacement.
+func themeConfig(s settings) (badges.Theme, error) {
+	config := badges.Theme{
+		Style:  s.get("BADGE_STYLE", ""),
+		Colors: map[badges.Color]string{},
+	}
+	for _, color := range s.getStringSlice("BADGE_COLORS", nil) {
+		name, replacement, ok := strings.Cut(color, "=")
+		if !ok {
+			return config, errors.Errorf(
+				"color %q in environment variable BADGE_COLORS is not of the form "+
+					"color=replacement",
+				color,
+			)
+		}
+		config.Colors[badges.Color(strings.TrimSpace(name))] =
+			strings.TrimSpace(replacement)
+	}
+	return config, config.Validate()
+}
+
+// apiKeysConfig populates configuration for the API key admin endpoints from
+// environment variables.
+func apiKeysConfig(s settings) badges.APIKeysConfig {
+	return badges.APIKeysConfig{
+		AdminToken: s.get("ADMIN_TOKEN", ""),
+	}
+}
+
+// configuredAPIKeys populates API keys configured by operators, rather than
+// created using the admin endpoints, from the file named by an environment
+// variable, if any.
+func configuredAPIKeys(s settings) (map[string]badges.APIKey, error) {
+	if keysFile := s.get("API_KEYS_FILE", ""); keysFile != "" {
+		return badges.LoadAPIKeys(keysFile)
+	}
+	return nil, nil
+}
+
+// clientRateLimitConfig populates configuration for per-client and per-owner
+// rate limits from environment variables.
+func clientRateLimitConfig(s settings) (badges.ClientRateLimitConfig, error) {
+	config := badges.ClientRateLimitConfig{}
+	var err error
+	config.PerIP, err = clientLimit(s, "CLIENT_RATE_LIMIT_IP")
+	if err != nil {
+		return config, err
+	}
+	config.PerOwner, err = clientLimit(s, "CLIENT_RATE_LIMIT_OWNER")
+	return config, err
+}
+
+// clientLimit populates a single client rate limit from the environment
+// variables with the specified prefix.
+func clientLimit(s settings, prefix string) (badges.ClientLimit, error) {
+	limit := badges.ClientLimit{}
+	rateVar := prefix + "_RATE"
+	rateStr := s.get(rateVar, "0")
+	var err error
+	limit.Rate, err = strconv.ParseFloat(rateStr, 64)
+	if err != nil || limit.Rate < 0 {
+		return limit, errors.Errorf(
+			"value %q for environment variable %s was not parsable as a "+
+				"non-negative number",
+			rateStr,
+			rateVar,
+		)
+	}
+	burstVar := prefix + "_BURST"
+	limit.Burst, err = s.getInt(burstVar, 10)
+	if err != nil {
+		return limit, err
+	}
+	if limit.Rate > 0 && limit.Burst < 1 {
+		return limit, errors.Errorf(
+			"value for environment variable %s must be positive",
+			burstVar,
+		)
+	}
+	return limit, nil
+}
+
+// prewarmConfig populates configuration for pre-warming popular badges from
+// environment variables.
+func prewarmConfig(s settings) (badges.PrewarmConfig, error) {
+	config := badges.PrewarmConfig{}
+	var err error
+	config.TopN, err = s.getInt("PREWARM_TOP_N", 0)
+	if err != nil {
+		return config, err
+	}
+	config.Interval, err = s.getDuration("PREWARM_INTERVAL", 10*time.Second)
+	if err != nil {
+		return config, err
+	}
+	if config.Interval <= 0 {
+		return config, errors.New(
+			"value for environment variable PREWARM_INTERVAL must be positive",
+		)
+	}
+	config.Lead, err = s.getDuration("PREWARM_LEAD", 20*time.Second)
+	if err != nil {
+		return config, err
+	}
+	// Otherwise, badges could expire from the warm cache between looks
+	if config.Interval >= config.Lead {
+		return config, errors.New(
+			"value for environment variable PREWARM_INTERVAL must be shorter " +
+				"than the value for environment variable PREWARM_LEAD",
+		)
+	}
+	budgetShareStr := s.get("PREWARM_BUDGET_SHARE", "0.2")
+	config.BudgetShare, err = strconv.ParseFloat(budgetShareStr, 64)
+	if err != nil || config.BudgetShare < 0 || config.BudgetShare > 1 {
+		return config, errors.Errorf(
+			"value %q for environment variable PREWARM_BUDGET_SHARE was not "+
+				"parsable as a number between 0 and 1",
+			budgetShareStr,
+		)
+	}
+	return config, nil
+}
+
 // serverConfig populates configuration for the HTTP/S server from environment
 // variables.
-func serverConfig() (http.ServerConfig, error) {
+func serverConfig(s settings) (http.ServerConfig, error) {
 	config := http.ServerConfig{}
 	var err error
-	config.Port, err = os.GetIntFromEnvVar("PORT", 8080)
+	config.Port, err = s.getInt("PORT", 8080)
 	if err != nil {
 		return config, err
 	}
-	config.TLSEnabled, err = os.GetBoolFromEnvVar("TLS_ENABLED", false)
+	config.TLSEnabled, err = s.getBool("TLS_ENABLED", false)
 	if err != nil {
 		return config, err
 	}
 	if config.TLSEnabled {
-		config.TLSCertPath, err = os.GetRequiredEnvVar("TLS_CERT_PATH")
+		config.TLSCertPath, err = s.getRequired("TLS_CERT_PATH")
 		if err != nil {
 			return config, err
 		}
-		config.TLSKeyPath, err = os.GetRequiredEnvVar("TLS_KEY_PATH")
+		config.TLSKeyPath, err = s.getRequired("TLS_KEY_PATH")
 		if err != nil {
 			return config, err
 		}
@@ -33,29 +426,93 @@ func serverConfig() (http.ServerConfig, error) {
 	return config, nil
 }
 
-func redisCacheConfig() (redis.CacheConfig, error) {
+// metricsServerConfig populates configuration for the HTTP server that exposes
+// metrics from environment variables. A zero port indicates that metrics should
+// be exposed by the main HTTP/S server instead.
+func metricsServerConfig(s settings) (http.ServerConfig, error) {
+	config := http.ServerConfig{}
+	var err error
+	config.Port, err = s.getInt("METRICS_PORT", 0)
+	return config, err
+}
+
+// redisCacheConfig populates configuration for the Redis-based cache from
+// environment variables.
+func redisCacheConfig(s settings) (redis.CacheConfig, error) {
 	config := redis.CacheConfig{}
 	var err error
-	config.RedisHost, err = os.GetRequiredEnvVar("REDIS_HOST")
+	config.RedisHost, err = s.getRequired("REDIS_HOST")
+	if err != nil {
+		return config, err
+	}
+	config.RedisPort, err = s.getInt("REDIS_PORT", 6379)
+	if err != nil {
+		return config, err
+	}
+	config.RedisPassword, err = s.getRequired("REDIS_PASSWORD")
 	if err != nil {
 		return config, err
 	}
-	config.RedisPort, err = os.GetIntFromEnvVar("REDIS_PORT", 6379)
+	config.RedisDB, err = s.getInt("REDIS_DB", 0)
 	if err != nil {
 		return config, err
 	}
-	config.RedisPassword, err = os.GetRequiredEnvVar("REDIS_PASSWORD")
+	config.RedisEnableTLS, err = s.getBool("REDIS_ENABLE_TLS", false)
 	if err != nil {
 		return config, err
 	}
-	config.RedisDB, err = os.GetIntFromEnvVar("REDIS_DB", 0)
+	config.RedisPrefix = s.get("REDIS_PREFIX", "")
+	config.WarmTTL, err = s.getDuration("CACHE_WARM_TTL", time.Minute)
 	if err != nil {
 		return config, err
 	}
-	config.RedisEnableTLS, err = os.GetBoolFromEnvVar("REDIS_ENABLE_TLS", false)
+	config.ColdTTL, err = s.getDuration("CACHE_COLD_TTL", 24*time.Hour)
 	if err != nil {
 		return config, err
 	}
-	config.RedisPrefix = os.GetEnvVar("REDIS_PREFIX", "")
+	if config.WarmTTL <= 0 || config.ColdTTL <= 0 {
+		return config, errors.New(
+			"values for environment variables CACHE_WARM_TTL and CACHE_COLD_TTL " +
+				"must be positive",
+		)
+	}
 	return config, nil
 }
+
+// popularityCapacityFactor is how many times as many badges as are kept warm
+// have their popularity tallied.
+const popularityCapacityFactor = 10
+
+// popularityConfig populates configuration for the Redis-based tracking of
+// badge popularity from environment variables.
+func popularityConfig(s settings) (redis.PopularityConfig, error) {
+	config := redis.PopularityConfig{}
+	var err error
+	config.Window, err = s.getDuration("PREWARM_POPULARITY_WINDOW", time.Hour)
+	if err != nil {
+		return config, err
+	}
+	if config.Window <= 0 {
+		return config, errors.New(
+			"value for environment variable PREWARM_POPULARITY_WINDOW must be " +
+				"positive",
+		)
+	}
+	// Tally several times as many badges as are kept warm, so that badges only
+	// beginning to gain popularity aren't crowded out by those that were
+	// recorded first
+	topN, err := s.getInt("PREWARM_TOP_N", 0)
+	config.Capacity = popularityCapacityFactor * topN
+	return config, err
+}
+
+// tracingConfig populates configuration for tracing from environment
+// variables.
+func tracingConfig(s settings) (tracing.Config, error) {
+	config := tracing.Config{}
+	var err error
+	config.Exporter = s.get("TRACING_EXPORTER", tracing.ExporterNone)
+	config.OTLPEndpoint = s.get("TRACING_OTLP_ENDPOINT", "")
+	config.OTLPInsecure, err = s.getBool("TRACING_OTLP_INSECURE", false)
+	return config, err
+}
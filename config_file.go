@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configFilePollInterval is how often the config file is checked for changes.
+const configFilePollInterval = 5 * time.Second
+
+// settingKind describes the type of value a setting in the config file takes.
+type settingKind int
+
+const (
+	settingString settingKind = iota
+	settingInt
+	settingFloat
+	settingBool
+	settingDuration
+	settingList
+	// settingMap is a mapping of names to strings, such as badge aliases
+	settingMap
+)
+
+// setting describes a setting that may appear in the config file.
+type setting struct {
+	// envVar is the environment variable the setting stands in for
+	envVar string
+	kind   settingKind
+}
+
+// configFileSettings maps the dotted path of every setting that may appear in
+// the config file to the environment variable it stands in for. Every setting
+// is validated against its kind when the file is loaded and then rendered as
+// the value of that environment variable, so the rest of Badgr's configuration
+// is populated exactly as if the environment variable had been set.
+var configFileSettings = map[string]setting{
+	"logging.level": {
+		envVar: "LOG_LEVEL",
+		kind:   settingString,
+	},
+	"accessLog.sampleRate": {
+		envVar: "ACCESS_LOG_SAMPLE_RATE",
+		kind:   settingFloat,
+	},
+	"accessLog.logHealthz": {
+		envVar: "ACCESS_LOG_HEALTHZ",
+		kind:   settingBool,
+	},
+	"accessLog.trustedProxies": {
+		envVar: "ACCESS_LOG_TRUSTED_PROXIES",
+		kind:   settingList,
+	},
+	"server.port": {
+		envVar: "PORT",
+		kind:   settingInt,
+	},
+	"server.tls.enabled": {
+		envVar: "TLS_ENABLED",
+		kind:   settingBool,
+	},
+	"server.tls.certPath": {
+		envVar: "TLS_CERT_PATH",
+		kind:   settingString,
+	},
+	"server.tls.keyPath": {
+		envVar: "TLS_KEY_PATH",
+		kind:   settingString,
+	},
+	"metrics.port": {
+		envVar: "METRICS_PORT",
+		kind:   settingInt,
+	},
+	"readiness.checkGitHub": {
+		envVar: "READYZ_CHECK_GITHUB",
+		kind:   settingBool,
+	},
+	"readiness.cacheTTL": {
+		envVar: "READYZ_CACHE_TTL",
+		kind:   settingDuration,
+	},
+	"cache.redis.host": {
+		envVar: "REDIS_HOST",
+		kind:   settingString,
+	},
+	"cache.redis.port": {
+		envVar: "REDIS_PORT",
+		kind:   settingInt,
+	},
+	"cache.redis.password": {
+		envVar: "REDIS_PASSWORD",
+		kind:   settingString,
+	},
+	"cache.redis.db": {
+		envVar: "REDIS_DB",
+		kind:   settingInt,
+	},
+	"cache.redis.enableTLS": {
+		envVar: "REDIS_ENABLE_TLS",
+		kind:   settingBool,
+	},
+	"cache.redis.prefix": {
+		envVar: "REDIS_PREFIX",
+		kind:   settingString,
+	},
+	"cache.warmTTL": {
+		envVar: "CACHE_WARM_TTL",
+		kind:   settingDuration,
+	},
+	"cache.coldTTL": {
+		envVar: "CACHE_COLD_TTL",
+		kind:   settingDuration,
+	},
+	"github.baseURL": {
+		envVar: "GITHUB_BASE_URL",
+		kind:   settingString,
+	},
+	"github.backend": {
+		envVar: "GITHUB_BACKEND",
+		kind:   settingString,
+	},
+	"github.token": {
+		envVar: "GITHUB_TOKEN",
+		kind:   settingString,
+	},
+	"github.deadline": {
+		envVar: "BADGE_DEADLINE",
+		kind:   settingDuration,
+	},
+	"github.pageConcurrency": {
+		envVar: "GITHUB_PAGE_CONCURRENCY",
+		kind:   settingInt,
+	},
+	"github.graphqlBatchWindow": {
+		envVar: "GITHUB_GRAPHQL_BATCH_WINDOW",
+		kind:   settingDuration,
+	},
+	"github.breaker.failureThreshold": {
+		envVar: "GITHUB_BREAKER_FAILURE_THRESHOLD",
+		kind:   settingInt,
+	},
+	"github.breaker.latencyThreshold": {
+		envVar: "GITHUB_BREAKER_LATENCY_THRESHOLD",
+		kind:   settingDuration,
+	},
+	"github.breaker.openDuration": {
+		envVar: "GITHUB_BREAKER_OPEN_DURATION",
+		kind:   settingDuration,
+	},
+	"brigade.apiAddress": {
+		envVar: "BRIGADE_API_ADDRESS",
+		kind:   settingString,
+	},
+	"brigade.apiToken": {
+		envVar: "BRIGADE_API_TOKEN",
+		kind:   settingString,
+	},
+	"gitea.baseURL": {
+		envVar: "GITEA_BASE_URL",
+		kind:   settingString,
+	},
+	"gitea.token": {
+		envVar: "GITEA_TOKEN",
+		kind:   settingString,
+	},
+	"gitlab.baseURL": {
+		envVar: "GITLAB_BASE_URL",
+		kind:   settingString,
+	},
+	"gitlab.token": {
+		envVar: "GITLAB_TOKEN",
+		kind:   settingString,
+	},
+	"jenkins.baseURL": {
+		envVar: "JENKINS_BASE_URL",
+		kind:   settingString,
+	},
+	"jenkins.username": {
+		envVar: "JENKINS_USERNAME",
+		kind:   settingString,
+	},
+	"jenkins.apiToken": {
+		envVar: "JENKINS_API_TOKEN",
+		kind:   settingString,
+	},
+	"repoAccess.rulesFile": {
+		envVar: "REPO_RULES_FILE",
+		kind:   settingString,
+	},
+	"repoAccess.allow": {
+		envVar: "REPO_ALLOWLIST",
+		kind:   settingList,
+	},
+	"repoAccess.deny": {
+		envVar: "REPO_DENYLIST",
+		kind:   settingList,
+	},
+	"aliases": {
+		envVar: "BADGE_ALIASES",
+		kind:   settingMap,
+	},
+	"theme.style": {
+		envVar: "BADGE_STYLE",
+		kind:   settingString,
+	},
+	"theme.colors": {
+		envVar: "BADGE_COLORS",
+		kind:   settingMap,
+	},
+	"signing.key": {
+		envVar: "SIGNING_KEY",
+		kind:   settingString,
+	},
+	"signing.owners": {
+		envVar: "SIGNED_OWNERS",
+		kind:   settingList,
+	},
+	"signing.visibilityTTL": {
+		envVar: "SIGNING_VISIBILITY_TTL",
+		kind:   settingDuration,
+	},
+	"apiKeys.file": {
+		envVar: "API_KEYS_FILE",
+		kind:   settingString,
+	},
+	"adminToken": {
+		envVar: "ADMIN_TOKEN",
+		kind:   settingString,
+	},
+	"clientRateLimit.perIP.rate": {
+		envVar: "CLIENT_RATE_LIMIT_IP_RATE",
+		kind:   settingFloat,
+	},
+	"clientRateLimit.perIP.burst": {
+		envVar: "CLIENT_RATE_LIMIT_IP_BURST",
+		kind:   settingInt,
+	},
+	"clientRateLimit.perOwner.rate": {
+		envVar: "CLIENT_RATE_LIMIT_OWNER_RATE",
+		kind:   settingFloat,
+	},
+	"clientRateLimit.perOwner.burst": {
+		envVar: "CLIENT_RATE_LIMIT_OWNER_BURST",
+		kind:   settingInt,
+	},
+	"prewarm.topN": {
+		envVar: "PREWARM_TOP_N",
+		kind:   settingInt,
+	},
+	"prewarm.interval": {
+		envVar: "PREWARM_INTERVAL",
+		kind:   settingDuration,
+	},
+	"prewarm.lead": {
+		envVar: "PREWARM_LEAD",
+		kind:   settingDuration,
+	},
+	"prewarm.budgetShare": {
+		envVar: "PREWARM_BUDGET_SHARE",
+		kind:   settingFloat,
+	},
+	"prewarm.popularityWindow": {
+		envVar: "PREWARM_POPULARITY_WINDOW",
+		kind:   settingDuration,
+	},
+	"tracing.exporter": {
+		envVar: "TRACING_EXPORTER",
+		kind:   settingString,
+	},
+	"tracing.otlpEndpoint": {
+		envVar: "TRACING_OTLP_ENDPOINT",
+		kind:   settingString,
+	},
+	"tracing.otlpInsecure": {
+		envVar: "TRACING_OTLP_INSECURE",
+		kind:   settingBool,
+	},
+}
+
+// parseConfigFile parses the contents of a YAML or TOML config file, as
+// determined by the file's extension, and returns the value of every setting it
+// contains, keyed by the environment variable each stands in for. Every problem
+// with the file is reported, not just the first.
+func parseConfigFile(filename string, data []byte) (map[string]string, error) {
+	doc := map[string]interface{}{}
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		return nil, errors.Errorf(
+			"config file %q has an unrecognized extension; expected .yaml, .yml, "+
+				".json, or .toml",
+			filename,
+		)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing config file %q", filename)
+	}
+	values := map[string]interface{}{}
+	flattenConfig("", doc, values)
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	env := map[string]string{}
+	var problems []string
+	for _, path := range paths {
+		s, ok := configFileSettings[path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", path))
+			continue
+		}
+		if values[path] == nil {
+			continue // Treat an empty setting as unset
+		}
+		value, renderErr := renderSetting(s.kind, values[path])
+		if renderErr != nil {
+			problems = append(
+				problems,
+				fmt.Sprintf("setting %q: %s", path, renderErr),
+			)
+			continue
+		}
+		env[s.envVar] = value
+	}
+	if len(problems) > 0 {
+		return nil, errors.Errorf(
+			"invalid config file %q: %s",
+			filename,
+			strings.Join(problems, "; "),
+		)
+	}
+	return env, nil
+}
+
+// flattenConfig records every leaf of the provided, possibly nested, map in
+// the provided values, keyed by its dotted path.
+func flattenConfig(
+	prefix string,
+	doc map[string]interface{},
+	values map[string]interface{},
+) {
+	for key, value := range doc {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		// Maps are flattened unless they are the value of a setting
+		nested, ok := value.(map[string]interface{})
+		if ok && configFileSettings[path].kind != settingMap {
+			flattenConfig(path, nested, values)
+			continue
+		}
+		values[path] = value
+	}
+}
+
+// renderSetting validates the provided value against the provided kind and
+// renders it as the value of an environment variable.
+func renderSetting(kind settingKind, value interface{}) (string, error) {
+	switch kind {
+	case settingInt:
+		switch v := value.(type) {
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		}
+		return "", errors.New("expected an integer")
+	case settingFloat:
+		switch v := value.(type) {
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return "", errors.New("expected a number")
+	case settingBool:
+		if v, ok := value.(bool); ok {
+			return strconv.FormatBool(v), nil
+		}
+		return "", errors.New("expected true or false")
+	case settingDuration:
+		if v, ok := value.(string); ok {
+			if _, err := time.ParseDuration(v); err == nil {
+				return v, nil
+			}
+		}
+		return "", errors.New("expected a duration, such as \"30s\" or \"1h\"")
+	case settingList:
+		items, ok := value.([]interface{})
+		if !ok {
+			return "", errors.New("expected a list")
+		}
+		strs := make([]string, len(items))
+		for i, item := range items {
+			str, ok := item.(string)
+			if !ok || strings.Contains(str, ",") {
+				return "", errors.New("expected a list of strings without commas")
+			}
+			strs[i] = str
+		}
+		return strings.Join(strs, ","), nil
+	case settingMap:
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return "", errors.New("expected a mapping")
+		}
+		names := make([]string, 0, len(entries))
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		strs := make([]string, len(names))
+		for i, name := range names {
+			str, ok := entries[name].(string)
+			if !ok || strings.ContainsAny(name, ",=") ||
+				strings.Contains(str, ",") {
+				return "", errors.New(
+					"expected a mapping of names to strings without commas",
+				)
+			}
+			strs[i] = name + "=" + str
+		}
+		return strings.Join(strs, ","), nil
+	default:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return "", errors.New("expected a string")
+	}
+}
+
+// configFile reads settings from a config file and keeps them up to date as the
+// file changes.
+type configFile struct {
+	filename string
+	mu       sync.Mutex
+	loaded   bool
+	sum      [sha256.Size]byte
+}
+
+// newConfigFile returns a configFile for the specified file. The file is not
+// loaded until load is called.
+func newConfigFile(filename string) *configFile {
+	return &configFile{filename: filename}
+}
+
+// load reads the config file and, if it is valid, passes its settings to the
+// provided function to be applied. Unless force is true, nothing is applied if
+// the file's contents are unchanged since they were last applied successfully.
+// If the function returns an error, the contents are not considered applied,
+// so they will be applied again the next time the file is loaded. A bool is
+// returned indicating whether settings were applied.
+func (c *configFile) load(
+	force bool,
+	apply func(settings) error,
+) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := os.ReadFile(c.filename)
+	if err != nil {
+		return false, errors.Wrapf(
+			err,
+			"error reading config file %q",
+			c.filename,
+		)
+	}
+	sum := sha256.Sum256(data)
+	if c.loaded && sum == c.sum && !force {
+		return false, nil
+	}
+	file, err := parseConfigFile(c.filename, data)
+	if err != nil {
+		return false, err
+	}
+	if err = apply(settings{file: file}); err != nil {
+		return false, err
+	}
+	c.sum = sum
+	c.loaded = true
+	return true, nil
+}
+
+// watch reloads the config file whenever the process receives SIGHUP and
+// whenever the file's contents change, until the provided context is
+// canceled. The provided function is called to apply the settings from each
+// reload. SIGHUP forces a reload even if the file is unchanged so that files it
+// refers to, such as the access rules file, can be reloaded too.
+func (c *configFile) watch(
+	ctx context.Context,
+	onReload func(settings) error,
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(configFilePollInterval)
+	defer ticker.Stop()
+	for {
+		var force bool
+		select {
+		case <-hup:
+			force = true
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		reloaded, err := c.load(force, onReload)
+		if err != nil {
+			slog.Warn(
+				"error reloading configuration; retaining previous configuration",
+				"file", c.filename,
+				"error", err,
+			)
+			continue
+		}
+		if reloaded {
+			slog.Info("reloaded configuration", "file", c.filename)
+		}
+	}
+}
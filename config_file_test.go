@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	stdos "os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		filename   string
+		data       string
+		assertions func(map[string]string, error)
+	}{
+		{
+			name:     "unrecognized extension",
+			filename: "config.ini",
+			assertions: func(_ map[string]string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unrecognized extension")
+			},
+		},
+		{
+			name:     "malformed YAML",
+			filename: "config.yaml",
+			data:     "server: [",
+			assertions: func(_ map[string]string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing config file")
+			},
+		},
+		{
+			name:     "invalid settings",
+			filename: "config.yaml",
+			data: `
+server:
+  prot: 8080
+cache:
+  warmTTL: soon
+repoAccess:
+  allow: brigadecore/*
+`,
+			assertions: func(_ map[string]string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `unknown setting "server.prot"`)
+				require.Contains(
+					t,
+					err.Error(),
+					`setting "cache.warmTTL": expected a duration`,
+				)
+				require.Contains(
+					t,
+					err.Error(),
+					`setting "repoAccess.allow": expected a list`,
+				)
+			},
+		},
+		{
+			name:     "valid YAML",
+			filename: "config.yml",
+			data: `
+logging:
+  level: debug
+server:
+  port: 8080
+  tls:
+    enabled: true
+cache:
+  warmTTL: 2m
+  redis:
+    prefix:
+clientRateLimit:
+  perIP:
+    rate: 0.5
+repoAccess:
+  allow:
+  - brigadecore/*
+  - krancour/*
+aliases:
+  foo: /v1/github/checks/krancour/foo/badge.svg
+  bar: /v1/github/checks/krancour/bar/badge.svg?branch=main
+`,
+			assertions: func(env map[string]string, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]string{
+						"LOG_LEVEL":                 "debug",
+						"PORT":                      "8080",
+						"TLS_ENABLED":               "true",
+						"CACHE_WARM_TTL":            "2m",
+						"CLIENT_RATE_LIMIT_IP_RATE": "0.5",
+						"REPO_ALLOWLIST":            "brigadecore/*,krancour/*",
+						"BADGE_ALIASES": "bar=/v1/github/checks/krancour/bar/badge.svg" +
+							"?branch=main,foo=/v1/github/checks/krancour/foo/badge.svg",
+					},
+					env,
+				)
+			},
+		},
+		{
+			name:     "valid TOML",
+			filename: "config.toml",
+			data: `
+[server]
+port = 8080
+
+[clientRateLimit.perOwner]
+rate = 2
+burst = 20
+
+[repoAccess]
+deny = ["*/junk"]
+`,
+			assertions: func(env map[string]string, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]string{
+						"PORT":                          "8080",
+						"CLIENT_RATE_LIMIT_OWNER_RATE":  "2",
+						"CLIENT_RATE_LIMIT_OWNER_BURST": "20",
+						"REPO_DENYLIST":                 "*/junk",
+					},
+					env,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				parseConfigFile(testCase.filename, []byte(testCase.data)),
+			)
+		})
+	}
+}
+
+func TestRenderSetting(t *testing.T) {
+	testCases := []struct {
+		name          string
+		kind          settingKind
+		value         interface{}
+		expectedValue string
+		expectedErr   string
+	}{
+		{
+			name:          "string",
+			kind:          settingString,
+			value:         "foo",
+			expectedValue: "foo",
+		},
+		{
+			name:        "string mismatch",
+			kind:        settingString,
+			value:       42,
+			expectedErr: "expected a string",
+		},
+		{
+			name:          "int",
+			kind:          settingInt,
+			value:         int64(42),
+			expectedValue: "42",
+		},
+		{
+			name:        "int mismatch",
+			kind:        settingInt,
+			value:       4.2,
+			expectedErr: "expected an integer",
+		},
+		{
+			name:          "float",
+			kind:          settingFloat,
+			value:         0.25,
+			expectedValue: "0.25",
+		},
+		{
+			name:        "float mismatch",
+			kind:        settingFloat,
+			value:       "foo",
+			expectedErr: "expected a number",
+		},
+		{
+			name:          "bool",
+			kind:          settingBool,
+			value:         true,
+			expectedValue: "true",
+		},
+		{
+			name:        "bool mismatch",
+			kind:        settingBool,
+			value:       "yes please",
+			expectedErr: "expected true or false",
+		},
+		{
+			name:          "duration",
+			kind:          settingDuration,
+			value:         "90s",
+			expectedValue: "90s",
+		},
+		{
+			name:        "duration mismatch",
+			kind:        settingDuration,
+			value:       90,
+			expectedErr: "expected a duration",
+		},
+		{
+			name:          "list",
+			kind:          settingList,
+			value:         []interface{}{"a", "b"},
+			expectedValue: "a,b",
+		},
+		{
+			name:        "list item with comma",
+			kind:        settingList,
+			value:       []interface{}{"a,b"},
+			expectedErr: "without commas",
+		},
+		{
+			name: "map",
+			kind: settingMap,
+			value: map[string]interface{}{
+				"b": "/v1/alias/b",
+				"a": "/v1/alias/a",
+			},
+			expectedValue: "a=/v1/alias/a,b=/v1/alias/b",
+		},
+		{
+			name:        "map mismatch",
+			kind:        settingMap,
+			value:       []interface{}{"a"},
+			expectedErr: "expected a mapping",
+		},
+		{
+			name:        "map value with comma",
+			kind:        settingMap,
+			value:       map[string]interface{}{"a": "b,c"},
+			expectedErr: "without commas",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			value, err := renderSetting(testCase.kind, testCase.value)
+			if testCase.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), testCase.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedValue, value)
+		})
+	}
+}
+
+func TestConfigFileLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(data string) {
+		require.NoError(t, stdos.WriteFile(filename, []byte(data), 0600))
+	}
+	c := newConfigFile(filename)
+	var applyErr error
+	load := func(force bool) (settings, bool, error) {
+		var s settings
+		loaded, err := c.load(force, func(fileSettings settings) error {
+			s = fileSettings
+			return applyErr
+		})
+		return s, loaded, err
+	}
+
+	_, _, err := load(false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error reading config file")
+
+	writeConfig("cache: {redis: {prefix: file, db: 2}}\nserver: {port: 8080}\n")
+	s, loaded, err := load(false)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.Equal(
+		t,
+		map[string]string{
+			"REDIS_PREFIX": "file",
+			"REDIS_DB":     "2",
+			"PORT":         "8080",
+		},
+		s.file,
+	)
+
+	// Unchanged
+	_, loaded, err = load(false)
+	require.NoError(t, err)
+	require.False(t, loaded)
+
+	// Unchanged, but forced
+	s, loaded, err = load(true)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.Equal(t, "8080", s.file["PORT"])
+
+	// Invalid
+	writeConfig("server: {port: eighty}\n")
+	_, _, err = load(false)
+	require.Error(t, err)
+
+	// Valid, but refused when applied
+	writeConfig("server: {port: 9090}\n")
+	applyErr = errors.New("something went wrong")
+	_, loaded, err = load(false)
+	require.Error(t, err)
+	require.False(t, loaded)
+
+	// Unchanged since it was refused, so it should be applied again
+	applyErr = nil
+	s, loaded, err = load(false)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.Equal(t, "9090", s.file["PORT"])
+
+	// A setting removed from the file should no longer be set
+	writeConfig("cache: {redis: {prefix: file, db: 3}}\n")
+	s, loaded, err = load(false)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.Equal(
+		t,
+		map[string]string{"REDIS_PREFIX": "file", "REDIS_DB": "3"},
+		s.file,
+	)
+}
@@ -2,9 +2,17 @@ package main
 
 // nolint: lll
 import (
+	"log/slog"
+	stdos "os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brigadecore/badgr/internal/badges"
 	"github.com/brigadecore/badgr/internal/badges/redis"
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/brigadecore/badgr/internal/tracing"
 	"github.com/brigadecore/brigade-foundations/http"
 	"github.com/stretchr/testify/require"
 )
@@ -14,71 +22,184 @@ import (
 // test functions uses a series of test cases that cumulatively build upon one
 // another.
 
-func TestServerConfig(t *testing.T) {
+func TestLoggingConfig(t *testing.T) {
 	testCases := []struct {
 		name       string
 		setup      func()
-		assertions func(http.ServerConfig, error)
+		assertions func(logging.Config, error)
 	}{
 		{
-			name: "PORT not an int",
+			name: "LOG_LEVEL not set",
+			assertions: func(config logging.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(t, logging.Config{Level: slog.LevelInfo}, config)
+			},
+		},
+		{
+			name: "LOG_LEVEL not a log level",
 			setup: func() {
-				t.Setenv("PORT", "foo")
+				t.Setenv("LOG_LEVEL", "foo")
 			},
-			assertions: func(_ http.ServerConfig, err error) {
+			assertions: func(_ logging.Config, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "was not parsable as an int")
-				require.Contains(t, err.Error(), "PORT")
+				require.Contains(t, err.Error(), "was not parsable as a log level")
+				require.Contains(t, err.Error(), "LOG_LEVEL")
 			},
 		},
 		{
-			name: "TLS_ENABLED not a bool",
+			name: "success",
 			setup: func() {
-				t.Setenv("PORT", "8080")
-				t.Setenv("TLS_ENABLED", "nope")
+				t.Setenv("LOG_LEVEL", "debug")
 			},
-			assertions: func(_ http.ServerConfig, err error) {
+			assertions: func(config logging.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(t, logging.Config{Level: slog.LevelDebug}, config)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := loggingConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAccessLogConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(logging.AccessLogConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config logging.AccessLogConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, logging.AccessLogConfig{SampleRate: 1}, config)
+			},
+		},
+		{
+			name: "ACCESS_LOG_SAMPLE_RATE not a number",
+			setup: func() {
+				t.Setenv("ACCESS_LOG_SAMPLE_RATE", "foo")
+			},
+			assertions: func(_ logging.AccessLogConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "ACCESS_LOG_SAMPLE_RATE")
+			},
+		},
+		{
+			name: "ACCESS_LOG_SAMPLE_RATE out of range",
+			setup: func() {
+				t.Setenv("ACCESS_LOG_SAMPLE_RATE", "1.5")
+			},
+			assertions: func(_ logging.AccessLogConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "between 0 and 1")
+			},
+		},
+		{
+			name: "ACCESS_LOG_HEALTHZ not a bool",
+			setup: func() {
+				t.Setenv("ACCESS_LOG_SAMPLE_RATE", "0.25")
+				t.Setenv("ACCESS_LOG_HEALTHZ", "foo")
+			},
+			assertions: func(_ logging.AccessLogConfig, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "was not parsable as a bool")
-				require.Contains(t, err.Error(), "TLS_ENABLED")
+				require.Contains(t, err.Error(), "ACCESS_LOG_HEALTHZ")
 			},
 		},
 		{
-			name: "TLS_CERT_PATH required but not set",
+			name: "ACCESS_LOG_TRUSTED_PROXIES not CIDRs",
 			setup: func() {
-				t.Setenv("TLS_ENABLED", "true")
+				t.Setenv("ACCESS_LOG_HEALTHZ", "true")
+				t.Setenv("ACCESS_LOG_TRUSTED_PROXIES", "foo")
 			},
-			assertions: func(_ http.ServerConfig, err error) {
+			assertions: func(_ logging.AccessLogConfig, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "value not found for")
-				require.Contains(t, err.Error(), "TLS_CERT_PATH")
+				require.Contains(t, err.Error(), "ACCESS_LOG_TRUSTED_PROXIES")
 			},
 		},
 		{
-			name: "TLS_KEY_PATH required but not set",
+			name: "success",
 			setup: func() {
-				t.Setenv("TLS_CERT_PATH", "/var/ssl/cert")
+				t.Setenv("ACCESS_LOG_TRUSTED_PROXIES", "10.0.0.0/8")
 			},
-			assertions: func(_ http.ServerConfig, err error) {
+			assertions: func(config logging.AccessLogConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 0.25, config.SampleRate)
+				require.True(t, config.LogHealthz)
+				require.Len(t, config.TrustedProxies, 1)
+				require.Equal(t, "10.0.0.0/8", config.TrustedProxies[0].String())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := accessLogConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestReadinessConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.ReadinessConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.ReadinessConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.ReadinessConfig{CacheTTL: 5 * time.Second},
+					config,
+				)
+			},
+		},
+		{
+			name: "READYZ_CHECK_GITHUB not a bool",
+			setup: func() {
+				t.Setenv("READYZ_CHECK_GITHUB", "foo")
+			},
+			assertions: func(_ badges.ReadinessConfig, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "value not found for")
-				require.Contains(t, err.Error(), "TLS_KEY_PATH")
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "READYZ_CHECK_GITHUB")
+			},
+		},
+		{
+			name: "READYZ_CACHE_TTL not a duration",
+			setup: func() {
+				t.Setenv("READYZ_CHECK_GITHUB", "true")
+				t.Setenv("READYZ_CACHE_TTL", "foo")
+			},
+			assertions: func(_ badges.ReadinessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "READYZ_CACHE_TTL")
 			},
 		},
 		{
 			name: "success",
 			setup: func() {
-				t.Setenv("TLS_KEY_PATH", "/var/ssl/key")
+				t.Setenv("READYZ_CACHE_TTL", "10s")
 			},
-			assertions: func(config http.ServerConfig, err error) {
+			assertions: func(config badges.ReadinessConfig, err error) {
 				require.NoError(t, err)
 				require.Equal(
 					t,
-					http.ServerConfig{
-						Port:        8080,
-						TLSEnabled:  true,
-						TLSCertPath: "/var/ssl/cert",
-						TLSKeyPath:  "/var/ssl/key",
+					badges.ReadinessConfig{
+						CheckGitHub: true,
+						CacheTTL:    10 * time.Second,
 					},
 					config,
 				)
@@ -87,99 +208,241 @@ func TestServerConfig(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			testCase.setup()
-			config, err := serverConfig()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := readinessConfig(settings{})
 			testCase.assertions(config, err)
 		})
 	}
 }
 
-func TestRedisCacheConfig(t *testing.T) {
+func TestHandlerConfig(t *testing.T) {
 	testCases := []struct {
 		name       string
 		setup      func()
-		assertions func(redis.CacheConfig, error)
+		assertions func(badges.HandlerConfig, error)
 	}{
 		{
-			name: "REDIS_HOST not set",
-			assertions: func(_ redis.CacheConfig, err error) {
+			name: "nothing set",
+			assertions: func(config badges.HandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.HandlerConfig{Deadline: 5 * time.Second},
+					config,
+				)
+			},
+		},
+		{
+			name: "BADGE_DEADLINE not a duration",
+			setup: func() {
+				t.Setenv("BADGE_DEADLINE", "foo")
+			},
+			assertions: func(_ badges.HandlerConfig, err error) {
 				require.Error(t, err)
-				require.Contains(
+				require.Contains(t, err.Error(), "BADGE_DEADLINE")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("BADGE_DEADLINE", "3s")
+			},
+			assertions: func(config badges.HandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
 					t,
-					err.Error(),
-					"value not found for required environment variable",
+					badges.HandlerConfig{Deadline: 3 * time.Second},
+					config,
 				)
-				require.Contains(t, err.Error(), "REDIS_HOST")
 			},
 		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			testCase.assertions(handlerConfig(settings{}))
+		})
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.ServiceConfig, error)
+	}{
 		{
-			name: "REDIS_PORT not an int",
+			name: "nothing set",
+			assertions: func(config badges.ServiceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.ServiceConfig{
+						Backend:     badges.BackendREST,
+						Concurrency: 4,
+						BatchWindow: 10 * time.Millisecond,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "GITHUB_BASE_URL not absolute",
 			setup: func() {
-				t.Setenv("REDIS_HOST", "localhost")
-				t.Setenv("REDIS_PORT", "foo")
+				t.Setenv("GITHUB_BASE_URL", "github.example.com")
 			},
-			assertions: func(_ redis.CacheConfig, err error) {
+			assertions: func(_ badges.ServiceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "not an absolute URL")
+			},
+		},
+		{
+			name: "GITHUB_BACKEND invalid",
+			setup: func() {
+				t.Setenv("GITHUB_BASE_URL", "https://github.example.com/api/v3/")
+				t.Setenv("GITHUB_BACKEND", "foo")
+			},
+			assertions: func(_ badges.ServiceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "GITHUB_BACKEND is invalid")
+			},
+		},
+		{
+			name: "GITHUB_BACKEND graphql without GITHUB_TOKEN",
+			setup: func() {
+				t.Setenv("GITHUB_BACKEND", badges.BackendGraphQL)
+			},
+			assertions: func(_ badges.ServiceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "GITHUB_TOKEN must be set")
+			},
+		},
+		{
+			name: "GITHUB_PAGE_CONCURRENCY not an int",
+			setup: func() {
+				t.Setenv("GITHUB_TOKEN", "token")
+				t.Setenv("GITHUB_PAGE_CONCURRENCY", "foo")
+			},
+			assertions: func(_ badges.ServiceConfig, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "was not parsable as an int")
-				require.Contains(t, err.Error(), "REDIS_PORT")
+				require.Contains(t, err.Error(), "GITHUB_PAGE_CONCURRENCY")
 			},
 		},
 		{
-			name: "REDIS_PASSWORD not set",
+			name: "GITHUB_GRAPHQL_BATCH_WINDOW not a duration",
 			setup: func() {
-				t.Setenv("REDIS_PORT", "6379")
+				t.Setenv("GITHUB_PAGE_CONCURRENCY", "8")
+				t.Setenv("GITHUB_GRAPHQL_BATCH_WINDOW", "foo")
 			},
-			assertions: func(_ redis.CacheConfig, err error) {
+			assertions: func(_ badges.ServiceConfig, err error) {
 				require.Error(t, err)
-				require.Contains(
+				require.Contains(t, err.Error(), "GITHUB_GRAPHQL_BATCH_WINDOW")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("GITHUB_GRAPHQL_BATCH_WINDOW", "20ms")
+			},
+			assertions: func(config badges.ServiceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
 					t,
-					err.Error(),
-					"value not found for required environment variable",
+					badges.ServiceConfig{
+						Backend:     badges.BackendGraphQL,
+						Token:       "token",
+						BaseURL:     "https://github.example.com/api/v3/",
+						Concurrency: 8,
+						BatchWindow: 20 * time.Millisecond,
+					},
+					config,
 				)
-				require.Contains(t, err.Error(), "REDIS_PASSWORD")
 			},
 		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := serviceConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.CircuitBreakerConfig, error)
+	}{
 		{
-			name: "REDIS_DB not an int",
+			name: "nothing set",
+			assertions: func(config badges.CircuitBreakerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.CircuitBreakerConfig{
+						FailureThreshold: 5,
+						LatencyThreshold: 5 * time.Second,
+						OpenDuration:     30 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "GITHUB_BREAKER_FAILURE_THRESHOLD not an int",
 			setup: func() {
-				t.Setenv("REDIS_PASSWORD", "foobar")
-				t.Setenv("REDIS_DB", "foo")
+				t.Setenv("GITHUB_BREAKER_FAILURE_THRESHOLD", "foo")
 			},
-			assertions: func(_ redis.CacheConfig, err error) {
+			assertions: func(_ badges.CircuitBreakerConfig, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "was not parsable as an int")
-				require.Contains(t, err.Error(), "REDIS_DB")
+				require.Contains(t, err.Error(), "GITHUB_BREAKER_FAILURE_THRESHOLD")
 			},
 		},
 		{
-			name: "REDIS_ENABLE_TLS not a bool",
+			name: "GITHUB_BREAKER_LATENCY_THRESHOLD not a duration",
 			setup: func() {
-				t.Setenv("REDIS_DB", "1")
-				t.Setenv("REDIS_ENABLE_TLS", "foo")
+				t.Setenv("GITHUB_BREAKER_FAILURE_THRESHOLD", "3")
+				t.Setenv("GITHUB_BREAKER_LATENCY_THRESHOLD", "foo")
 			},
-			assertions: func(_ redis.CacheConfig, err error) {
+			assertions: func(_ badges.CircuitBreakerConfig, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "was not parsable as a bool")
-				require.Contains(t, err.Error(), "REDIS_ENABLE_TLS")
+				require.Contains(t, err.Error(), "GITHUB_BREAKER_LATENCY_THRESHOLD")
+			},
+		},
+		{
+			name: "GITHUB_BREAKER_OPEN_DURATION not a duration",
+			setup: func() {
+				t.Setenv("GITHUB_BREAKER_LATENCY_THRESHOLD", "2s")
+				t.Setenv("GITHUB_BREAKER_OPEN_DURATION", "foo")
+			},
+			assertions: func(_ badges.CircuitBreakerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "GITHUB_BREAKER_OPEN_DURATION")
 			},
 		},
 		{
 			name: "success",
 			setup: func() {
-				t.Setenv("REDIS_ENABLE_TLS", "true")
-				t.Setenv("REDIS_PREFIX", "foo")
+				t.Setenv("GITHUB_BREAKER_OPEN_DURATION", "1m")
 			},
-			assertions: func(config redis.CacheConfig, err error) {
+			assertions: func(config badges.CircuitBreakerConfig, err error) {
 				require.NoError(t, err)
 				require.Equal(
 					t,
-					redis.CacheConfig{
-						RedisHost:      "localhost",
-						RedisPort:      6379,
-						RedisPassword:  "foobar",
-						RedisDB:        1,
-						RedisEnableTLS: true,
-						RedisPrefix:    "foo",
+					badges.CircuitBreakerConfig{
+						FailureThreshold: 3,
+						LatencyThreshold: 2 * time.Second,
+						OpenDuration:     time.Minute,
 					},
 					config,
 				)
@@ -191,7 +454,933 @@ func TestRedisCacheConfig(t *testing.T) {
 			if testCase.setup != nil {
 				testCase.setup()
 			}
-			config, err := redisCacheConfig()
+			config, err := circuitBreakerConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(http.ServerConfig, error)
+	}{
+		{
+			name: "PORT not an int",
+			setup: func() {
+				t.Setenv("PORT", "foo")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "PORT")
+			},
+		},
+		{
+			name: "TLS_ENABLED not a bool",
+			setup: func() {
+				t.Setenv("PORT", "8080")
+				t.Setenv("TLS_ENABLED", "nope")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "TLS_ENABLED")
+			},
+		},
+		{
+			name: "TLS_CERT_PATH required but not set",
+			setup: func() {
+				t.Setenv("TLS_ENABLED", "true")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TLS_CERT_PATH")
+			},
+		},
+		{
+			name: "TLS_KEY_PATH required but not set",
+			setup: func() {
+				t.Setenv("TLS_CERT_PATH", "/var/ssl/cert")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TLS_KEY_PATH")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TLS_KEY_PATH", "/var/ssl/key")
+			},
+			assertions: func(config http.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					http.ServerConfig{
+						Port:        8080,
+						TLSEnabled:  true,
+						TLSCertPath: "/var/ssl/cert",
+						TLSKeyPath:  "/var/ssl/key",
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := serverConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestMetricsServerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(http.ServerConfig, error)
+	}{
+		{
+			name: "METRICS_PORT not set",
+			assertions: func(config http.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, http.ServerConfig{}, config)
+			},
+		},
+		{
+			name: "METRICS_PORT not an int",
+			setup: func() {
+				t.Setenv("METRICS_PORT", "foo")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "METRICS_PORT")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("METRICS_PORT", "9090")
+			},
+			assertions: func(config http.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, http.ServerConfig{Port: 9090}, config)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := metricsServerConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestRedisCacheConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(redis.CacheConfig, error)
+	}{
+		{
+			name: "REDIS_HOST not set",
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"value not found for required environment variable",
+				)
+				require.Contains(t, err.Error(), "REDIS_HOST")
+			},
+		},
+		{
+			name: "REDIS_PORT not an int",
+			setup: func() {
+				t.Setenv("REDIS_HOST", "localhost")
+				t.Setenv("REDIS_PORT", "foo")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "REDIS_PORT")
+			},
+		},
+		{
+			name: "REDIS_PASSWORD not set",
+			setup: func() {
+				t.Setenv("REDIS_PORT", "6379")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"value not found for required environment variable",
+				)
+				require.Contains(t, err.Error(), "REDIS_PASSWORD")
+			},
+		},
+		{
+			name: "REDIS_DB not an int",
+			setup: func() {
+				t.Setenv("REDIS_PASSWORD", "foobar")
+				t.Setenv("REDIS_DB", "foo")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "REDIS_DB")
+			},
+		},
+		{
+			name: "REDIS_ENABLE_TLS not a bool",
+			setup: func() {
+				t.Setenv("REDIS_DB", "1")
+				t.Setenv("REDIS_ENABLE_TLS", "foo")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "REDIS_ENABLE_TLS")
+			},
+		},
+		{
+			name: "CACHE_WARM_TTL not a duration",
+			setup: func() {
+				t.Setenv("REDIS_ENABLE_TLS", "true")
+				t.Setenv("REDIS_PREFIX", "foo")
+				t.Setenv("CACHE_WARM_TTL", "foo")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "CACHE_WARM_TTL")
+			},
+		},
+		{
+			name: "CACHE_COLD_TTL not positive",
+			setup: func() {
+				t.Setenv("CACHE_WARM_TTL", "2m")
+				t.Setenv("CACHE_COLD_TTL", "0s")
+			},
+			assertions: func(_ redis.CacheConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("CACHE_COLD_TTL", "48h")
+			},
+			assertions: func(config redis.CacheConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					redis.CacheConfig{
+						RedisHost:      "localhost",
+						RedisPort:      6379,
+						RedisPassword:  "foobar",
+						RedisDB:        1,
+						RedisEnableTLS: true,
+						RedisPrefix:    "foo",
+						WarmTTL:        2 * time.Minute,
+						ColdTTL:        48 * time.Hour,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := redisCacheConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAccessConfig(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules")
+	require.NoError(
+		t,
+		stdos.WriteFile(rulesFile, []byte("deny brigadecore/secret-*\n"), 0600),
+	)
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.AccessConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.AccessConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, badges.AccessConfig{}, config)
+			},
+		},
+		{
+			name: "REPO_RULES_FILE does not exist",
+			setup: func() {
+				t.Setenv("REPO_RULES_FILE", rulesFile+"-nonexistent")
+			},
+			assertions: func(_ badges.AccessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error opening access rules file")
+			},
+		},
+		{
+			name: "REPO_ALLOWLIST invalid",
+			setup: func() {
+				t.Setenv("REPO_RULES_FILE", rulesFile)
+				t.Setenv("REPO_ALLOWLIST", "brigadecore/[")
+			},
+			assertions: func(_ badges.AccessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid access rule")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("REPO_ALLOWLIST", "brigadecore/*,krancour/*")
+				t.Setenv("REPO_DENYLIST", "*/junk")
+			},
+			assertions: func(config badges.AccessConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.AccessConfig{
+						Allow: []string{"brigadecore/*", "krancour/*"},
+						Deny:  []string{"brigadecore/secret-*", "*/junk"},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := accessConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestSigningConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.SigningConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.SigningConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.SigningConfig{
+						Key:           []byte{},
+						VisibilityTTL: 10 * time.Minute,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "SIGNED_OWNERS set without SIGNING_KEY",
+			setup: func() {
+				t.Setenv("SIGNED_OWNERS", "brigadecore,krancour")
+			},
+			assertions: func(_ badges.SigningConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "SIGNING_KEY must be set")
+			},
+		},
+		{
+			name: "SIGNING_VISIBILITY_TTL not parsable as duration",
+			setup: func() {
+				t.Setenv("SIGNING_KEY", "foo")
+				t.Setenv("SIGNING_VISIBILITY_TTL", "foo")
+			},
+			assertions: func(_ badges.SigningConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("SIGNING_VISIBILITY_TTL", "1h")
+			},
+			assertions: func(config badges.SigningConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.SigningConfig{
+						Key:           []byte("foo"),
+						Owners:        []string{"brigadecore", "krancour"},
+						VisibilityTTL: time.Hour,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := signingConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAliasConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.AliasConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.AliasConfig, err error) {
+				require.NoError(t, err)
+				require.Empty(t, config.Aliases)
+			},
+		},
+		{
+			name: "BADGE_ALIASES malformed",
+			setup: func() {
+				t.Setenv("BADGE_ALIASES", "foo")
+			},
+			assertions: func(_ badges.AliasConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "not of the form name=URL")
+			},
+		},
+		{
+			name: "alias defined twice",
+			setup: func() {
+				t.Setenv(
+					"BADGE_ALIASES",
+					"foo=/v1/github/checks/krancour/foo/badge.svg,"+
+						"foo=/v1/github/checks/krancour/bar/badge.svg",
+				)
+			},
+			assertions: func(_ badges.AliasConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "defined more than once")
+			},
+		},
+		{
+			name: "invalid alias name",
+			setup: func() {
+				t.Setenv(
+					"BADGE_ALIASES",
+					"foo bar=/v1/github/checks/krancour/foo/badge.svg",
+				)
+			},
+			assertions: func(_ badges.AliasConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid alias name")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv(
+					"BADGE_ALIASES",
+					"badgr-build=/v1/github/checks/brigadecore/badgr/badge.svg"+
+						"?appID=99005&branch=main, "+
+						"foo=/v1/github/checks/krancour/foo/badge.svg",
+				)
+			},
+			assertions: func(config badges.AliasConfig, err error) {
+				require.NoError(t, err)
+				require.Len(t, config.Aliases, 2)
+				require.Equal(
+					t,
+					"/v1/github/checks/brigadecore/badgr/badge.svg"+
+						"?appID=99005&branch=main",
+					config.Aliases["badgr-build"].String(),
+				)
+				require.Equal(
+					t,
+					"/v1/github/checks/krancour/foo/badge.svg",
+					config.Aliases["foo"].String(),
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := aliasConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestThemeConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.Theme, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.Theme, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.Theme{Colors: map[badges.Color]string{}},
+					config,
+				)
+			},
+		},
+		{
+			name: "BADGE_STYLE invalid",
+			setup: func() {
+				t.Setenv("BADGE_STYLE", "foo")
+			},
+			assertions: func(_ badges.Theme, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid badge style")
+			},
+		},
+		{
+			name: "BADGE_COLORS malformed",
+			setup: func() {
+				t.Setenv("BADGE_STYLE", "flat-square")
+				t.Setenv("BADGE_COLORS", "red")
+			},
+			assertions: func(_ badges.Theme, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "not of the form color=replacement")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("BADGE_COLORS", "brightgreen=2ea44f, red=critical")
+			},
+			assertions: func(config badges.Theme, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.Theme{
+						Style: "flat-square",
+						Colors: map[badges.Color]string{
+							badges.ColorGreen: "2ea44f",
+							badges.ColorRed:   "critical",
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := themeConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAPIKeysConfig(t *testing.T) {
+	require.Equal(t, badges.APIKeysConfig{}, apiKeysConfig(settings{}))
+	t.Setenv("ADMIN_TOKEN", "foo")
+	require.Equal(
+		t,
+		badges.APIKeysConfig{AdminToken: "foo"},
+		apiKeysConfig(settings{}),
+	)
+}
+
+func TestConfiguredAPIKeys(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	hash := strings.Repeat("a", 64)
+	require.NoError(
+		t,
+		stdos.WriteFile(keysFile, []byte("ci "+hash+" brigadecore/*\n"), 0600),
+	)
+
+	keys, err := configuredAPIKeys(settings{})
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	t.Setenv("API_KEYS_FILE", keysFile+"-nonexistent")
+	_, err = configuredAPIKeys(settings{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error opening API keys file")
+
+	t.Setenv("API_KEYS_FILE", keysFile)
+	keys, err = configuredAPIKeys(settings{})
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[string]badges.APIKey{
+			hash: {Name: "ci", Scopes: []string{"brigadecore/*"}},
+		},
+		keys,
+	)
+}
+
+func TestClientRateLimitConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.ClientRateLimitConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.ClientRateLimitConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.ClientRateLimitConfig{
+						PerIP:    badges.ClientLimit{Burst: 10},
+						PerOwner: badges.ClientLimit{Burst: 10},
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "CLIENT_RATE_LIMIT_IP_RATE not a number",
+			setup: func() {
+				t.Setenv("CLIENT_RATE_LIMIT_IP_RATE", "foo")
+			},
+			assertions: func(_ badges.ClientRateLimitConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "CLIENT_RATE_LIMIT_IP_RATE")
+				require.Contains(t, err.Error(), "non-negative number")
+			},
+		},
+		{
+			name: "CLIENT_RATE_LIMIT_IP_BURST not an int",
+			setup: func() {
+				t.Setenv("CLIENT_RATE_LIMIT_IP_RATE", "0.5")
+				t.Setenv("CLIENT_RATE_LIMIT_IP_BURST", "foo")
+			},
+			assertions: func(_ badges.ClientRateLimitConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "CLIENT_RATE_LIMIT_IP_BURST")
+			},
+		},
+		{
+			name: "CLIENT_RATE_LIMIT_IP_BURST not positive",
+			setup: func() {
+				t.Setenv("CLIENT_RATE_LIMIT_IP_BURST", "0")
+			},
+			assertions: func(_ badges.ClientRateLimitConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "CLIENT_RATE_LIMIT_IP_BURST")
+				require.Contains(t, err.Error(), "must be positive")
+			},
+		},
+		{
+			name: "CLIENT_RATE_LIMIT_OWNER_RATE negative",
+			setup: func() {
+				t.Setenv("CLIENT_RATE_LIMIT_IP_BURST", "30")
+				t.Setenv("CLIENT_RATE_LIMIT_OWNER_RATE", "-1")
+			},
+			assertions: func(_ badges.ClientRateLimitConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "CLIENT_RATE_LIMIT_OWNER_RATE")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("CLIENT_RATE_LIMIT_OWNER_RATE", "2")
+				t.Setenv("CLIENT_RATE_LIMIT_OWNER_BURST", "100")
+			},
+			assertions: func(config badges.ClientRateLimitConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.ClientRateLimitConfig{
+						PerIP:    badges.ClientLimit{Rate: 0.5, Burst: 30},
+						PerOwner: badges.ClientLimit{Rate: 2, Burst: 100},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := clientRateLimitConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestPrewarmConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(badges.PrewarmConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config badges.PrewarmConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.PrewarmConfig{
+						Interval:    10 * time.Second,
+						Lead:        20 * time.Second,
+						BudgetShare: 0.2,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "PREWARM_TOP_N not an int",
+			setup: func() {
+				t.Setenv("PREWARM_TOP_N", "foo")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "PREWARM_TOP_N")
+			},
+		},
+		{
+			name: "PREWARM_INTERVAL not a duration",
+			setup: func() {
+				t.Setenv("PREWARM_TOP_N", "100")
+				t.Setenv("PREWARM_INTERVAL", "foo")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_INTERVAL")
+			},
+		},
+		{
+			name: "PREWARM_INTERVAL not positive",
+			setup: func() {
+				t.Setenv("PREWARM_INTERVAL", "0s")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_INTERVAL must be positive")
+			},
+		},
+		{
+			name: "PREWARM_LEAD not a duration",
+			setup: func() {
+				t.Setenv("PREWARM_INTERVAL", "5s")
+				t.Setenv("PREWARM_LEAD", "foo")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_LEAD")
+			},
+		},
+		{
+			name: "PREWARM_INTERVAL not shorter than PREWARM_LEAD",
+			setup: func() {
+				t.Setenv("PREWARM_LEAD", "5s")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"PREWARM_INTERVAL must be shorter than",
+				)
+			},
+		},
+		{
+			name: "PREWARM_BUDGET_SHARE out of range",
+			setup: func() {
+				t.Setenv("PREWARM_LEAD", "15s")
+				t.Setenv("PREWARM_BUDGET_SHARE", "1.5")
+			},
+			assertions: func(_ badges.PrewarmConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_BUDGET_SHARE")
+				require.Contains(t, err.Error(), "between 0 and 1")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("PREWARM_BUDGET_SHARE", "0.5")
+			},
+			assertions: func(config badges.PrewarmConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.PrewarmConfig{
+						TopN:        100,
+						Interval:    5 * time.Second,
+						Lead:        15 * time.Second,
+						BudgetShare: 0.5,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := prewarmConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestPopularityConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(redis.PopularityConfig, error)
+	}{
+		{
+			name: "nothing set",
+			assertions: func(config redis.PopularityConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, redis.PopularityConfig{Window: time.Hour}, config)
+			},
+		},
+		{
+			name: "PREWARM_POPULARITY_WINDOW not a duration",
+			setup: func() {
+				t.Setenv("PREWARM_POPULARITY_WINDOW", "foo")
+			},
+			assertions: func(_ redis.PopularityConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_POPULARITY_WINDOW")
+			},
+		},
+		{
+			name: "PREWARM_POPULARITY_WINDOW not positive",
+			setup: func() {
+				t.Setenv("PREWARM_POPULARITY_WINDOW", "-1h")
+			},
+			assertions: func(_ redis.PopularityConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must be positive")
+			},
+		},
+		{
+			name: "PREWARM_TOP_N not an int",
+			setup: func() {
+				t.Setenv("PREWARM_POPULARITY_WINDOW", "30m")
+				t.Setenv("PREWARM_TOP_N", "foo")
+			},
+			assertions: func(_ redis.PopularityConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "PREWARM_TOP_N")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("PREWARM_TOP_N", "100")
+			},
+			assertions: func(config redis.PopularityConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					redis.PopularityConfig{
+						Window:   30 * time.Minute,
+						Capacity: 1000,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := popularityConfig(settings{})
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTracingConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(tracing.Config, error)
+	}{
+		{
+			name: "TRACING_OTLP_INSECURE not a bool",
+			setup: func() {
+				t.Setenv("TRACING_OTLP_INSECURE", "foo")
+			},
+			assertions: func(_ tracing.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a bool")
+				require.Contains(t, err.Error(), "TRACING_OTLP_INSECURE")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TRACING_EXPORTER", tracing.ExporterOTLP)
+				t.Setenv("TRACING_OTLP_ENDPOINT", "collector:4318")
+				t.Setenv("TRACING_OTLP_INSECURE", "true")
+			},
+			assertions: func(config tracing.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tracing.Config{
+						Exporter:     tracing.ExporterOTLP,
+						OTLPEndpoint: "collector:4318",
+						OTLPInsecure: true,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := tracingConfig(settings{})
 			testCase.assertions(config, err)
 		})
 	}
@@ -0,0 +1,168 @@
+package badges
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/pkg/errors"
+)
+
+// AccessConfig represents configuration options for restricting which
+// repositories Badgr serves badges for. Rules are glob patterns, as understood
+// by path.Match, matched case-insensitively against "owner/repo".
+type AccessConfig struct {
+	// Allow, if non-empty, lists rules at least one of which a repository must
+	// match for Badgr to serve badges for it.
+	Allow []string
+	// Deny lists rules none of which a repository may match for Badgr to serve
+	// badges for it. Deny rules take precedence over Allow rules.
+	Deny []string
+}
+
+// Validate returns an error if any rule is malformed.
+func (a AccessConfig) Validate() error {
+	for _, rule := range append(append([]string{}, a.Allow...), a.Deny...) {
+		if _, err := path.Match(rule, ""); err != nil {
+			return errors.Wrapf(err, "invalid access rule %q", rule)
+		}
+	}
+	return nil
+}
+
+// permitted returns a bool indicating whether Badgr may serve badges for the
+// specified repository.
+func (a AccessConfig) permitted(owner, repo string) bool {
+	name := strings.ToLower(Subject{Owner: owner, Repo: repo}.String())
+	matches := func(rules []string) bool {
+		for _, rule := range rules {
+			// Rules were validated up front, so errors can be ignored
+			if matched, _ := path.Match(strings.ToLower(rule), name); matched {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(a.Deny) {
+		return false
+	}
+	return len(a.Allow) == 0 || matches(a.Allow)
+}
+
+// ParseAccessRules parses access rules from the provided io.Reader. Each line
+// consists of the word "allow" or "deny" followed by a rule. Blank lines and
+// lines beginning with # are ignored.
+func ParseAccessRules(r io.Reader) (AccessConfig, error) {
+	config := AccessConfig{}
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return config, errors.Errorf(
+				"line %d: expected \"allow <rule>\" or \"deny <rule>\"",
+				lineNum,
+			)
+		}
+		switch fields[0] {
+		case "allow":
+			config.Allow = append(config.Allow, fields[1])
+		case "deny":
+			config.Deny = append(config.Deny, fields[1])
+		default:
+			return config, errors.Errorf(
+				"line %d: unrecognized action %q; expected \"allow\" or \"deny\"",
+				lineNum,
+				fields[0],
+			)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return config, errors.Wrap(err, "error reading access rules")
+	}
+	return config, config.Validate()
+}
+
+// LoadAccessRules parses access rules from the specified file. See
+// ParseAccessRules for the file's format.
+func LoadAccessRules(filename string) (AccessConfig, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return AccessConfig{}, errors.Wrapf(
+			err,
+			"error opening access rules file %q",
+			filename,
+		)
+	}
+	defer file.Close()
+	config, err := ParseAccessRules(file)
+	return config, errors.Wrapf(
+		err,
+		"error parsing access rules file %q",
+		filename,
+	)
+}
+
+// accessControl is an http.Handler that serves a "forbidden" badge in response
+// to requests for badges for repositories Badgr may not serve badges for and
+// delegates all other requests to another http.Handler.
+type accessControl struct {
+	config  AccessConfig
+	handler http.Handler
+}
+
+// NewAccessControl returns an http.Handler that serves a "forbidden" badge in
+// response to requests for badges for repositories that are not permitted by
+// the provided AccessConfig, without consulting the cache or GitHub, and
+// delegates all other requests to the provided http.Handler.
+func NewAccessControl(config AccessConfig, handler http.Handler) http.Handler {
+	return &accessControl{
+		config:  config,
+		handler: handler,
+	}
+}
+
+func (a *accessControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subject := subjectFromRequest(r)
+	if a.config.permitted(subject.Owner, subject.Repo) {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	refuse(w, r, NewErrBadge("forbidden"))
+}
+
+// refuse redirects the client to the provided error badge without consulting
+// the cache or GitHub, then logs and records metrics for the refusal.
+func refuse(w http.ResponseWriter, r *http.Request, badge ErrBadge) {
+	start := time.Now()
+	redirectToBadge(w, r, badge)
+	subject := subjectFromRequest(r)
+	logging.LoggerFromContext(r.Context()).Debug(
+		"served badge",
+		"owner", subject.Owner,
+		"repo", subject.Repo,
+		"outcome", outcomeForbidden,
+		"status", badge.Status(),
+	)
+	logging.AddAccessLogAttrs(
+		r.Context(),
+		slog.String("badgeKey", normalizedBadgeKey(r)),
+		slog.String("cache", outcomeForbidden),
+	)
+	route := routeTemplate(r)
+	badgeRequestsTotal.WithLabelValues(route, outcomeForbidden).Inc()
+	badgeRequestDuration.WithLabelValues(route, outcomeForbidden).Observe(
+		time.Since(start).Seconds(),
+	)
+}
@@ -0,0 +1,201 @@
+package badges
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessConfigValidate(t *testing.T) {
+	require.NoError(t, AccessConfig{Allow: []string{"brigadecore/*"}}.Validate())
+	err := AccessConfig{Deny: []string{"brigadecore/["}}.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid access rule")
+}
+
+func TestAccessConfigPermitted(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   AccessConfig
+		owner    string
+		repo     string
+		expected bool
+	}{
+		{
+			name:     "no rules",
+			owner:    "krancour",
+			repo:     "foo",
+			expected: true,
+		},
+		{
+			name:     "allowed",
+			config:   AccessConfig{Allow: []string{"brigadecore/*"}},
+			owner:    "BrigadeCore",
+			repo:     "badgr",
+			expected: true,
+		},
+		{
+			name:   "not allowed",
+			config: AccessConfig{Allow: []string{"brigadecore/*"}},
+			owner:  "krancour",
+			repo:   "foo",
+		},
+		{
+			name:   "wildcard does not span owner and repo",
+			config: AccessConfig{Allow: []string{"brigade*"}},
+			owner:  "brigadecore",
+			repo:   "badgr",
+		},
+		{
+			name: "allowed but denied",
+			config: AccessConfig{
+				Allow: []string{"brigadecore/*"},
+				Deny:  []string{"brigadecore/secret-*"},
+			},
+			owner: "brigadecore",
+			repo:  "secret-sauce",
+		},
+		{
+			name:   "denied",
+			config: AccessConfig{Deny: []string{"*/junk"}},
+			owner:  "krancour",
+			repo:   "junk",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.expected,
+				testCase.config.permitted(testCase.owner, testCase.repo),
+			)
+		})
+	}
+}
+
+func TestParseAccessRules(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rules      string
+		assertions func(AccessConfig, error)
+	}{
+		{
+			name:  "malformed line",
+			rules: "allow",
+			assertions: func(_ AccessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 1")
+			},
+		},
+		{
+			name:  "unrecognized action",
+			rules: "# Comment\npermit brigadecore/*",
+			assertions: func(_ AccessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 2")
+				require.Contains(t, err.Error(), "unrecognized action")
+			},
+		},
+		{
+			name:  "invalid rule",
+			rules: "deny brigadecore/[",
+			assertions: func(_ AccessConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid access rule")
+			},
+		},
+		{
+			name: "success",
+			rules: `
+# Our organizations
+allow brigadecore/*
+allow krancour/*
+
+deny brigadecore/secret-*
+`,
+			assertions: func(config AccessConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					AccessConfig{
+						Allow: []string{"brigadecore/*", "krancour/*"},
+						Deny:  []string{"brigadecore/secret-*"},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				ParseAccessRules(strings.NewReader(testCase.rules)),
+			)
+		})
+	}
+}
+
+func TestLoadAccessRules(t *testing.T) {
+	_, err := LoadAccessRules(filepath.Join(t.TempDir(), "nonexistent"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error opening access rules file")
+
+	filename := filepath.Join(t.TempDir(), "rules")
+	require.NoError(
+		t,
+		os.WriteFile(filename, []byte("allow brigadecore/*\n"), 0600),
+	)
+	config, err := LoadAccessRules(filename)
+	require.NoError(t, err)
+	require.Equal(t, AccessConfig{Allow: []string{"brigadecore/*"}}, config)
+}
+
+func TestAccessControl(t *testing.T) {
+	var served bool
+	testRouter := mux.NewRouter()
+	testRouter.Handle(
+		"/v1/github/checks/{owner}/{repo}/badge.svg",
+		NewAccessControl(
+			AccessConfig{Allow: []string{"brigadecore/*"}},
+			http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				served = true
+			}),
+		),
+	).Methods(http.MethodGet)
+
+	// Forbidden
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(
+		rr,
+		httptest.NewRequest(
+			http.MethodGet,
+			"/v1/github/checks/krancour/foo/badge.svg",
+			nil,
+		),
+	)
+	require.False(t, served)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.Equal(
+		t,
+		badgeURL(NewErrBadge("forbidden")),
+		rr.Header().Get("Location"),
+	)
+
+	// Permitted
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(
+		rr,
+		httptest.NewRequest(
+			http.MethodGet,
+			"/v1/github/checks/brigadecore/badgr/badge.svg",
+			nil,
+		),
+	)
+	require.True(t, served)
+}
@@ -0,0 +1,116 @@
+package badges
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// aliasNameRegex matches valid alias names.
+var aliasNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// AliasConfig represents configuration options for serving badges under short,
+// stable names in place of full badge URLs.
+type AliasConfig struct {
+	// Aliases maps the name of each alias to the URL of the badge it stands for.
+	// URLs consist only of a path and, optionally, a query string.
+	Aliases map[string]*url.URL
+}
+
+// Validate returns an error if any alias has an invalid name or does not stand
+// for a URL relative to Badgr itself.
+func (a AliasConfig) Validate() error {
+	for name, target := range a.Aliases {
+		if !aliasNameRegex.MatchString(name) {
+			return errors.Errorf(
+				"invalid alias name %q; names may contain only letters, digits, "+
+					"dots, hyphens, and underscores",
+				name,
+			)
+		}
+		if target == nil || target.Scheme != "" || target.Host != "" ||
+			target.Path == "" {
+			return errors.Errorf(
+				"alias %q must stand for a path, with an optional query string",
+				name,
+			)
+		}
+	}
+	return nil
+}
+
+// aliases is an http.Handler that serves badges requested by alias by
+// rewriting requests to the URLs the aliases stand for and delegating them to
+// another http.Handler.
+type aliases struct {
+	config  AliasConfig
+	handler http.Handler
+}
+
+// NewAliasHandler returns an http.Handler that serves badges requested by the
+// name of an alias in the provided AliasConfig by rewriting each request to the
+// URL the alias stands for and delegating it to the provided http.Handler,
+// which is expected to route it. Query parameters of the original request that
+// the alias does not specify are retained, so that, for instance, an API key
+// token may accompany a request by alias. Requests for unknown aliases are
+// served an "alias not found" badge.
+func NewAliasHandler(config AliasConfig, handler http.Handler) http.Handler {
+	return &aliases{
+		config:  config,
+		handler: handler,
+	}
+}
+
+func (a *aliases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	target, ok := a.config.Aliases[name]
+	if !ok {
+		start := time.Now()
+		badge := NewErrBadge("alias not found")
+		redirectToBadge(w, r, badge)
+		logging.LoggerFromContext(r.Context()).Debug(
+			"served badge",
+			"alias", name,
+			"outcome", outcomeNotFound,
+			"status", badge.Status(),
+		)
+		logging.AddAccessLogAttrs(
+			r.Context(),
+			slog.String("alias", name),
+			slog.String("cache", outcomeNotFound),
+		)
+		route := routeTemplate(r)
+		badgeRequestsTotal.WithLabelValues(route, outcomeNotFound).Inc()
+		badgeRequestDuration.WithLabelValues(route, outcomeNotFound).Observe(
+			time.Since(start).Seconds(),
+		)
+		return
+	}
+	logging.AddAccessLogAttrs(r.Context(), slog.String("alias", name))
+	a.handler.ServeHTTP(w, resolveAlias(r, target))
+}
+
+// resolveAlias returns a copy of the provided request rewritten to request the
+// provided URL. Only the original request's credentials (an API key or a
+// signature) are carried over; every other query parameter comes from the URL,
+// so callers cannot unpin a branch or badge name that the alias fixes.
+func resolveAlias(r *http.Request, target *url.URL) *http.Request {
+	query := target.Query()
+	for _, key := range []string{tokenParam, signatureParam, expiresParam} {
+		if values, ok := r.URL.Query()[key]; ok {
+			query[key] = values
+		}
+	}
+	resolved := r.Clone(r.Context())
+	resolved.URL.Path = target.Path
+	resolved.URL.RawPath = target.RawPath
+	resolved.URL.RawQuery = query.Encode()
+	resolved.RequestURI = resolved.URL.RequestURI()
+	return resolved
+}
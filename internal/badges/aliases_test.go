@@ -0,0 +1,139 @@
+package badges
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasConfigValidate(t *testing.T) {
+	mustParse := func(rawURL string) *url.URL {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return u
+	}
+	testCases := []struct {
+		name       string
+		config     AliasConfig
+		assertions func(error)
+	}{
+		{
+			name: "invalid name",
+			config: AliasConfig{
+				Aliases: map[string]*url.URL{
+					"foo/bar": mustParse("/v1/github/checks/krancour/foo/badge.svg"),
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid alias name")
+			},
+		},
+		{
+			name: "absolute URL",
+			config: AliasConfig{
+				Aliases: map[string]*url.URL{
+					"foo": mustParse(
+						"https://example.com/v1/github/checks/krancour/foo/badge.svg",
+					),
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must stand for a path")
+			},
+		},
+		{
+			name: "no path",
+			config: AliasConfig{
+				Aliases: map[string]*url.URL{"foo": mustParse("?branch=main")},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must stand for a path")
+			},
+		},
+		{
+			name: "valid",
+			config: AliasConfig{
+				Aliases: map[string]*url.URL{
+					"badgr-build_v2.0": mustParse(
+						"/v1/github/checks/brigadecore/badgr/badge.svg?branch=main",
+					),
+				},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(testCase.config.Validate())
+		})
+	}
+}
+
+func TestAliasHandler(t *testing.T) {
+	target, err := url.Parse(
+		"/v1/github/checks/brigadecore/badgr/badge.svg?appID=99005&branch=main",
+	)
+	require.NoError(t, err)
+	var served *http.Request
+	testRouter := mux.NewRouter()
+	testRouter.Handle(
+		"/v1/github/checks/{owner}/{repo}/badge.svg",
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			served = r
+		}),
+	).Methods(http.MethodGet)
+	testRouter.Handle(
+		"/v1/alias/{name}/badge.svg",
+		NewAliasHandler(
+			AliasConfig{Aliases: map[string]*url.URL{"badgr-build": target}},
+			testRouter,
+		),
+	).Methods(http.MethodGet)
+
+	// Unknown alias
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(
+		rr,
+		httptest.NewRequest(http.MethodGet, "/v1/alias/foo/badge.svg", nil),
+	)
+	require.Nil(t, served)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.Equal(
+		t,
+		badgeURL(NewErrBadge("alias not found")),
+		rr.Header().Get("Location"),
+	)
+
+	// Known alias; only the request's credentials are passed along, so its
+	// other query parameters are ignored
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(
+		rr,
+		httptest.NewRequest(
+			http.MethodGet,
+			"/v1/alias/badgr-build/badge.svg?branch=v2&name=x&token=foo",
+			nil,
+		),
+	)
+	require.NotNil(t, served)
+	require.Equal(t, "brigadecore", mux.Vars(served)["owner"])
+	require.Equal(t, "badgr", mux.Vars(served)["repo"])
+	require.Equal(
+		t,
+		"/v1/github/checks/brigadecore/badgr/badge.svg",
+		served.URL.Path,
+	)
+	require.Equal(t, "main", served.URL.Query().Get("branch"))
+	require.Equal(t, "99005", served.URL.Query().Get("appID"))
+	require.Equal(t, "foo", served.URL.Query().Get("token"))
+	require.NotContains(t, served.URL.Query(), "name")
+}
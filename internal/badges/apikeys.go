@@ -0,0 +1,411 @@
+package badges
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// tokenParam is the query parameter that may carry an API key's token
+const tokenParam = "token"
+
+var (
+	// ErrAPIKeyExists is returned by an APIKeyStore when asked to create an API
+	// key with the same name as an existing one.
+	ErrAPIKeyExists = errors.New("API key already exists")
+	// ErrAPIKeyNotFound is returned by an APIKeyStore when asked to revoke an
+	// API key that does not exist.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is a named key granting access to badges for the repositories matched
+// by its scopes.
+type APIKey struct {
+	// Name identifies the key to operators, e.g. in the access log.
+	Name string `json:"name"`
+	// Scopes are glob patterns, as understood by path.Match, matched
+	// case-insensitively against "owner/repo". The key grants access to badges
+	// for any repository matching at least one of them.
+	Scopes []string `json:"scopes"`
+}
+
+// Validate returns an error if the key is unnamed, has no scopes, or has a
+// malformed scope.
+func (a APIKey) Validate() error {
+	if a.Name == "" {
+		return errors.New("API key name must not be empty")
+	}
+	if len(a.Scopes) == 0 {
+		return errors.New("API key must have at least one scope")
+	}
+	return AccessConfig{Allow: a.Scopes}.Validate()
+}
+
+// permits returns a bool indicating whether the key grants access to badges
+// for the specified repository.
+func (a APIKey) permits(owner, repo string) bool {
+	return len(a.Scopes) > 0 &&
+		AccessConfig{Allow: a.Scopes}.permitted(owner, repo)
+}
+
+// APIKeyStore is the public interface for any component that can store API
+// keys. Keys are stored under the hash of their tokens, never under the tokens
+// themselves.
+type APIKeyStore interface {
+	// Create stores the provided API key under the provided hash. If a key with
+	// the same name already exists, ErrAPIKeyExists is returned.
+	Create(ctx context.Context, hash string, key APIKey) error
+	// Get returns the API key stored under the provided hash, or nil if there is
+	// none.
+	Get(ctx context.Context, hash string) (*APIKey, error)
+	// Revoke deletes the API key with the specified name. If there is no such
+	// key, ErrAPIKeyNotFound is returned.
+	Revoke(ctx context.Context, name string) error
+}
+
+// hashAPIKeyToken returns the hash under which the API key with the provided
+// token is stored. Tokens are long and random, so they need neither salt nor
+// a slow hash.
+func hashAPIKeyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyToken returns the API key token carried by the provided request, if
+// any, in either the Authorization header or the query string.
+func apiKeyToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := bearerToken(auth); ok {
+			return token
+		}
+	}
+	return r.URL.Query().Get(tokenParam)
+}
+
+// bearerToken extracts the token from the value of an Authorization header
+// using the Bearer scheme.
+func bearerToken(auth string) (string, bool) {
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) ||
+		!strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// ParseAPIKeys parses API keys from the provided io.Reader. Each line consists
+// of a key's name, the hex-encoded SHA-256 hash of its token, and a
+// comma-delimited list of its scopes, separated by whitespace. Blank lines and
+// lines beginning with # are ignored. Keys are returned keyed by the hashes of
+// their tokens, as an APIKeyStore would store them.
+func ParseAPIKeys(r io.Reader) (map[string]APIKey, error) {
+	keys := map[string]APIKey{}
+	names := map[string]struct{}{}
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, errors.Errorf(
+				"line %d: expected \"<name> <token hash> <scopes>\"",
+				lineNum,
+			)
+		}
+		hash := strings.ToLower(fields[1])
+		if decoded, err := hex.DecodeString(hash); err != nil ||
+			len(decoded) != sha256.Size {
+			return nil, errors.Errorf(
+				"line %d: token hash %q is not a hex-encoded SHA-256 hash",
+				lineNum,
+				fields[1],
+			)
+		}
+		key := APIKey{
+			Name:   fields[0],
+			Scopes: strings.Split(fields[2], ","),
+		}
+		if err := key.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		if _, ok := names[key.Name]; ok {
+			return nil, errors.Errorf(
+				"line %d: API key %q is defined more than once",
+				lineNum,
+				key.Name,
+			)
+		}
+		if _, ok := keys[hash]; ok {
+			return nil, errors.Errorf(
+				"line %d: token hash is shared with another API key",
+				lineNum,
+			)
+		}
+		names[key.Name] = struct{}{}
+		keys[hash] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading API keys")
+	}
+	return keys, nil
+}
+
+// LoadAPIKeys parses API keys from the specified file. See ParseAPIKeys for the
+// file's format.
+func LoadAPIKeys(filename string) (map[string]APIKey, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error opening API keys file %q",
+			filename,
+		)
+	}
+	defer file.Close()
+	keys, err := ParseAPIKeys(file)
+	return keys, errors.Wrapf(
+		err,
+		"error parsing API keys file %q",
+		filename,
+	)
+}
+
+// apiKeyAuthenticator is an http.Handler that authenticates requests for
+// badges bearing the token of an API key and delegates them to another
+// http.Handler only if the key's scopes include the repository requested.
+type apiKeyAuthenticator struct {
+	// keys are API keys configured by operators, keyed by the hashes of their
+	// tokens
+	keys    map[string]APIKey
+	store   APIKeyStore
+	handler http.Handler
+}
+
+// NewAPIKeyAuthenticator returns an http.Handler that serves a "forbidden"
+// badge in response to requests for badges that bear the token of an API key
+// unless the key is found in the provided map, which is keyed by the hashes of
+// tokens, or, failing that, in the provided APIKeyStore, and its scopes include
+// the repository requested. The name of any key found is added to the access
+// log. Requests that are not refused, including those that bear no token, are
+// delegated to the provided http.Handler, which can find any key that
+// authenticated a request in its context. If the APIKeyStore is nil, only keys
+// found in the map are accepted.
+func NewAPIKeyAuthenticator(
+	keys map[string]APIKey,
+	store APIKeyStore,
+	handler http.Handler,
+) http.Handler {
+	return &apiKeyAuthenticator{
+		keys:    keys,
+		store:   store,
+		handler: handler,
+	}
+}
+
+func (a *apiKeyAuthenticator) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	token := apiKeyToken(r)
+	if token == "" {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	subject := subjectFromRequest(r)
+	logger := logging.LoggerFromContext(r.Context()).With(
+		"owner", subject.Owner,
+		"repo", subject.Repo,
+	)
+	key, err := a.key(r.Context(), hashAPIKeyToken(token))
+	if err != nil {
+		// Err on the side of caution
+		logger.Error("error retrieving API key", "error", err)
+		refuse(w, r, NewErrBadge("forbidden"))
+		return
+	}
+	if key == nil {
+		logger.Debug("refusing request for badge", "reason", "unknown API key")
+		refuse(w, r, NewErrBadge("forbidden"))
+		return
+	}
+	logging.AddAccessLogAttrs(r.Context(), slog.String("apiKey", key.Name))
+	if !key.permits(subject.Owner, subject.Repo) {
+		logger.Debug(
+			"refusing request for badge",
+			"reason", "repository is outside the API key's scopes",
+			"apiKey", key.Name,
+		)
+		refuse(w, r, NewErrBadge("forbidden"))
+		return
+	}
+	a.handler.ServeHTTP(w, r.WithContext(contextWithAPIKey(r.Context(), key)))
+}
+
+// key returns the API key stored under the provided hash, if any.
+func (a *apiKeyAuthenticator) key(
+	ctx context.Context,
+	hash string,
+) (*APIKey, error) {
+	if key, ok := a.keys[hash]; ok {
+		return &key, nil
+	}
+	if a.store == nil {
+		return nil, nil
+	}
+	return a.store.Get(ctx, hash)
+}
+
+type apiKeyContextKey struct{}
+
+// contextWithAPIKey returns a context indicating that the request it belongs
+// to was authenticated by the provided API key.
+func contextWithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// apiKeyFromContext returns the API key that authenticated the request the
+// provided context belongs to, or nil if there is none.
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// APIKeysConfig represents configuration options for the API key admin
+// endpoints.
+type APIKeysConfig struct {
+	// AdminToken is the bearer token that must accompany requests to the admin
+	// endpoints.
+	AdminToken string
+}
+
+// apiKeysHandler is an http.Handler that creates and revokes API keys.
+type apiKeysHandler struct {
+	config APIKeysConfig
+	store  APIKeyStore
+	// tokenFn is overridable for testing purposes
+	tokenFn func() (string, error)
+}
+
+// NewAPIKeysHandler returns an http.Handler that creates API keys in response
+// to POST requests, whose bodies describe the key in JSON, and revokes them in
+// response to DELETE requests, whose routes must provide the key's name as the
+// "name" path variable. Every request must be authorized with the admin token.
+// The token of a newly created key is returned once and never again, since
+// only its hash is stored.
+func NewAPIKeysHandler(config APIKeysConfig, store APIKeyStore) http.Handler {
+	return &apiKeysHandler{
+		config:  config,
+		store:   store,
+		tokenFn: newAPIKeyToken,
+	}
+}
+
+func (a *apiKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok || a.config.AdminToken == "" || subtle.ConstantTimeCompare(
+		[]byte(token),
+		[]byte(a.config.AdminToken),
+	) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		a.create(w, r)
+	case http.MethodDelete:
+		a.revoke(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *apiKeysHandler) create(w http.ResponseWriter, r *http.Request) {
+	key := APIKey{}
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		http.Error(w, "malformed API key", http.StatusBadRequest)
+		return
+	}
+	if err := key.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := a.tokenFn()
+	if err != nil {
+		a.serverError(w, r, "error generating API key token", err)
+		return
+	}
+	err = a.store.Create(r.Context(), hashAPIKeyToken(token), key)
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		a.serverError(w, r, "error creating API key", err)
+		return
+	}
+	logging.LoggerFromContext(r.Context()).Info(
+		"created API key",
+		"name", key.Name,
+		"scopes", key.Scopes,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		APIKey
+		Token string `json:"token"`
+	}{
+		APIKey: key,
+		Token:  token,
+	})
+}
+
+func (a *apiKeysHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := a.store.Revoke(r.Context(), name); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.serverError(w, r, "error revoking API key", err)
+		return
+	}
+	logging.LoggerFromContext(r.Context()).Info("revoked API key", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *apiKeysHandler) serverError(
+	w http.ResponseWriter,
+	r *http.Request,
+	msg string,
+	err error,
+) {
+	logging.LoggerFromContext(r.Context()).Error(msg, "error", err)
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// newAPIKeyToken returns a new, random API key token.
+func newAPIKeyToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
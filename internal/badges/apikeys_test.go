@@ -0,0 +1,500 @@
+package badges
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyValidate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		key        APIKey
+		assertions func(error)
+	}{
+		{
+			name: "no name",
+			key:  APIKey{Scopes: []string{"brigadecore/*"}},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "name must not be empty")
+			},
+		},
+		{
+			name: "no scopes",
+			key:  APIKey{Name: "ci"},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "at least one scope")
+			},
+		},
+		{
+			name: "invalid scope",
+			key:  APIKey{Name: "ci", Scopes: []string{"brigadecore/["}},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid access rule")
+			},
+		},
+		{
+			name: "valid",
+			key:  APIKey{Name: "ci", Scopes: []string{"brigadecore/*"}},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(testCase.key.Validate())
+		})
+	}
+}
+
+func TestAPIKeyPermits(t *testing.T) {
+	key := APIKey{Name: "ci", Scopes: []string{"brigadecore/*", "krancour/foo"}}
+	require.True(t, key.permits("BrigadeCore", "badgr"))
+	require.True(t, key.permits("krancour", "foo"))
+	require.False(t, key.permits("krancour", "bar"))
+	// A key without scopes permits nothing
+	require.False(t, APIKey{Name: "ci"}.permits("krancour", "foo"))
+}
+
+func TestAPIKeyToken(t *testing.T) {
+	testCases := []struct {
+		name          string
+		url           string
+		authorization string
+		expectedToken string
+	}{
+		{
+			name: "no token",
+			url:  "/v1/github/checks/krancour/foo/badge.svg",
+		},
+		{
+			name:          "token in query string",
+			url:           "/v1/github/checks/krancour/foo/badge.svg?token=foo",
+			expectedToken: "foo",
+		},
+		{
+			name:          "token in header",
+			url:           "/v1/github/checks/krancour/foo/badge.svg?token=foo",
+			authorization: "bearer bar",
+			expectedToken: "bar",
+		},
+		{
+			name:          "other authorization scheme",
+			url:           "/v1/github/checks/krancour/foo/badge.svg",
+			authorization: "Basic Zm9vOmJhcg==",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testCase.url, nil)
+			if testCase.authorization != "" {
+				req.Header.Set("Authorization", testCase.authorization)
+			}
+			require.Equal(t, testCase.expectedToken, apiKeyToken(req))
+		})
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	hash := hashAPIKeyToken("t")
+	otherHash := hashAPIKeyToken("u")
+	testCases := []struct {
+		name       string
+		keys       string
+		assertions func(map[string]APIKey, error)
+	}{
+		{
+			name: "wrong number of fields",
+			keys: "ci " + hash,
+			assertions: func(_ map[string]APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 1")
+				require.Contains(t, err.Error(), "expected")
+			},
+		},
+		{
+			name: "invalid hash",
+			keys: "ci foo brigadecore/*",
+			assertions: func(_ map[string]APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "not a hex-encoded SHA-256 hash")
+			},
+		},
+		{
+			name: "invalid scope",
+			keys: "ci " + hash + " brigadecore/[",
+			assertions: func(_ map[string]APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid access rule")
+			},
+		},
+		{
+			name: "name defined twice",
+			keys: "ci " + hash + " brigadecore/*\nci " + otherHash + " krancour/*",
+			assertions: func(_ map[string]APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 2")
+				require.Contains(t, err.Error(), "defined more than once")
+			},
+		},
+		{
+			name: "hash defined twice",
+			keys: "ci " + hash + " brigadecore/*\ndocs " + hash + " krancour/*",
+			assertions: func(_ map[string]APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "shared with another API key")
+			},
+		},
+		{
+			name: "success",
+			keys: `
+# CI
+ci ` + strings.ToUpper(hash) + ` brigadecore/*,krancour/foo
+
+docs ` + otherHash + ` krancour/*
+`,
+			assertions: func(keys map[string]APIKey, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]APIKey{
+						hash: {
+							Name:   "ci",
+							Scopes: []string{"brigadecore/*", "krancour/foo"},
+						},
+						otherHash: {
+							Name:   "docs",
+							Scopes: []string{"krancour/*"},
+						},
+					},
+					keys,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(ParseAPIKeys(strings.NewReader(testCase.keys)))
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	_, err := LoadAPIKeys(filepath.Join(t.TempDir(), "nonexistent"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error opening API keys file")
+
+	filename := filepath.Join(t.TempDir(), "keys")
+	require.NoError(
+		t,
+		os.WriteFile(
+			filename,
+			[]byte("ci "+hashAPIKeyToken("t")+" brigadecore/*\n"),
+			0600,
+		),
+	)
+	keys, err := LoadAPIKeys(filename)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[string]APIKey{
+			hashAPIKeyToken("t"): {Name: "ci", Scopes: []string{"brigadecore/*"}},
+		},
+		keys,
+	)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	testCases := []struct {
+		name             string
+		url              string
+		authorization    string
+		storeErr         error
+		expectedKey      string
+		expectedLocation string
+	}{
+		{
+			name: "no token",
+			url:  "/v1/github/checks/krancour/foo/badge.svg",
+		},
+		{
+			name:        "configured key in query string",
+			url:         "/v1/github/checks/krancour/foo/badge.svg?token=t",
+			expectedKey: "ci",
+		},
+		{
+			name:          "stored key in header",
+			url:           "/v1/github/checks/brigadecore/foo/badge.svg",
+			authorization: "Bearer u",
+			expectedKey:   "docs",
+		},
+		{
+			name:             "key without scope",
+			url:              "/v1/github/checks/krancour/bar/badge.svg?token=t",
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+		},
+		{
+			name:             "unknown key",
+			url:              "/v1/github/checks/krancour/foo/badge.svg?token=v",
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+		},
+		{
+			name:             "error retrieving key",
+			url:              "/v1/github/checks/brigadecore/foo/badge.svg?token=u",
+			storeErr:         errors.New("something went wrong"),
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var served bool
+			var key *APIKey
+			testRouter := mux.NewRouter()
+			testRouter.Handle(
+				"/v1/github/checks/{owner}/{repo}/badge.svg",
+				NewAPIKeyAuthenticator(
+					map[string]APIKey{
+						hashAPIKeyToken("t"): {
+							Name:   "ci",
+							Scopes: []string{"krancour/foo"},
+						},
+					},
+					&mockAPIKeyStore{
+						GetFn: func(
+							_ context.Context,
+							hash string,
+						) (*APIKey, error) {
+							if testCase.storeErr != nil {
+								return nil, testCase.storeErr
+							}
+							if hash != hashAPIKeyToken("u") {
+								return nil, nil
+							}
+							return &APIKey{
+								Name:   "docs",
+								Scopes: []string{"brigadecore/*"},
+							}, nil
+						},
+					},
+					http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+						served = true
+						key = apiKeyFromContext(r.Context())
+					}),
+				),
+			).Methods(http.MethodGet)
+			req := httptest.NewRequest(http.MethodGet, testCase.url, nil)
+			if testCase.authorization != "" {
+				req.Header.Set("Authorization", testCase.authorization)
+			}
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+			if testCase.expectedLocation != "" {
+				require.False(t, served)
+				require.Equal(t, http.StatusSeeOther, rr.Code)
+				require.Equal(
+					t,
+					testCase.expectedLocation,
+					rr.Header().Get("Location"),
+				)
+				return
+			}
+			require.True(t, served)
+			if testCase.expectedKey == "" {
+				require.Nil(t, key)
+				return
+			}
+			require.NotNil(t, key)
+			require.Equal(t, testCase.expectedKey, key.Name)
+		})
+	}
+}
+
+func TestAPIKeysHandler(t *testing.T) {
+	const testAdminToken = "admin"
+	testCases := []struct {
+		name          string
+		method        string
+		url           string
+		authorization string
+		body          string
+		store         *mockAPIKeyStore
+		assertions    func(*httptest.ResponseRecorder)
+	}{
+		{
+			name:          "unauthorized",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer wrong",
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusUnauthorized, rr.Code)
+			},
+		},
+		{
+			name:          "malformed key",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer " + testAdminToken,
+			body:          "{",
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, rr.Code)
+			},
+		},
+		{
+			name:          "invalid key",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer " + testAdminToken,
+			body:          `{"name":"ci"}`,
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, rr.Code)
+				require.Contains(t, rr.Body.String(), "at least one scope")
+			},
+		},
+		{
+			name:          "key already exists",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer " + testAdminToken,
+			body:          `{"name":"ci","scopes":["brigadecore/*"]}`,
+			store: &mockAPIKeyStore{
+				CreateFn: func(context.Context, string, APIKey) error {
+					return ErrAPIKeyExists
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, rr.Code)
+			},
+		},
+		{
+			name:          "error creating key",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer " + testAdminToken,
+			body:          `{"name":"ci","scopes":["brigadecore/*"]}`,
+			store: &mockAPIKeyStore{
+				CreateFn: func(context.Context, string, APIKey) error {
+					return errors.New("something went wrong")
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusInternalServerError, rr.Code)
+			},
+		},
+		{
+			name:          "key created",
+			method:        http.MethodPost,
+			url:           "/admin/api-keys",
+			authorization: "Bearer " + testAdminToken,
+			body:          `{"name":"ci","scopes":["brigadecore/*"]}`,
+			store: &mockAPIKeyStore{
+				CreateFn: func(_ context.Context, hash string, key APIKey) error {
+					require.Equal(t, hashAPIKeyToken("foo"), hash)
+					require.Equal(
+						t,
+						APIKey{Name: "ci", Scopes: []string{"brigadecore/*"}},
+						key,
+					)
+					return nil
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusCreated, rr.Code)
+				created := map[string]interface{}{}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+				require.Equal(t, "ci", created["name"])
+				require.Equal(t, "foo", created["token"])
+			},
+		},
+		{
+			name:          "key not found",
+			method:        http.MethodDelete,
+			url:           "/admin/api-keys/ci",
+			authorization: "Bearer " + testAdminToken,
+			store: &mockAPIKeyStore{
+				RevokeFn: func(context.Context, string) error {
+					return ErrAPIKeyNotFound
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, rr.Code)
+			},
+		},
+		{
+			name:          "key revoked",
+			method:        http.MethodDelete,
+			url:           "/admin/api-keys/ci",
+			authorization: "Bearer " + testAdminToken,
+			store: &mockAPIKeyStore{
+				RevokeFn: func(_ context.Context, name string) error {
+					require.Equal(t, "ci", name)
+					return nil
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNoContent, rr.Code)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler, ok := NewAPIKeysHandler(
+				APIKeysConfig{AdminToken: testAdminToken},
+				testCase.store,
+			).(*apiKeysHandler)
+			require.True(t, ok)
+			handler.tokenFn = func() (string, error) {
+				return "foo", nil
+			}
+			testRouter := mux.NewRouter()
+			testRouter.Handle("/admin/api-keys", handler)
+			testRouter.Handle("/admin/api-keys/{name}", handler)
+			req := httptest.NewRequest(
+				testCase.method,
+				testCase.url,
+				strings.NewReader(testCase.body),
+			)
+			req.Header.Set("Authorization", testCase.authorization)
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+			testCase.assertions(rr)
+		})
+	}
+}
+
+type mockAPIKeyStore struct {
+	CreateFn func(ctx context.Context, hash string, key APIKey) error
+	GetFn    func(ctx context.Context, hash string) (*APIKey, error)
+	RevokeFn func(ctx context.Context, name string) error
+}
+
+func (m *mockAPIKeyStore) Create(
+	ctx context.Context,
+	hash string,
+	key APIKey,
+) error {
+	return m.CreateFn(ctx, hash, key)
+}
+
+func (m *mockAPIKeyStore) Get(
+	ctx context.Context,
+	hash string,
+) (*APIKey, error) {
+	return m.GetFn(ctx, hash)
+}
+
+func (m *mockAPIKeyStore) Revoke(ctx context.Context, name string) error {
+	return m.RevokeFn(ctx, name)
+}
@@ -0,0 +1,195 @@
+package badges
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState string
+
+const (
+	// CircuitStateClosed indicates that requests are permitted.
+	CircuitStateClosed CircuitState = "closed"
+	// CircuitStateOpen indicates that requests are not permitted because the
+	// upstream has recently been failing.
+	CircuitStateOpen CircuitState = "open"
+	// CircuitStateHalfOpen indicates that a single trial request is permitted to
+	// determine whether the upstream has recovered.
+	CircuitStateHalfOpen CircuitState = "half-open"
+)
+
+// circuitStates enumerates all possible states of a CircuitBreaker.
+var circuitStates = []CircuitState{
+	CircuitStateClosed,
+	CircuitStateOpen,
+	CircuitStateHalfOpen,
+}
+
+// CircuitBreakerConfig represents configuration options for a CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures after which the
+	// circuit opens. If zero, the circuit never opens.
+	FailureThreshold int
+	// LatencyThreshold, if non-zero, is the latency above which an otherwise
+	// successful request is counted as a failure.
+	LatencyThreshold time.Duration
+	// OpenDuration is how long the circuit remains open before a trial request
+	// is permitted.
+	OpenDuration time.Duration
+}
+
+// CircuitBreaker stops Badgr from making requests to GitHub while GitHub
+// appears degraded so that results can be served from the cold cache without
+// first waiting for requests that are likely to fail. It is safe for concurrent
+// use.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+	mu     sync.Mutex
+	state  CircuitState
+	// failures is the number of consecutive failures recorded while closed
+	failures int
+	// openedAt is the time at which the circuit last opened
+	openedAt time.Time
+	// trialInFlight indicates that a trial request has been permitted while
+	// half-open and its outcome has not yet been recorded
+	trialInFlight bool
+	// nowFn is overridable for testing purposes
+	nowFn func() time.Time
+}
+
+// NewCircuitBreaker returns a new, closed CircuitBreaker.
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	c := &CircuitBreaker{
+		config: config,
+		nowFn:  time.Now,
+	}
+	c.setState(CircuitStateClosed)
+	return c
+}
+
+// State returns the current state of the circuit.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// allow returns an error if a request should not be made right now. If nil is
+// returned, the outcome of the request MUST subsequently be recorded.
+func (c *CircuitBreaker) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == CircuitStateOpen {
+		until := c.openedAt.Add(c.config.OpenDuration)
+		if c.nowFn().Before(until) {
+			return &circuitOpenError{until: until}
+		}
+		c.setState(CircuitStateHalfOpen)
+	}
+	if c.state == CircuitStateHalfOpen {
+		if c.trialInFlight {
+			return &circuitOpenError{}
+		}
+		c.trialInFlight = true
+	}
+	return nil
+}
+
+// record updates the state of the circuit using the outcome of a request.
+func (c *CircuitBreaker) record(latency time.Duration, err error) {
+	failed := isUpstreamFailure(err) ||
+		(c.config.LatencyThreshold > 0 && latency > c.config.LatencyThreshold)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// We stopped waiting, so the request tells us nothing about GitHub's
+		// health. If it was a trial, let the next request try again.
+		c.trialInFlight = false
+		return
+	}
+	switch c.state {
+	case CircuitStateHalfOpen:
+		c.trialInFlight = false
+		if failed {
+			c.open()
+		} else {
+			slog.Info("GitHub circuit breaker closed")
+			c.failures = 0
+			c.setState(CircuitStateClosed)
+		}
+	case CircuitStateClosed:
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.config.FailureThreshold > 0 &&
+			c.failures >= c.config.FailureThreshold {
+			c.open()
+		}
+	}
+}
+
+// open opens the circuit. The caller must hold the lock.
+func (c *CircuitBreaker) open() {
+	c.openedAt = c.nowFn()
+	slog.Warn(
+		"GitHub circuit breaker opened",
+		"until", c.openedAt.Add(c.config.OpenDuration),
+	)
+	c.setState(CircuitStateOpen)
+}
+
+// setState transitions the circuit to the specified state and updates metrics
+// accordingly. The caller must hold the lock.
+func (c *CircuitBreaker) setState(state CircuitState) {
+	c.state = state
+	for _, s := range circuitStates {
+		var value float64
+		if s == state {
+			value = 1
+		}
+		githubCircuitBreakerState.WithLabelValues(string(s)).Set(value)
+	}
+}
+
+// isUpstreamFailure returns a bool indicating whether an error indicates that
+// GitHub is degraded, as opposed to, for instance, GitHub having given a
+// definitive answer that the requested resource does not exist.
+func isUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return errResp.Response.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// circuitOpenError is returned when Badgr refrains from making a request to
+// GitHub because the circuit is open.
+type circuitOpenError struct {
+	until time.Time
+}
+
+func (c *circuitOpenError) Error() string {
+	if c.until.IsZero() {
+		return "GitHub circuit breaker is half-open and awaiting a trial " +
+			"request; not querying GitHub"
+	}
+	return fmt.Sprintf(
+		"GitHub circuit breaker is open; not querying GitHub until %s",
+		c.until.Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,174 @@
+package badges
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCircuitBreaker(t *testing.T) {
+	testConfig := CircuitBreakerConfig{FailureThreshold: 3}
+	breaker := NewCircuitBreaker(testConfig)
+	require.Equal(t, testConfig, breaker.config)
+	require.Equal(t, CircuitStateClosed, breaker.State())
+	require.NotNil(t, breaker.nowFn)
+	require.Equal(
+		t,
+		float64(1),
+		testutil.ToFloat64(
+			githubCircuitBreakerState.WithLabelValues(string(CircuitStateClosed)),
+		),
+	)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	upstreamErr := &github.ErrorResponse{
+		Response: &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Request:    &http.Request{},
+		},
+	}
+	now := time.Now()
+	breaker := NewCircuitBreaker(
+		CircuitBreakerConfig{
+			FailureThreshold: 2,
+			LatencyThreshold: time.Second,
+			OpenDuration:     time.Minute,
+		},
+	)
+	breaker.nowFn = func() time.Time {
+		return now
+	}
+
+	// Definitive answers from GitHub aren't failures
+	require.NoError(t, breaker.allow())
+	breaker.record(
+		time.Millisecond,
+		&github.ErrorResponse{
+			Response: &http.Response{
+				StatusCode: http.StatusNotFound,
+				Request:    &http.Request{},
+			},
+		},
+	)
+	require.Equal(t, 0, breaker.failures)
+
+	// A success resets the count of consecutive failures
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, upstreamErr)
+	require.Equal(t, 1, breaker.failures)
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, nil)
+	require.Equal(t, 0, breaker.failures)
+
+	// A canceled request is neither a success nor a failure
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, upstreamErr)
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, context.Canceled)
+	require.Equal(t, 1, breaker.failures)
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, nil)
+
+	// Consecutive failures, including slow responses, open the circuit
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, upstreamErr)
+	require.NoError(t, breaker.allow())
+	breaker.record(2*time.Second, nil)
+	require.Equal(t, CircuitStateOpen, breaker.State())
+	require.Equal(
+		t,
+		float64(1),
+		testutil.ToFloat64(
+			githubCircuitBreakerState.WithLabelValues(string(CircuitStateOpen)),
+		),
+	)
+
+	// Requests aren't permitted while the circuit is open
+	err := breaker.allow()
+	var circuitOpenErr *circuitOpenError
+	require.ErrorAs(t, err, &circuitOpenErr)
+
+	// A single trial request is permitted once the circuit has been open long
+	// enough
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.allow())
+	require.Equal(t, CircuitStateHalfOpen, breaker.State())
+	require.ErrorAs(t, breaker.allow(), &circuitOpenErr)
+
+	// An inconclusive trial permits another
+	breaker.record(time.Millisecond, context.Canceled)
+	require.Equal(t, CircuitStateHalfOpen, breaker.State())
+	require.NoError(t, breaker.allow())
+
+	// A failed trial re-opens the circuit
+	breaker.record(time.Millisecond, upstreamErr)
+	require.Equal(t, CircuitStateOpen, breaker.State())
+	require.ErrorAs(t, breaker.allow(), &circuitOpenErr)
+
+	// A successful trial closes the circuit
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.allow())
+	breaker.record(time.Millisecond, nil)
+	require.Equal(t, CircuitStateClosed, breaker.State())
+	require.NoError(t, breaker.allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, breaker.allow())
+		breaker.record(time.Hour, context.DeadlineExceeded)
+	}
+	require.Equal(t, CircuitStateClosed, breaker.State())
+}
+
+func TestIsUpstreamFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name: "no error",
+		},
+		{
+			name: "not modified",
+			err: &github.ErrorResponse{
+				Response: &http.Response{StatusCode: http.StatusNotModified},
+			},
+		},
+		{
+			name: "not found",
+			err: &github.ErrorResponse{
+				Response: &http.Response{StatusCode: http.StatusNotFound},
+			},
+		},
+		{
+			name: "server error",
+			err: &github.ErrorResponse{
+				Response: &http.Response{StatusCode: http.StatusServiceUnavailable},
+			},
+			expected: true,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      context.DeadlineExceeded,
+			expected: true,
+		},
+		{
+			name: "canceled",
+			err:  context.Canceled,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, isUpstreamFailure(testCase.err))
+		})
+	}
+}
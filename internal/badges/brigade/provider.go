@@ -0,0 +1,191 @@
+package brigade
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/pkg/errors"
+)
+
+// Route is the route of badges based on Brigade events.
+const Route = "/v1/brigade/projects/{project}/badge.svg"
+
+// Config represents configuration options for the Brigade provider.
+type Config struct {
+	// APIAddress is the address of the Brigade 2 API server, e.g.
+	// https://brigade.example.com.
+	APIAddress string
+	// APIToken, if non-empty, is a token used to authenticate to the Brigade
+	// API server. A service account token with read access to the relevant
+	// projects is sufficient.
+	APIToken string
+}
+
+func init() {
+	badges.RegisterProviderFactory("brigade", newConfiguredProvider)
+}
+
+// configFromSettings populates configuration for the Brigade provider from
+// the provided settings.
+func configFromSettings(settings badges.Settings) (Config, error) {
+	config := Config{
+		APIToken: settings.Setting("BRIGADE_API_TOKEN"),
+	}
+	var err error
+	config.APIAddress, err = badges.URLSetting(settings, "BRIGADE_API_ADDRESS")
+	return config, err
+}
+
+// newConfiguredProvider returns a Brigade provider configured from the
+// provided settings, or nil if BRIGADE_API_ADDRESS is unset.
+func newConfiguredProvider(
+	settings badges.Settings,
+) (badges.Provider, error) {
+	config, err := configFromSettings(settings)
+	if err != nil || config.APIAddress == "" {
+		return nil, err
+	}
+	return NewProvider(config), nil
+}
+
+// Options represents options for a badge based on Brigade events.
+type Options struct {
+	// BadgeName specifies a name that should be applied to the badge. If left
+	// unspecified, it will default to "build".
+	BadgeName string
+	// Source, if non-empty, restricts the badge to events from the specified
+	// source, e.g. brigade.sh/github.
+	Source string
+	// Type, if non-empty, restricts the badge to events of the specified type,
+	// e.g. check_suite:requested.
+	Type string
+}
+
+// eventList is the subset of a Brigade list of events that Badgr is interested
+// in. Brigade lists events most recent first.
+type eventList struct {
+	Items []event `json:"items"`
+}
+
+// event is the subset of a Brigade event that Badgr is interested in.
+type event struct {
+	Worker struct {
+		Status struct {
+			Phase string `json:"phase"`
+		} `json:"status"`
+	} `json:"worker"`
+}
+
+type provider struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// NewProvider returns an implementation of the badges.Provider interface that
+// serves badges based on the phase of the worker handling the most recent event
+// for a Brigade project.
+func NewProvider(config Config) badges.Provider {
+	return &provider{
+		config:     config,
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *provider) Name() string {
+	return "brigade-events"
+}
+
+func (p *provider) Route() string {
+	return Route
+}
+
+// Subject returns the project as the owner. Brigade projects have no owner of
+// their own, and are independent of one another, so each project is limited
+// and prewarmed separately, and access rules see a project as its ID.
+func (p *provider) Subject(vars map[string]string) badges.Subject {
+	return badges.Subject{Owner: vars["project"]}
+}
+
+func (p *provider) ParseOptions(query url.Values) (interface{}, error) {
+	return &Options{
+		BadgeName: query.Get("name"),
+		Source:    query.Get("source"),
+		Type:      query.Get("type"),
+	}, nil
+}
+
+func (p *provider) Fetch(
+	ctx context.Context,
+	req badges.BadgeRequest,
+) (badges.Badge, error) {
+	opts, ok := req.Options.(*Options)
+	if !ok {
+		return nil, errors.Errorf("unexpected options type %T", req.Options)
+	}
+
+	query := url.Values{
+		"projectID": []string{req.Owner},
+		"limit":     []string{"1"},
+	}
+	if opts.Source != "" {
+		query.Set("source", opts.Source)
+	}
+	if opts.Type != "" {
+		query.Set("type", opts.Type)
+	}
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/v2/events?%s",
+			strings.TrimSuffix(p.config.APIAddress, "/"),
+			query.Encode(),
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Brigade request")
+	}
+	if p.config.APIToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.config.APIToken)
+	}
+	events := eventList{}
+	if err = badges.GetJSON(ctx, p.httpClient, httpReq, &events); err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error retrieving events for Brigade project %s",
+			req.Owner,
+		)
+	}
+	if len(events.Items) == 0 {
+		return badges.NewCheckBadge(opts.BadgeName, badges.CheckStatusUnknown), nil
+	}
+	return badges.NewCheckBadge(
+		opts.BadgeName,
+		workerPhaseStatus(events.Items[0].Worker.Status.Phase),
+	), nil
+}
+
+// workerPhaseStatus maps the phase of a Brigade worker onto a CheckStatus.
+func workerPhaseStatus(phase string) badges.CheckStatus {
+	switch phase {
+	case "SUCCEEDED":
+		return badges.CheckStatusPassed
+	case "FAILED", "SCHEDULING_FAILED":
+		return badges.CheckStatusFailed
+	case "TIMED_OUT":
+		return badges.CheckStatusTimedOut
+	case "ABORTED", "CANCELED":
+		return badges.CheckStatusCanceled
+	case "PENDING":
+		return badges.CheckStatusQueued
+	case "STARTING", "RUNNING":
+		return badges.CheckStatusInProgress
+	default:
+		return badges.CheckStatusUnknown
+	}
+}
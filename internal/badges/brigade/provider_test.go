@@ -0,0 +1,218 @@
+package brigade
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle(Route, http.NotFoundHandler())
+	match := mux.RouteMatch{}
+	require.True(
+		t,
+		router.Match(
+			httptest.NewRequest(
+				http.MethodGet,
+				"/v1/brigade/projects/hello-world/badge.svg",
+				nil,
+			),
+			&match,
+		),
+	)
+	require.Equal(t, "hello-world", match.Vars["project"])
+	require.Equal(
+		t,
+		badges.Subject{Owner: "hello-world"},
+		NewProvider(Config{}).Subject(match.Vars),
+	)
+	require.False(
+		t,
+		router.Match(
+			httptest.NewRequest(
+				http.MethodGet,
+				"/v1/brigade/foo/hello-world/badge.svg",
+				nil,
+			),
+			&mux.RouteMatch{},
+		),
+	)
+}
+
+func TestNewConfiguredProvider(t *testing.T) {
+	// Providers whose backends aren't configured aren't registered at all
+	p, err := newConfiguredProvider(badges.SettingsMap{})
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = newConfiguredProvider(
+		badges.SettingsMap{
+			"BRIGADE_API_ADDRESS": "https://brigade.example.com",
+			"BRIGADE_API_TOKEN":   "foo",
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Config{
+			APIAddress: "https://brigade.example.com",
+			APIToken:   "foo",
+		},
+		p.(*provider).config,
+	)
+}
+
+func TestProviderParseOptions(t *testing.T) {
+	opts, err := NewProvider(Config{}).ParseOptions(
+		url.Values{
+			"name":   []string{"tests"},
+			"source": []string{"brigade.sh/github"},
+			"type":   []string{"check_suite:requested"},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		&Options{
+			BadgeName: "tests",
+			Source:    "brigade.sh/github",
+			Type:      "check_suite:requested",
+		},
+		opts,
+	)
+}
+
+func TestProviderFetch(t *testing.T) {
+	const testToken = "foo"
+	testCases := []struct {
+		name       string
+		opts       *Options
+		handler    http.HandlerFunc
+		assertions func(badges.Badge, error)
+	}{
+		{
+			name: "no events",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, `{"metadata":{},"items":[]}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusUnknown),
+					badge,
+				)
+			},
+		},
+		{
+			name: "defaults",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(
+					t,
+					url.Values{
+						"projectID": []string{"hello-world"},
+						"limit":     []string{"1"},
+					},
+					r.URL.Query(),
+				)
+				fmt.Fprint(w, `{"items":[{"worker":{"status":{"phase":"RUNNING"}}}]}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusInProgress),
+					badge,
+				)
+			},
+		},
+		{
+			name: "latest event from source of type",
+			opts: &Options{
+				BadgeName: "tests",
+				Source:    "brigade.sh/github",
+				Type:      "check_suite:requested",
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(
+					t,
+					"Bearer "+testToken,
+					r.Header.Get("Authorization"),
+				)
+				require.Equal(t, "/v2/events", r.URL.Path)
+				require.Equal(t, "hello-world", r.URL.Query().Get("projectID"))
+				require.Equal(
+					t,
+					"brigade.sh/github",
+					r.URL.Query().Get("source"),
+				)
+				require.Equal(
+					t,
+					"check_suite:requested",
+					r.URL.Query().Get("type"),
+				)
+				fmt.Fprint(
+					w,
+					`{"items":[{"worker":{"status":{"phase":"SUCCEEDED"}}}]}`,
+				)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("tests", badges.CheckStatusPassed),
+					badge,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+			testCase.assertions(
+				NewProvider(
+					Config{
+						APIAddress: server.URL + "/",
+						APIToken:   testToken,
+					},
+				).Fetch(
+					context.Background(),
+					badges.BadgeRequest{
+						Owner:   "hello-world",
+						Options: testCase.opts,
+					},
+				),
+			)
+		})
+	}
+}
+
+func TestWorkerPhaseStatus(t *testing.T) {
+	testCases := map[string]badges.CheckStatus{
+		"SUCCEEDED":         badges.CheckStatusPassed,
+		"FAILED":            badges.CheckStatusFailed,
+		"SCHEDULING_FAILED": badges.CheckStatusFailed,
+		"TIMED_OUT":         badges.CheckStatusTimedOut,
+		"ABORTED":           badges.CheckStatusCanceled,
+		"CANCELED":          badges.CheckStatusCanceled,
+		"PENDING":           badges.CheckStatusQueued,
+		"STARTING":          badges.CheckStatusInProgress,
+		"RUNNING":           badges.CheckStatusInProgress,
+		"UNKNOWN":           badges.CheckStatusUnknown,
+	}
+	for phase, expected := range testCases {
+		t.Run(phase, func(t *testing.T) {
+			require.Equal(t, expected, workerPhaseStatus(phase))
+		})
+	}
+}
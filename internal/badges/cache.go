@@ -1,13 +1,32 @@
 package badges
 
+import (
+	"context"
+	"time"
+)
+
 // Cache is the public interface for any component that can cache results.
 type Cache interface {
 	// Set writes a result to both warm and cold caches.
-	Set(key, value string) error
-	// Get reads a result from the warm cache. An empty string return value
-	// indicates a cache miss.
-	GetWarm(key string) (string, error)
-	// Get reads a result from the cold cache. An empty string return value
-	// indicates a cache miss.
-	GetCold(key string) (string, error)
+	Set(ctx context.Context, key string, record BadgeRecord) error
+	// SetWarm writes a result to the warm cache only, with the specified TTL.
+	// This is useful for results that should be remembered briefly, but which
+	// should never be mistaken for a last known good result.
+	SetWarm(
+		ctx context.Context,
+		key string,
+		record BadgeRecord,
+		ttl time.Duration,
+	) error
+	// Get reads a result from the warm cache. A nil return value indicates a
+	// cache miss.
+	GetWarm(ctx context.Context, key string) (*BadgeRecord, error)
+	// Get reads a result from the cold cache. A nil return value indicates a
+	// cache miss.
+	GetCold(ctx context.Context, key string) (*BadgeRecord, error)
+	// WarmTTL returns the time remaining before a result expires from the warm
+	// cache. Zero is returned if there is no such result.
+	WarmTTL(ctx context.Context, key string) (time.Duration, error)
+	// Ping verifies that the cache is reachable.
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,168 @@
+package badges
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/logging"
+)
+
+// Scopes of client rate limits, used as metric label values and in the keys of
+// token buckets
+const (
+	clientLimitScopeIP    = "ip"
+	clientLimitScopeOwner = "owner"
+)
+
+// ClientLimit describes a token bucket.
+type ClientLimit struct {
+	// Rate is the rate, in requests per second, at which the bucket refills. If
+	// zero, the limit is not enforced.
+	Rate float64
+	// Burst is the capacity of the bucket, i.e. the number of requests permitted
+	// in quick succession.
+	Burst int
+}
+
+// ClientRateLimitConfig represents configuration options for limiting the rate
+// at which clients may cause Badgr to query the backends of providers.
+type ClientRateLimitConfig struct {
+	// PerIP limits requests from each client IP address.
+	PerIP ClientLimit
+	// PerOwner limits requests for badges belonging to each owner, as reported
+	// by the Subject of each badge.
+	PerOwner ClientLimit
+}
+
+// Limiter is the public interface for any component that can enforce token
+// bucket rate limits.
+type Limiter interface {
+	// Allow takes a token from the bucket with the specified key, if one is
+	// available, and returns a bool indicating whether one was.
+	Allow(ctx context.Context, key string, limit ClientLimit) (bool, error)
+}
+
+// clientRateLimitedProvider is an implementation of the Provider interface
+// that enforces per-client and per-owner rate limits before delegating to
+// another implementation. Since it only comes into play when a badge cannot be
+// served from the warm cache, viewers of popular badges are unaffected by it,
+// while anyone looping over random repositories is quickly cut off from the
+// provider's backend.
+type clientRateLimitedProvider struct {
+	config   ClientRateLimitConfig
+	provider Provider
+	limiter  Limiter
+}
+
+// NewClientRateLimitedProvider returns an implementation of the Provider
+// interface that enforces per-client and per-owner rate limits before
+// delegating to the provided Provider. Requests made by the Prewarmer are not
+// limited. Per-IP limits are shared by all providers, while per-owner limits
+// apply to the owners of each provider's Subjects separately.
+func NewClientRateLimitedProvider(
+	config ClientRateLimitConfig,
+	provider Provider,
+	limiter Limiter,
+) Provider {
+	return &clientRateLimitedProvider{
+		config:   config,
+		provider: provider,
+		limiter:  limiter,
+	}
+}
+
+func (c *clientRateLimitedProvider) Name() string {
+	return c.provider.Name()
+}
+
+func (c *clientRateLimitedProvider) Route() string {
+	return c.provider.Route()
+}
+
+func (c *clientRateLimitedProvider) Subject(vars map[string]string) Subject {
+	return c.provider.Subject(vars)
+}
+
+func (c *clientRateLimitedProvider) ParseOptions(
+	query url.Values,
+) (interface{}, error) {
+	return c.provider.ParseOptions(query)
+}
+
+func (c *clientRateLimitedProvider) Fetch(
+	ctx context.Context,
+	req BadgeRequest,
+) (Badge, error) {
+	if refreshFromContext(ctx) == nil {
+		if clientIP := logging.ClientIPFromContext(ctx); clientIP != "" {
+			if err := c.allow(
+				ctx,
+				clientLimitScopeIP,
+				clientIP,
+				c.config.PerIP,
+			); err != nil {
+				return nil, err
+			}
+		}
+		// Owners are distinct per provider, even where their names coincide
+		if err := c.allow(
+			ctx,
+			clientLimitScopeOwner,
+			fmt.Sprintf("%s/%s", c.provider.Name(), strings.ToLower(req.Owner)),
+			c.config.PerOwner,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return c.provider.Fetch(ctx, req)
+}
+
+// allow returns an error if the specified limit has been exceeded. If the
+// limiter itself fails, the request is permitted, since refusing every request
+// for want of Redis would be worse than briefly not enforcing limits.
+func (c *clientRateLimitedProvider) allow(
+	ctx context.Context,
+	scope string,
+	subject string,
+	limit ClientLimit,
+) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	allowed, err := c.limiter.Allow(
+		ctx,
+		fmt.Sprintf("%s:%s", scope, subject),
+		limit,
+	)
+	if err != nil {
+		logging.LoggerFromContext(ctx).Error(
+			"error enforcing client rate limit",
+			"scope", scope,
+			"error", err,
+		)
+		return nil
+	}
+	if !allowed {
+		clientRateLimitedTotal.WithLabelValues(scope).Inc()
+		return &clientRateLimitedError{scope: scope, subject: subject}
+	}
+	return nil
+}
+
+// clientRateLimitedError is returned when Badgr refrains from querying a
+// provider's backend on a client's behalf because a client rate limit has been
+// exceeded.
+type clientRateLimitedError struct {
+	scope   string
+	subject string
+}
+
+func (c *clientRateLimitedError) Error() string {
+	return fmt.Sprintf(
+		"rate limit for %s %q exceeded; not fetching badge",
+		c.scope,
+		c.subject,
+	)
+}
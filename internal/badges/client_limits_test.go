@@ -0,0 +1,203 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientRateLimitedProvider(t *testing.T) {
+	testConfig := ClientRateLimitConfig{
+		PerIP: ClientLimit{Rate: 1, Burst: 10},
+	}
+	testProvider := &mockProvider{}
+	testLimiter := &mockLimiter{}
+	p, ok := NewClientRateLimitedProvider(
+		testConfig,
+		testProvider,
+		testLimiter,
+	).(*clientRateLimitedProvider)
+	require.True(t, ok)
+	require.Equal(t, testConfig, p.config)
+	require.Same(t, testProvider, p.provider)
+	require.Same(t, testLimiter, p.limiter)
+}
+
+func TestClientRateLimitedProviderFetch(t *testing.T) {
+	testLimit := ClientLimit{Rate: 1, Burst: 10}
+	testBadge := CheckBadge{name: "build", status: CheckStatusPassed}
+	testCases := []struct {
+		name       string
+		config     ClientRateLimitConfig
+		limiter    *mockLimiter
+		refresh    bool
+		assertions func(keys []string, badge Badge, err error)
+	}{
+		{
+			name: "limits disabled",
+			limiter: &mockLimiter{
+				AllowFn: func(context.Context, string, ClientLimit) (bool, error) {
+					require.Fail(t, "limiter should not have been consulted")
+					return false, nil
+				},
+			},
+			assertions: func(_ []string, badge Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(t, testBadge, badge)
+			},
+		},
+		{
+			name: "ip limit exceeded",
+			config: ClientRateLimitConfig{
+				PerIP:    testLimit,
+				PerOwner: testLimit,
+			},
+			limiter: &mockLimiter{
+				AllowFn: func(context.Context, string, ClientLimit) (bool, error) {
+					return false, nil
+				},
+			},
+			assertions: func(keys []string, _ Badge, err error) {
+				require.Equal(t, []string{"ip:192.0.2.1"}, keys)
+				var clientRateLimitedErr *clientRateLimitedError
+				require.ErrorAs(t, err, &clientRateLimitedErr)
+				require.Equal(t, clientLimitScopeIP, clientRateLimitedErr.scope)
+			},
+		},
+		{
+			name: "owner limit exceeded",
+			config: ClientRateLimitConfig{
+				PerIP:    testLimit,
+				PerOwner: testLimit,
+			},
+			limiter: &mockLimiter{
+				AllowFn: func(
+					_ context.Context,
+					key string,
+					_ ClientLimit,
+				) (bool, error) {
+					return key != "owner:github-checks/krancour", nil
+				},
+			},
+			assertions: func(keys []string, _ Badge, err error) {
+				require.Equal(
+					t,
+					[]string{"ip:192.0.2.1", "owner:github-checks/krancour"},
+					keys,
+				)
+				var clientRateLimitedErr *clientRateLimitedError
+				require.ErrorAs(t, err, &clientRateLimitedErr)
+				require.Equal(t, clientLimitScopeOwner, clientRateLimitedErr.scope)
+			},
+		},
+		{
+			name:   "limiter error",
+			config: ClientRateLimitConfig{PerIP: testLimit},
+			limiter: &mockLimiter{
+				AllowFn: func(context.Context, string, ClientLimit) (bool, error) {
+					return false, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ []string, badge Badge, err error) {
+				// Limits should not be enforced if they cannot be
+				require.NoError(t, err)
+				require.Equal(t, testBadge, badge)
+			},
+		},
+		{
+			name:    "prewarmer refresh",
+			config:  ClientRateLimitConfig{PerOwner: testLimit},
+			refresh: true,
+			limiter: &mockLimiter{
+				AllowFn: func(context.Context, string, ClientLimit) (bool, error) {
+					return false, nil
+				},
+			},
+			assertions: func(keys []string, badge Badge, err error) {
+				require.Empty(t, keys)
+				require.NoError(t, err)
+				require.Equal(t, testBadge, badge)
+			},
+		},
+		{
+			name: "within limits",
+			config: ClientRateLimitConfig{
+				PerIP:    testLimit,
+				PerOwner: testLimit,
+			},
+			limiter: &mockLimiter{
+				AllowFn: func(context.Context, string, ClientLimit) (bool, error) {
+					return true, nil
+				},
+			},
+			assertions: func(keys []string, badge Badge, err error) {
+				require.Len(t, keys, 2)
+				require.NoError(t, err)
+				require.Equal(t, testBadge, badge)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var keys []string
+			allowFn := testCase.limiter.AllowFn
+			testCase.limiter.AllowFn = func(
+				ctx context.Context,
+				key string,
+				limit ClientLimit,
+			) (bool, error) {
+				keys = append(keys, key)
+				return allowFn(ctx, key, limit)
+			}
+			p := &clientRateLimitedProvider{
+				config:  testCase.config,
+				limiter: testCase.limiter,
+				provider: &mockProvider{
+					NameFn: func() string {
+						return "github-checks"
+					},
+					FetchFn: func(context.Context, BadgeRequest) (Badge, error) {
+						return testBadge, nil
+					},
+				},
+			}
+			// The client IP is determined by the access log
+			var badge Badge
+			var err error
+			logging.AccessLog(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					ctx := r.Context()
+					if testCase.refresh {
+						ctx = contextWithRefresh(ctx, &refresh{})
+					}
+					badge, err = p.Fetch(
+						ctx,
+						BadgeRequest{Owner: "Krancour", Repo: "foo"},
+					)
+				}),
+				logging.AccessLogConfig{},
+			).ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, "/", nil),
+			)
+			testCase.assertions(keys, badge, err)
+		})
+	}
+}
+
+type mockLimiter struct {
+	AllowFn func(ctx context.Context, key string, limit ClientLimit) (bool, error)
+}
+
+func (m *mockLimiter) Allow(
+	ctx context.Context,
+	key string,
+	limit ClientLimit,
+) (bool, error) {
+	return m.AllowFn(ctx, key, limit)
+}
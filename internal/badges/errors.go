@@ -0,0 +1,146 @@
+package badges
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+)
+
+// notFoundTTL is how long a "not found" result is held in the warm cache. A
+// repository or branch that does not exist now is unlikely to exist a few
+// minutes from now, so there is little sense in asking GitHub again sooner.
+const notFoundTTL = 5 * time.Minute
+
+// failure describes how a failure to obtain a fresh badge from the Service
+// should be handled.
+type failure struct {
+	// badge is the badge that is served if no better result is available.
+	badge ErrBadge
+	// logLevel is the severity with which the failure should be logged.
+	logLevel slog.Level
+	// useColdCache indicates whether a cold cache result, if available, should
+	// be preferred over badge. This is true for transient failures and false
+	// for failures where GitHub has given us a definitive answer.
+	useColdCache bool
+	// warmTTL, if non-zero, indicates that badge should be written to the warm
+	// cache for the specified duration.
+	warmTTL time.Duration
+}
+
+// classifyError inspects an error returned from the Service and determines how
+// the failure should be handled.
+func classifyError(err error) failure {
+	var clientRateLimitedErr *clientRateLimitedError
+	var circuitOpenErr *circuitOpenError
+	var rateLimitedErr *rateLimitedError
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	var errResp *github.ErrorResponse
+	var upstreamErr *UpstreamError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &clientRateLimitedErr):
+		// The client is being refused on purpose. There's no sense in rewarding
+		// it with a last known good result.
+		return failure{
+			badge:    NewErrBadge("rate limited"),
+			logLevel: slog.LevelInfo,
+		}
+	case errors.As(err, &circuitOpenErr):
+		// The failure that opened the circuit was already logged as a warning, so
+		// there's no need to make noise about every request that follows.
+		return failure{
+			badge:        NewErrBadge("upstream unavailable"),
+			logLevel:     slog.LevelInfo,
+			useColdCache: true,
+		}
+	case errors.As(err, &rateLimitedErr),
+		errors.As(err, &rateLimitErr),
+		errors.As(err, &abuseRateLimitErr):
+		return failure{
+			badge:        NewErrBadge("rate limited"),
+			logLevel:     slog.LevelWarn,
+			useColdCache: true,
+		}
+	case errors.As(err, &errResp) && errResp.Response != nil:
+		switch code := errResp.Response.StatusCode; {
+		case code == http.StatusNotFound:
+			return failure{
+				badge:    NewErrBadge("repo not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			}
+		case code == http.StatusUnprocessableEntity:
+			// This is what GitHub returns when the ref doesn't exist.
+			return failure{
+				badge:    NewErrBadge("branch not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			}
+		case code >= http.StatusInternalServerError:
+			return failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			}
+		}
+	case errors.Is(err, ErrRepoNotFound):
+		return failure{
+			badge:    NewErrBadge("repo not found"),
+			logLevel: slog.LevelInfo,
+			warmTTL:  notFoundTTL,
+		}
+	case errors.Is(err, ErrBranchNotFound):
+		return failure{
+			badge:    NewErrBadge("branch not found"),
+			logLevel: slog.LevelInfo,
+			warmTTL:  notFoundTTL,
+		}
+	case errors.As(err, &upstreamErr):
+		switch code := upstreamErr.StatusCode; {
+		case code == http.StatusNotFound:
+			// Providers' backends don't, as a rule, distinguish between something
+			// that doesn't exist and something that isn't visible to Badgr
+			return failure{
+				badge:    NewErrBadge("repo not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			}
+		case code == http.StatusTooManyRequests:
+			return failure{
+				badge:        NewErrBadge("rate limited"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			}
+		case code >= http.StatusInternalServerError:
+			return failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			}
+		}
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return failure{
+			badge:        NewErrBadge("timeout"),
+			logLevel:     slog.LevelWarn,
+			useColdCache: true,
+		}
+	case errors.As(err, &netErr):
+		return failure{
+			badge:        NewErrBadge("upstream unavailable"),
+			logLevel:     slog.LevelWarn,
+			useColdCache: true,
+		}
+	}
+	return failure{
+		badge:        NewErrBadge(http.StatusInternalServerError),
+		logLevel:     slog.LevelError,
+		useColdCache: true,
+	}
+}
@@ -0,0 +1,247 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	pkgErrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	retryAfter := time.Minute
+	testCases := []struct {
+		name            string
+		err             error
+		expectedFailure failure
+	}{
+		{
+			name: "client rate limited",
+			err: &clientRateLimitedError{
+				scope:   clientLimitScopeIP,
+				subject: "192.0.2.1",
+			},
+			expectedFailure: failure{
+				badge:    NewErrBadge("rate limited"),
+				logLevel: slog.LevelInfo,
+			},
+		},
+		{
+			name: "circuit open",
+			err: pkgErrors.Wrap(
+				&circuitOpenError{until: time.Now()},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelInfo,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "rate limited",
+			err: pkgErrors.Wrap(
+				&github.RateLimitError{
+					Response: &http.Response{StatusCode: http.StatusForbidden},
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("rate limited"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "secondary rate limit",
+			err: pkgErrors.Wrap(
+				&github.AbuseRateLimitError{
+					Response:   &http.Response{StatusCode: http.StatusForbidden},
+					RetryAfter: &retryAfter,
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("rate limited"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "repo not found",
+			err: pkgErrors.Wrap(
+				&github.ErrorResponse{
+					Response: &http.Response{StatusCode: http.StatusNotFound},
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:    NewErrBadge("repo not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			},
+		},
+		{
+			name: "branch not found",
+			err: pkgErrors.Wrap(
+				&github.ErrorResponse{
+					Response: &http.Response{
+						StatusCode: http.StatusUnprocessableEntity,
+					},
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:    NewErrBadge("branch not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			},
+		},
+		{
+			name: "github server error",
+			err: pkgErrors.Wrap(
+				&github.ErrorResponse{
+					Response: &http.Response{StatusCode: http.StatusBadGateway},
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "provider repo not found",
+			err:  pkgErrors.Wrap(ErrRepoNotFound, "error retrieving pipelines"),
+			expectedFailure: failure{
+				badge:    NewErrBadge("repo not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			},
+		},
+		{
+			name: "provider branch not found",
+			err:  pkgErrors.Wrap(ErrBranchNotFound, "error retrieving pipelines"),
+			expectedFailure: failure{
+				badge:    NewErrBadge("branch not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			},
+		},
+		{
+			name: "provider resource not found",
+			err: pkgErrors.Wrap(
+				&UpstreamError{StatusCode: http.StatusNotFound},
+				"error retrieving pipelines",
+			),
+			expectedFailure: failure{
+				badge:    NewErrBadge("repo not found"),
+				logLevel: slog.LevelInfo,
+				warmTTL:  notFoundTTL,
+			},
+		},
+		{
+			name: "provider rate limited",
+			err: pkgErrors.Wrap(
+				&UpstreamError{StatusCode: http.StatusTooManyRequests},
+				"error retrieving pipelines",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("rate limited"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "provider server error",
+			err: pkgErrors.Wrap(
+				&UpstreamError{StatusCode: http.StatusServiceUnavailable},
+				"error retrieving pipelines",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "unclassified provider error",
+			err: pkgErrors.Wrap(
+				&UpstreamError{StatusCode: http.StatusUnauthorized},
+				"error retrieving pipelines",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge(http.StatusInternalServerError),
+				logLevel:     slog.LevelError,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "network error",
+			err: pkgErrors.Wrap(
+				&url.Error{
+					Op:  http.MethodGet,
+					URL: "https://api.github.com",
+					Err: errors.New("connection refused"),
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("upstream unavailable"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "deadline exceeded",
+			err: pkgErrors.Wrap(
+				context.DeadlineExceeded,
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge("timeout"),
+				logLevel:     slog.LevelWarn,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "unclassified github error",
+			err: pkgErrors.Wrap(
+				&github.ErrorResponse{
+					Response: &http.Response{StatusCode: http.StatusUnauthorized},
+				},
+				"error retrieving check suites",
+			),
+			expectedFailure: failure{
+				badge:        NewErrBadge(http.StatusInternalServerError),
+				logLevel:     slog.LevelError,
+				useColdCache: true,
+			},
+		},
+		{
+			name: "unclassified error",
+			err:  errors.New("something went wrong"),
+			expectedFailure: failure{
+				badge:        NewErrBadge(http.StatusInternalServerError),
+				logLevel:     slog.LevelError,
+				useColdCache: true,
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.expectedFailure,
+				classifyError(testCase.err),
+			)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/pkg/errors"
+)
+
+// Route is the route of badges based on Gitea commit statuses.
+const Route = "/v1/gitea/statuses/{owner}/{repo}/badge.svg"
+
+// maxStatuses is the number of statuses requested at once. It matches Gitea's
+// default maximum page size, which is more distinct contexts than any sane ref
+// reports statuses for.
+const maxStatuses = 50
+
+// Config represents configuration options for the Gitea provider. Because
+// Forgejo retains Gitea's API, it works equally well with Forgejo instances.
+type Config struct {
+	// BaseURL is the base URL of the Gitea instance, e.g.
+	// https://gitea.example.com.
+	BaseURL string
+	// Token, if non-empty, is an access token used to authenticate to Gitea.
+	// This is required for badges for private repositories.
+	Token string
+}
+
+func init() {
+	badges.RegisterProviderFactory("gitea", newConfiguredProvider)
+}
+
+// configFromSettings populates configuration for the Gitea provider from
+// the provided settings.
+func configFromSettings(settings badges.Settings) (Config, error) {
+	config := Config{
+		Token: settings.Setting("GITEA_TOKEN"),
+	}
+	var err error
+	config.BaseURL, err = badges.URLSetting(settings, "GITEA_BASE_URL")
+	return config, err
+}
+
+// newConfiguredProvider returns a Gitea provider configured from the
+// provided settings, or nil if GITEA_BASE_URL is unset.
+func newConfiguredProvider(
+	settings badges.Settings,
+) (badges.Provider, error) {
+	config, err := configFromSettings(settings)
+	if err != nil || config.BaseURL == "" {
+		return nil, err
+	}
+	return NewProvider(config), nil
+}
+
+// Options represents options for a badge based on Gitea commit statuses.
+type Options struct {
+	// BadgeName specifies a name that should be applied to the badge. If left
+	// unspecified, it will default to "build".
+	BadgeName string
+	// Ref indicates the branch, tag, or commit upon whose statuses the badge
+	// should be based. If left unspecified, it will default to "main".
+	Ref string
+}
+
+// combinedStatus is the subset of a Gitea combined commit status that Badgr is
+// interested in. Gitea includes only the latest status for each context.
+type combinedStatus struct {
+	Statuses []commitStatus `json:"statuses"`
+}
+
+// commitStatus is the subset of a Gitea commit status that Badgr is interested
+// in.
+type commitStatus struct {
+	Status string `json:"status"`
+}
+
+type provider struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// NewProvider returns an implementation of the badges.Provider interface that
+// serves badges based on the combined commit status of a ref of a Gitea
+// repository.
+func NewProvider(config Config) badges.Provider {
+	return &provider{
+		config:     config,
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *provider) Name() string {
+	return "gitea-statuses"
+}
+
+func (p *provider) Route() string {
+	return Route
+}
+
+func (p *provider) Subject(vars map[string]string) badges.Subject {
+	return badges.RepoSubject(vars)
+}
+
+func (p *provider) ParseOptions(query url.Values) (interface{}, error) {
+	return &Options{
+		BadgeName: query.Get("name"),
+		Ref:       query.Get("branch"),
+	}, nil
+}
+
+func (p *provider) Fetch(
+	ctx context.Context,
+	req badges.BadgeRequest,
+) (badges.Badge, error) {
+	opts, ok := req.Options.(*Options)
+	if !ok {
+		return nil, errors.Errorf("unexpected options type %T", req.Options)
+	}
+	ref := opts.Ref
+	if ref == "" {
+		ref = "main"
+	}
+
+	// Gitea's API routes {ref} as a single path segment, so a ref containing
+	// slashes, like feature/foo, must be escaped in its entirety, as Gitea's own
+	// Go SDK does. Escaping each of its segments separately would yield a path
+	// that matches no route at all.
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/api/v1/repos/%s/%s/commits/%s/status?limit=%d",
+			strings.TrimSuffix(p.config.BaseURL, "/"),
+			url.PathEscape(req.Owner),
+			url.PathEscape(req.Repo),
+			url.PathEscape(ref),
+			maxStatuses,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Gitea request")
+	}
+	if p.config.Token != "" {
+		httpReq.Header.Set("Authorization", "token "+p.config.Token)
+	}
+	status := combinedStatus{}
+	if err = badges.GetJSON(ctx, p.httpClient, httpReq, &status); err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error retrieving combined status for Gitea repository %s/%s",
+			req.Owner,
+			req.Repo,
+		)
+	}
+	statuses := make([]badges.CheckStatus, len(status.Statuses))
+	for i, commitStatus := range status.Statuses {
+		statuses[i] = checkStatus(commitStatus.Status)
+	}
+	return badges.NewCheckBadge(
+		opts.BadgeName,
+		badges.MostSevereCheckStatus(statuses...),
+	), nil
+}
+
+// checkStatus maps the state of a single Gitea commit status onto a
+// CheckStatus.
+func checkStatus(state string) badges.CheckStatus {
+	switch state {
+	case "success":
+		return badges.CheckStatusPassed
+	case "failure", "error":
+		return badges.CheckStatusFailed
+	case "pending":
+		// Gitea doesn't distinguish between statuses that are queued and those
+		// that are running
+		return badges.CheckStatusInProgress
+	case "warning", "skipped":
+		return badges.CheckStatusNeutral
+	default:
+		return badges.CheckStatusUnknown
+	}
+}
@@ -0,0 +1,184 @@
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfiguredProvider(t *testing.T) {
+	// Providers whose backends aren't configured aren't registered at all
+	p, err := newConfiguredProvider(badges.SettingsMap{})
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = newConfiguredProvider(
+		badges.SettingsMap{
+			"GITEA_BASE_URL": "https://gitea.example.com",
+			"GITEA_TOKEN":    "foo",
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Config{
+			BaseURL: "https://gitea.example.com",
+			Token:   "foo",
+		},
+		p.(*provider).config,
+	)
+}
+
+func TestProviderParseOptions(t *testing.T) {
+	opts, err := NewProvider(Config{}).ParseOptions(
+		url.Values{
+			"name":   []string{"tests"},
+			"branch": []string{"v2"},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, &Options{BadgeName: "tests", Ref: "v2"}, opts)
+}
+
+func TestProviderFetch(t *testing.T) {
+	const testToken = "foo"
+	testCases := []struct {
+		name       string
+		opts       *Options
+		handler    http.HandlerFunc
+		assertions func(badges.Badge, error)
+	}{
+		{
+			name: "no statuses",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, `{"state":"","statuses":[]}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusUnknown),
+					badge,
+				)
+			},
+		},
+		{
+			name: "defaults",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(
+					t,
+					"/api/v1/repos/owner/repo/commits/main/status",
+					r.URL.EscapedPath(),
+				)
+				fmt.Fprint(w, `{"statuses":[{"status":"success"}]}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusPassed),
+					badge,
+				)
+			},
+		},
+		{
+			name: "ref containing slashes",
+			opts: &Options{Ref: "feature/foo"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// The ref is a single path segment as far as Gitea is concerned
+				require.Equal(
+					t,
+					"/api/v1/repos/owner/repo/commits/feature%2Ffoo/status",
+					r.URL.EscapedPath(),
+				)
+				fmt.Fprint(w, `{"statuses":[{"status":"success"}]}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusPassed),
+					badge,
+				)
+			},
+		},
+		{
+			name: "most severe status for ref",
+			opts: &Options{BadgeName: "tests", Ref: "v2"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(
+					t,
+					"token "+testToken,
+					r.Header.Get("Authorization"),
+				)
+				require.Equal(
+					t,
+					"/api/v1/repos/owner/repo/commits/v2/status",
+					r.URL.EscapedPath(),
+				)
+				require.Equal(t, "50", r.URL.Query().Get("limit"))
+				fmt.Fprint(
+					w,
+					`{"statuses":[`+
+						`{"status":"success"},`+
+						`{"status":"failure"},`+
+						`{"status":"pending"}`+
+						`]}`,
+				)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("tests", badges.CheckStatusFailed),
+					badge,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+			testCase.assertions(
+				NewProvider(
+					Config{
+						BaseURL: server.URL + "/",
+						Token:   testToken,
+					},
+				).Fetch(
+					context.Background(),
+					badges.BadgeRequest{
+						Owner:   "owner",
+						Repo:    "repo",
+						Options: testCase.opts,
+					},
+				),
+			)
+		})
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	testCases := map[string]badges.CheckStatus{
+		"success": badges.CheckStatusPassed,
+		"failure": badges.CheckStatusFailed,
+		"error":   badges.CheckStatusFailed,
+		"pending": badges.CheckStatusInProgress,
+		"warning": badges.CheckStatusNeutral,
+		"skipped": badges.CheckStatusNeutral,
+		"bogus":   badges.CheckStatusUnknown,
+	}
+	for state, expected := range testCases {
+		t.Run(state, func(t *testing.T) {
+			require.Equal(t, expected, checkStatus(state))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package badges
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// GitHubChecksRoute is the route of badges based on GitHub check suites.
+const GitHubChecksRoute = "/v1/github/checks/{owner}/{repo}/badge.svg"
+
+// githubChecksProvider is an implementation of the Provider interface that
+// serves badges based on GitHub check suites by delegating to a Service. It
+// also implements the RepoVisibility interface, so that signed URLs may be
+// required for badges for private repositories.
+type githubChecksProvider struct {
+	service    Service
+	visibility RepoVisibility
+}
+
+// NewGitHubChecksProvider returns an implementation of the Provider interface
+// that serves badges based on GitHub check suites by delegating to the provided
+// Service, and determines whether repositories are private by delegating to
+// the provided RepoVisibility.
+func NewGitHubChecksProvider(
+	service Service,
+	visibility RepoVisibility,
+) Provider {
+	return &githubChecksProvider{
+		service:    service,
+		visibility: visibility,
+	}
+}
+
+func (g *githubChecksProvider) Name() string {
+	return "github-checks"
+}
+
+func (g *githubChecksProvider) Route() string {
+	return GitHubChecksRoute
+}
+
+func (g *githubChecksProvider) Subject(vars map[string]string) Subject {
+	return RepoSubject(vars)
+}
+
+func (g *githubChecksProvider) IsPrivate(
+	ctx context.Context,
+	owner string,
+	repo string,
+) (bool, error) {
+	return g.visibility.IsPrivate(ctx, owner, repo)
+}
+
+func (g *githubChecksProvider) ParseOptions(
+	query url.Values,
+) (interface{}, error) {
+	opts := &CheckBadgeOptions{
+		BadgeName: query.Get("name"),
+		Branch:    query.Get("branch"),
+	}
+	if appIDStr := query.Get("appID"); appIDStr != "" {
+		var err error
+		if opts.GitHubAppID, err = strconv.Atoi(appIDStr); err != nil {
+			return nil, errors.Wrapf(err, "invalid appID %q", appIDStr)
+		}
+	}
+	return opts, nil
+}
+
+func (g *githubChecksProvider) Fetch(
+	ctx context.Context,
+	req BadgeRequest,
+) (Badge, error) {
+	opts, ok := req.Options.(*CheckBadgeOptions)
+	if !ok {
+		return nil, errors.Errorf("unexpected options type %T", req.Options)
+	}
+	// The pages of the last known result permit the Service to make conditional
+	// requests. Options are copied so the caller's are left as they were.
+	optsCopy := *opts
+	if req.Cached != nil {
+		optsCopy.CachedPages = req.Cached.Pages
+	}
+	badge, err := g.service.CheckBadge(ctx, req.Owner, req.Repo, &optsCopy)
+	if err != nil {
+		return nil, err
+	}
+	return badge, nil
+}
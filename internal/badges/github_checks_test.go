@@ -0,0 +1,138 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubChecksProviderParseOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		assertions func(interface{}, error)
+	}{
+		{
+			name:  "invalid appID",
+			query: "appID=foo",
+			assertions: func(_ interface{}, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid appID")
+			},
+		},
+		{
+			name:  "no options",
+			query: "",
+			assertions: func(opts interface{}, err error) {
+				require.NoError(t, err)
+				require.Equal(t, &CheckBadgeOptions{}, opts)
+			},
+		},
+		{
+			name:  "all options",
+			query: "appID=42&branch=v2&name=build",
+			assertions: func(opts interface{}, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					&CheckBadgeOptions{
+						BadgeName:   "build",
+						Branch:      "v2",
+						GitHubAppID: 42,
+					},
+					opts,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			query, err := url.ParseQuery(testCase.query)
+			require.NoError(t, err)
+			testCase.assertions(
+				NewGitHubChecksProvider(nil, nil).ParseOptions(query),
+			)
+		})
+	}
+}
+
+func TestGitHubChecksProviderFetch(t *testing.T) {
+	testBadge := CheckBadge{
+		name:   "build",
+		status: CheckStatusPassed,
+		pages:  []CheckSuitePage{{ETag: "etag", Count: 1}},
+	}
+	testCases := []struct {
+		name       string
+		req        BadgeRequest
+		service    *mockService
+		assertions func(Badge, error)
+	}{
+		{
+			name: "service error",
+			req:  BadgeRequest{Options: &CheckBadgeOptions{}},
+			service: &mockService{
+				CheckBadgeFn: func(
+					context.Context,
+					string,
+					string,
+					*CheckBadgeOptions,
+				) (CheckBadge, error) {
+					return CheckBadge{}, errors.New("something went wrong")
+				},
+			},
+			assertions: func(badge Badge, err error) {
+				require.Error(t, err)
+				require.Nil(t, badge)
+			},
+		},
+		{
+			name: "success",
+			req: BadgeRequest{
+				Owner:   "krancour",
+				Repo:    "foo",
+				Options: &CheckBadgeOptions{Branch: "v2"},
+				Cached:  &BadgeRecord{Pages: testBadge.pages},
+			},
+			service: &mockService{
+				CheckBadgeFn: func(
+					_ context.Context,
+					owner string,
+					repo string,
+					opts *CheckBadgeOptions,
+				) (CheckBadge, error) {
+					require.Equal(t, "krancour", owner)
+					require.Equal(t, "foo", repo)
+					// The service should have been given what it needs to make
+					// conditional requests
+					require.Equal(
+						t,
+						&CheckBadgeOptions{
+							Branch:      "v2",
+							CachedPages: testBadge.pages,
+						},
+						opts,
+					)
+					return testBadge, nil
+				},
+			},
+			assertions: func(badge Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(t, testBadge, badge)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				NewGitHubChecksProvider(testCase.service, nil).Fetch(
+					context.Background(),
+					testCase.req,
+				),
+			)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/pkg/errors"
+)
+
+// Route is the route of badges based on GitLab pipelines. The project is
+// identified either by its full path, including its namespace and any
+// subgroups, or by its numeric ID, just as it is by the GitLab API.
+const Route = "/v1/gitlab/pipelines/{project:.+}/badge.svg"
+
+// Config represents configuration options for the GitLab provider.
+type Config struct {
+	// BaseURL is the base URL of the GitLab instance, e.g.
+	// https://gitlab.example.com.
+	BaseURL string
+	// Token, if non-empty, is an access token used to authenticate to GitLab.
+	// This is required for badges for private projects.
+	Token string
+}
+
+func init() {
+	badges.RegisterProviderFactory("gitlab", newConfiguredProvider)
+}
+
+// configFromSettings populates configuration for the GitLab provider from
+// the provided settings.
+func configFromSettings(settings badges.Settings) (Config, error) {
+	config := Config{
+		Token: settings.Setting("GITLAB_TOKEN"),
+	}
+	var err error
+	config.BaseURL, err = badges.URLSetting(settings, "GITLAB_BASE_URL")
+	return config, err
+}
+
+// newConfiguredProvider returns a GitLab provider configured from the
+// provided settings, or nil if GITLAB_BASE_URL is unset.
+func newConfiguredProvider(
+	settings badges.Settings,
+) (badges.Provider, error) {
+	config, err := configFromSettings(settings)
+	if err != nil || config.BaseURL == "" {
+		return nil, err
+	}
+	return NewProvider(config), nil
+}
+
+// Options represents options for a badge based on GitLab pipelines.
+type Options struct {
+	// BadgeName specifies a name that should be applied to the badge. If left
+	// unspecified, it will default to "build".
+	BadgeName string
+	// Ref indicates the branch or tag upon whose latest pipeline the badge
+	// should be based. If left unspecified, it will default to "main".
+	Ref string
+}
+
+// pipeline is the subset of a GitLab pipeline that Badgr is interested in.
+type pipeline struct {
+	Status string `json:"status"`
+}
+
+type provider struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// NewProvider returns an implementation of the badges.Provider interface that
+// serves badges based on the status of the latest pipeline for a ref of a
+// GitLab project.
+func NewProvider(config Config) badges.Provider {
+	return &provider{
+		config:     config,
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *provider) Name() string {
+	return "gitlab-pipelines"
+}
+
+func (p *provider) Route() string {
+	return Route
+}
+
+// Subject returns the project's namespace, including any subgroups, as the
+// owner and the project's path within it as the repo. A project identified by
+// its numeric ID has no namespace Badgr knows of, so the ID itself stands in
+// for the owner.
+func (p *provider) Subject(vars map[string]string) badges.Subject {
+	return badges.PathSubject(vars["project"])
+}
+
+func (p *provider) ParseOptions(query url.Values) (interface{}, error) {
+	return &Options{
+		BadgeName: query.Get("name"),
+		Ref:       query.Get("branch"),
+	}, nil
+}
+
+func (p *provider) Fetch(
+	ctx context.Context,
+	req badges.BadgeRequest,
+) (badges.Badge, error) {
+	opts, ok := req.Options.(*Options)
+	if !ok {
+		return nil, errors.Errorf("unexpected options type %T", req.Options)
+	}
+	ref := opts.Ref
+	if ref == "" {
+		ref = "main"
+	}
+
+	// Projects are identified by their URL-encoded path, slashes and all, or by
+	// their ID
+	project := badges.Subject{Owner: req.Owner, Repo: req.Repo}.String()
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/api/v4/projects/%s/pipelines?%s",
+			strings.TrimSuffix(p.config.BaseURL, "/"),
+			url.PathEscape(project),
+			url.Values{
+				"ref":      []string{ref},
+				"order_by": []string{"id"},
+				"sort":     []string{"desc"},
+				"per_page": []string{"1"},
+			}.Encode(),
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating GitLab request")
+	}
+	if p.config.Token != "" {
+		httpReq.Header.Set("PRIVATE-TOKEN", p.config.Token)
+	}
+	pipelines := []pipeline{}
+	if err = badges.GetJSON(ctx, p.httpClient, httpReq, &pipelines); err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error retrieving pipelines for GitLab project %s",
+			project,
+		)
+	}
+	// GitLab doesn't distinguish between a ref that doesn't exist and one with
+	// no pipelines
+	if len(pipelines) == 0 {
+		return badges.NewCheckBadge(opts.BadgeName, badges.CheckStatusUnknown), nil
+	}
+	return badges.NewCheckBadge(
+		opts.BadgeName,
+		pipelineStatus(pipelines[0].Status),
+	), nil
+}
+
+// pipelineStatus maps the status of a GitLab pipeline onto a CheckStatus.
+func pipelineStatus(status string) badges.CheckStatus {
+	switch status {
+	case "success":
+		return badges.CheckStatusPassed
+	case "failed":
+		return badges.CheckStatusFailed
+	case "running":
+		return badges.CheckStatusInProgress
+	case "created", "waiting_for_resource", "preparing", "pending", "scheduled":
+		return badges.CheckStatusQueued
+	case "canceled":
+		return badges.CheckStatusCanceled
+	case "skipped":
+		return badges.CheckStatusNeutral
+	case "manual":
+		return badges.CheckStatusActionRequired
+	default:
+		return badges.CheckStatusUnknown
+	}
+}
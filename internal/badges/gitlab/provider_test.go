@@ -0,0 +1,221 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	testCases := []struct {
+		name            string
+		path            string
+		expectedProject string
+		expectedSubject badges.Subject
+	}{
+		{
+			name:            "project path",
+			path:            "/v1/gitlab/pipelines/group/subgroup/project/badge.svg",
+			expectedProject: "group/subgroup/project",
+			expectedSubject: badges.Subject{Owner: "group/subgroup", Repo: "project"},
+		},
+		{
+			name:            "project ID",
+			path:            "/v1/gitlab/pipelines/42/badge.svg",
+			expectedProject: "42",
+			expectedSubject: badges.Subject{Owner: "42"},
+		},
+	}
+	router := mux.NewRouter()
+	router.Handle(Route, http.NotFoundHandler())
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			match := mux.RouteMatch{}
+			require.True(
+				t,
+				router.Match(
+					httptest.NewRequest(http.MethodGet, testCase.path, nil),
+					&match,
+				),
+			)
+			require.Equal(t, testCase.expectedProject, match.Vars["project"])
+			require.Equal(
+				t,
+				testCase.expectedSubject,
+				NewProvider(Config{}).Subject(match.Vars),
+			)
+		})
+	}
+}
+
+func TestNewConfiguredProvider(t *testing.T) {
+	// Providers whose backends aren't configured aren't registered at all
+	p, err := newConfiguredProvider(badges.SettingsMap{})
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = newConfiguredProvider(
+		badges.SettingsMap{
+			"GITLAB_BASE_URL": "https://gitlab.example.com",
+			"GITLAB_TOKEN":    "foo",
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Config{
+			BaseURL: "https://gitlab.example.com",
+			Token:   "foo",
+		},
+		p.(*provider).config,
+	)
+}
+
+func TestProviderParseOptions(t *testing.T) {
+	opts, err := NewProvider(Config{}).ParseOptions(
+		url.Values{
+			"name":   []string{"tests"},
+			"branch": []string{"v2"},
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, &Options{BadgeName: "tests", Ref: "v2"}, opts)
+}
+
+func TestProviderFetch(t *testing.T) {
+	const testToken = "foo"
+	testCases := []struct {
+		name       string
+		subject    badges.Subject
+		opts       *Options
+		handler    http.HandlerFunc
+		assertions func(badges.Badge, error)
+	}{
+		{
+			name: "no pipelines",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, "[]")
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusUnknown),
+					badge,
+				)
+			},
+		},
+		{
+			name: "defaults",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "main", r.URL.Query().Get("ref"))
+				fmt.Fprint(w, `[{"id":2,"status":"running"}]`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusInProgress),
+					badge,
+				)
+			},
+		},
+		{
+			name:    "project by ID",
+			subject: badges.Subject{Owner: "42"},
+			opts:    &Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/api/v4/projects/42/pipelines", r.URL.EscapedPath())
+				fmt.Fprint(w, `[{"id":2,"status":"failed"}]`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusFailed),
+					badge,
+				)
+			},
+		},
+		{
+			name: "latest pipeline for ref",
+			opts: &Options{BadgeName: "tests", Ref: "v2"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, testToken, r.Header.Get("PRIVATE-TOKEN"))
+				require.Equal(
+					t,
+					"/api/v4/projects/group%2Fsubgroup%2Fproject/pipelines",
+					r.URL.EscapedPath(),
+				)
+				require.Equal(t, "v2", r.URL.Query().Get("ref"))
+				require.Equal(t, "1", r.URL.Query().Get("per_page"))
+				require.Equal(t, "id", r.URL.Query().Get("order_by"))
+				require.Equal(t, "desc", r.URL.Query().Get("sort"))
+				fmt.Fprint(w, `[{"id":2,"status":"success"}]`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("tests", badges.CheckStatusPassed),
+					badge,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+			subject := testCase.subject
+			if subject == (badges.Subject{}) {
+				subject = badges.Subject{Owner: "group/subgroup", Repo: "project"}
+			}
+			testCase.assertions(
+				NewProvider(
+					Config{
+						BaseURL: server.URL + "/",
+						Token:   testToken,
+					},
+				).Fetch(
+					context.Background(),
+					badges.BadgeRequest{
+						Owner:   subject.Owner,
+						Repo:    subject.Repo,
+						Options: testCase.opts,
+					},
+				),
+			)
+		})
+	}
+}
+
+func TestPipelineStatus(t *testing.T) {
+	testCases := map[string]badges.CheckStatus{
+		"success":              badges.CheckStatusPassed,
+		"failed":               badges.CheckStatusFailed,
+		"running":              badges.CheckStatusInProgress,
+		"pending":              badges.CheckStatusQueued,
+		"created":              badges.CheckStatusQueued,
+		"waiting_for_resource": badges.CheckStatusQueued,
+		"preparing":            badges.CheckStatusQueued,
+		"scheduled":            badges.CheckStatusQueued,
+		"canceled":             badges.CheckStatusCanceled,
+		"skipped":              badges.CheckStatusNeutral,
+		"manual":               badges.CheckStatusActionRequired,
+		"bogus":                badges.CheckStatusUnknown,
+	}
+	for status, expected := range testCases {
+		t.Run(status, func(t *testing.T) {
+			require.Equal(t, expected, pipelineStatus(status))
+		})
+	}
+}
@@ -0,0 +1,547 @@
+package badges
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBatchSize is the maximum number of refs whose check suites are retrieved
+// in a single query.
+const maxBatchSize = 25
+
+// graphqlService is an implementation of the Service interface that retrieves
+// check suites using GitHub's GraphQL API. Unlike the REST API, the GraphQL API
+// permits check suites for many refs, possibly belonging to many repositories,
+// to be retrieved in a single round trip. Requests for badges that arrive
+// within config.BatchWindow of one another are batched into a single query to
+// take advantage of this. Results are identical to those obtained from the
+// REST API, except that the GraphQL API does not support conditional requests,
+// so pages never carry ETags.
+//
+// The GraphQL API also offers a commit's statusCheckRollup, but it is
+// deliberately not used. The rollup's state also reflects commit statuses and
+// the check suites of every GitHub App, so it cannot be narrowed to a single
+// app as an appID requires, and a badge derived from it would not be identical
+// to one derived from check suites by the REST API.
+type graphqlService struct {
+	// The REST-based service is embedded for everything that isn't retrieving
+	// check suites
+	*service
+	// queryFn is usually implemented using a GitHub client, but is overridable
+	// for testing purposes
+	queryFn func(
+		ctx context.Context,
+		query string,
+		variables map[string]interface{},
+	) (*graphqlResponse, *github.Response, error)
+	// batchMu guards batch
+	batchMu sync.Mutex
+	// batch is the batch of queries currently accepting further queries, if
+	// any
+	batch *checkSuitesBatch
+}
+
+func newGraphQLService(s *service) *graphqlService {
+	g := &graphqlService{service: s}
+	g.queryFn = g.query
+	return g
+}
+
+// checkSuitesQuery identifies a ref whose check suites should be retrieved.
+type checkSuitesQuery struct {
+	owner string
+	repo  string
+	ref   string
+	appID int
+	// expectedPages is our best guess at how many pages of check suites there
+	// are
+	expectedPages int
+}
+
+// checkSuitesBatch is a batch of queries whose check suites are retrieved
+// together.
+type checkSuitesBatch struct {
+	queries []checkSuitesQuery
+	// ctx is the context of the first query in the batch, less its deadline and
+	// cancellation, so that queries are traced as part of that request
+	ctx context.Context
+	// deadline is the latest of the deadlines of the queries in the batch. It
+	// is zero if any of them has no deadline.
+	deadline   time.Time
+	noDeadline bool
+	// started indicates that the batch is no longer accepting queries
+	started bool
+	// done is closed once results and errs are populated
+	done    chan struct{}
+	results [][]CheckSuitePage
+	errs    []error
+}
+
+func (g *graphqlService) CheckBadge(
+	ctx context.Context,
+	owner string,
+	repo string,
+	opts *CheckBadgeOptions,
+) (_ CheckBadge, err error) {
+	if opts == nil {
+		opts = &CheckBadgeOptions{}
+	}
+	if opts.BadgeName == "" {
+		opts.BadgeName = "build"
+	}
+	if opts.Branch == "" {
+		opts.Branch = "main"
+	}
+
+	ctx, span := tracer.Start(
+		ctx,
+		"badges.graphqlService.CheckBadge",
+		trace.WithAttributes(
+			attribute.String("badgr.owner", owner),
+			attribute.String("badgr.repo", repo),
+			attribute.String("badgr.branch", opts.Branch),
+			attribute.Int("badgr.github_app_id", opts.GitHubAppID),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+
+	badge := CheckBadge{
+		name:   opts.BadgeName,
+		status: CheckStatusUnknown,
+	}
+	pages, err := g.batchedCheckSuitePages(
+		ctx,
+		checkSuitesQuery{
+			owner:         owner,
+			repo:          repo,
+			ref:           opts.Branch,
+			appID:         opts.GitHubAppID,
+			expectedPages: len(opts.CachedPages),
+		},
+	)
+	if err != nil {
+		if opts.GitHubAppID == 0 {
+			return badge, errors.Wrapf(
+				err,
+				"error retrieving check suites for owner %q, repo %q, branch %q "+
+					"from GitHub",
+				owner,
+				repo,
+				opts.Branch,
+			)
+		}
+		return badge, errors.Wrapf(
+			err,
+			"error retrieving check suites for appID %d, owner %q, repo %q, "+
+				"branch %q from GitHub",
+			opts.GitHubAppID,
+			owner,
+			repo,
+			opts.Branch,
+		)
+	}
+	checkBadgePages.Observe(float64(len(pages)))
+	badge.status = pagesStatus(pages)
+	badge.pages = pages
+	return badge, nil
+}
+
+// batchedCheckSuitePages retrieves and summarizes all pages of check suites for
+// the provided query. Unless batching is disabled, the query joins a batch of
+// queries from other requests that arrive within config.BatchWindow, all of
+// which are executed together. The batch is executed sooner if it reaches
+// maxBatchSize queries.
+func (g *graphqlService) batchedCheckSuitePages(
+	ctx context.Context,
+	q checkSuitesQuery,
+) ([]CheckSuitePage, error) {
+	if g.config.BatchWindow <= 0 {
+		results, errs := g.checkSuitePages(ctx, []checkSuitesQuery{q})
+		return results[0], errs[0]
+	}
+	g.batchMu.Lock()
+	b := g.batch
+	if b == nil {
+		b = &checkSuitesBatch{
+			ctx:  context.WithoutCancel(ctx),
+			done: make(chan struct{}),
+		}
+		g.batch = b
+		time.AfterFunc(g.config.BatchWindow, func() { g.runBatch(b) })
+	}
+	i := len(b.queries)
+	b.queries = append(b.queries, q)
+	if deadline, ok := ctx.Deadline(); !ok {
+		b.noDeadline = true
+	} else if deadline.After(b.deadline) {
+		b.deadline = deadline
+	}
+	full := len(b.queries) == maxBatchSize
+	g.batchMu.Unlock()
+	if full {
+		go g.runBatch(b)
+	}
+	trace.SpanFromContext(ctx).AddEvent("waiting for batch")
+	select {
+	case <-b.done:
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.Int("badgr.batch_size", len(b.queries)),
+		)
+		return b.results[i], b.errs[i]
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runBatch retrieves check suites for every query in the provided batch, unless
+// that is already under way.
+func (g *graphqlService) runBatch(b *checkSuitesBatch) {
+	g.batchMu.Lock()
+	if b.started {
+		g.batchMu.Unlock()
+		return
+	}
+	b.started = true
+	if g.batch == b {
+		g.batch = nil
+	}
+	g.batchMu.Unlock()
+	ctx := b.ctx
+	if !b.noDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, b.deadline)
+		defer cancel()
+	}
+	b.results, b.errs = g.checkSuitePages(ctx, b.queries)
+	close(b.done)
+}
+
+// checkSuitePages retrieves and summarizes all pages of check suites for each
+// of the provided queries. Each round trip to GitHub retrieves the next page
+// for every query that has one. As with the REST-based implementation, pages
+// following one whose status makes the overall status a foregone conclusion
+// are not retrieved and are left blank. Results and errors are indexed by
+// query, so that a failure pertaining to one query does not affect others.
+func (g *graphqlService) checkSuitePages(
+	ctx context.Context,
+	queries []checkSuitesQuery,
+) ([][]CheckSuitePage, []error) {
+	results := make([][]CheckSuitePage, len(queries))
+	errs := make([]error, len(queries))
+	cursors := make([]*string, len(queries))
+	pending := make([]int, len(queries))
+	var expectedRounds int
+	for i, q := range queries {
+		pending[i] = i
+		if q.expectedPages > expectedRounds {
+			expectedRounds = q.expectedPages
+		}
+	}
+	for round := 1; len(pending) > 0; round++ {
+		data, queryErrs, err := g.checkSuitesRound(
+			ctx,
+			queries,
+			cursors,
+			pending,
+			expectedRounds-round+1,
+		)
+		if err != nil {
+			for _, i := range pending {
+				errs[i] = err
+				results[i] = nil
+			}
+			break
+		}
+		var stillPending []int
+		for _, i := range pending {
+			if queryErrs[i] != nil {
+				errs[i] = queryErrs[i]
+				results[i] = nil
+				continue
+			}
+			checkSuites := data[i]
+			page := CheckSuitePage{
+				Count:  len(checkSuites.Nodes),
+				Status: checkStatus(checkSuites.checkSuites()),
+			}
+			if results[i] == nil {
+				lastPage := (checkSuites.TotalCount + checkSuitesPerPage - 1) /
+					checkSuitesPerPage
+				if lastPage < 1 {
+					lastPage = 1
+				}
+				if lastPage > expectedRounds {
+					expectedRounds = lastPage
+				}
+				results[i] = make([]CheckSuitePage, lastPage)
+			}
+			if round <= len(results[i]) {
+				results[i][round-1] = page
+			}
+			if checkSuites.PageInfo.HasNextPage && !page.final() &&
+				round < len(results[i]) {
+				cursors[i] = &checkSuites.PageInfo.EndCursor
+				stillPending = append(stillPending, i)
+			}
+		}
+		pending = stillPending
+	}
+	for _, pages := range results {
+		for i := range pages {
+			pages[i].HasNext = i < len(pages)-1
+		}
+	}
+	return results, errs
+}
+
+// checkSuitesRound retrieves, in a single round trip to GitHub, the next page
+// of check suites for each of the specified pending queries. Results, and
+// errors pertaining to individual queries, are indexed by query. If an error
+// pertaining to the round trip as a whole is returned, no query succeeded.
+func (g *graphqlService) checkSuitesRound(
+	ctx context.Context,
+	queries []checkSuitesQuery,
+	cursors []*string,
+	pending []int,
+	expectedRounds int,
+) (_ map[int]graphqlCheckSuites, _ map[int]error, err error) {
+	if err = g.rateLimits.check(rateLimitResourceGraphQL); err != nil {
+		return nil, nil, err
+	}
+	if err = g.breaker.allow(); err != nil {
+		return nil, nil, err
+	}
+	query, variables := buildCheckSuitesQuery(queries, cursors, pending)
+	ctx, span := tracer.Start(
+		ctx,
+		"GitHub GraphQL checkSuites",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("badgr.refs", len(pending))),
+	)
+	defer func() { endSpan(span, err) }()
+	fetchCtx, cancel := pageContext(ctx, expectedRounds)
+	start := time.Now()
+	resp, response, err := g.queryFn(fetchCtx, query, variables)
+	cancel()
+	observeGitHubRequest(githubEndpointGraphQL, start, response)
+	g.rateLimits.record(rateLimitResourceGraphQL, response, err)
+	g.breaker.record(time.Since(start), err)
+	if response != nil && response.Response != nil {
+		span.SetAttributes(
+			attribute.Int("http.status_code", response.StatusCode),
+		)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	results := map[int]graphqlCheckSuites{}
+	errs := map[int]error{}
+	for _, i := range pending {
+		alias := fmt.Sprintf("r%d", i)
+		repo := resp.Data[alias]
+		// Failures are reported in the same manner as the REST API would report
+		// them so that they are handled identically.
+		if repo == nil {
+			if gqlErr := resp.errorFor(alias); gqlErr != nil &&
+				gqlErr.Type != "NOT_FOUND" {
+				errs[i] = errors.Errorf("GitHub GraphQL error: %s", gqlErr.Message)
+			} else {
+				errs[i] =
+					graphqlErrorResponse(response, http.StatusNotFound, alias)
+			}
+			continue
+		}
+		if repo.Object == nil {
+			errs[i] = graphqlErrorResponse(
+				response,
+				http.StatusUnprocessableEntity,
+				alias,
+			)
+			continue
+		}
+		results[i] = repo.Object.CheckSuites
+	}
+	return results, errs, nil
+}
+
+// buildCheckSuitesQuery builds a GraphQL query that retrieves the next page of
+// check suites for each of the specified pending queries. Each is aliased by
+// its index so that results can be correlated with queries.
+func buildCheckSuitesQuery(
+	queries []checkSuitesQuery,
+	cursors []*string,
+	pending []int,
+) (string, map[string]interface{}) {
+	var params []string
+	var fields []string
+	variables := map[string]interface{}{}
+	for _, i := range pending {
+		q := queries[i]
+		params = append(
+			params,
+			fmt.Sprintf(
+				"$owner%d: String!, $name%d: String!, $ref%d: String!, "+
+					"$appId%d: Int, $after%d: String",
+				i, i, i, i, i,
+			),
+		)
+		fields = append(
+			fields,
+			fmt.Sprintf(
+				`r%d: repository(owner: $owner%d, name: $name%d) {
+    object(expression: $ref%d) {
+      ... on Commit {
+        checkSuites(first: %d, after: $after%d, filterBy: {appId: $appId%d}) {
+          totalCount
+          nodes { status conclusion }
+          pageInfo { hasNextPage endCursor }
+        }
+      }
+    }
+  }`,
+				i, i, i, i, checkSuitesPerPage, i, i,
+			),
+		)
+		variables[fmt.Sprintf("owner%d", i)] = q.owner
+		variables[fmt.Sprintf("name%d", i)] = q.repo
+		variables[fmt.Sprintf("ref%d", i)] = q.ref
+		var appID *int
+		if q.appID != 0 {
+			appID = &q.appID
+		}
+		variables[fmt.Sprintf("appId%d", i)] = appID
+		variables[fmt.Sprintf("after%d", i)] = cursors[i]
+	}
+	return fmt.Sprintf(
+		"query(%s) {\n  %s\n}",
+		strings.Join(params, ", "),
+		strings.Join(fields, "\n  "),
+	), variables
+}
+
+// query executes a GraphQL query.
+func (g *graphqlService) query(
+	ctx context.Context,
+	query string,
+	variables map[string]interface{},
+) (*graphqlResponse, *github.Response, error) {
+	// GitHub Enterprise Server serves the GraphQL API at /api/graphql rather
+	// than relative to the REST API's /api/v3/
+	path := "graphql"
+	if g.config.BaseURL != "" {
+		path = "../graphql"
+	}
+	req, err := g.githubClient.NewRequest(
+		http.MethodPost,
+		path,
+		map[string]interface{}{
+			"query":     query,
+			"variables": variables,
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := &graphqlResponse{}
+	response, err := g.githubClient.Do(ctx, req, resp)
+	if err != nil {
+		return nil, response, err
+	}
+	return resp, response, nil
+}
+
+// graphqlResponse is the response to a GraphQL query for check suites.
+type graphqlResponse struct {
+	Data   map[string]*graphqlRepository `json:"data"`
+	Errors []graphqlError                `json:"errors"`
+}
+
+// errorFor returns the first error pertaining to the specified alias or, if
+// there is none, the first error not pertaining to any alias in particular.
+func (g *graphqlResponse) errorFor(alias string) *graphqlError {
+	var general *graphqlError
+	for i, gqlErr := range g.Errors {
+		if len(gqlErr.Path) == 0 {
+			if general == nil {
+				general = &g.Errors[i]
+			}
+		} else if gqlErr.Path[0] == alias {
+			return &g.Errors[i]
+		}
+	}
+	return general
+}
+
+type graphqlError struct {
+	Type    string        `json:"type"`
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path"`
+}
+
+type graphqlRepository struct {
+	Object *struct {
+		CheckSuites graphqlCheckSuites `json:"checkSuites"`
+	} `json:"object"`
+}
+
+type graphqlCheckSuites struct {
+	TotalCount int `json:"totalCount"`
+	Nodes      []struct {
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+	} `json:"nodes"`
+	PageInfo struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+}
+
+// checkSuites converts check suites as represented by the GraphQL API to check
+// suites as represented by the REST API so that statuses are derived from them
+// in exactly the same manner.
+func (g graphqlCheckSuites) checkSuites() []*github.CheckSuite {
+	checkSuites := make([]*github.CheckSuite, len(g.Nodes))
+	for i, node := range g.Nodes {
+		checkSuites[i] = &github.CheckSuite{
+			Status: github.String(strings.ToLower(node.Status)),
+		}
+		if node.Conclusion != "" {
+			checkSuites[i].Conclusion =
+				github.String(strings.ToLower(node.Conclusion))
+		}
+	}
+	return checkSuites
+}
+
+// graphqlErrorResponse fabricates the error the REST API would have returned
+// for a missing repository or ref.
+func graphqlErrorResponse(
+	response *github.Response,
+	statusCode int,
+	alias string,
+) *github.ErrorResponse {
+	errResp := &github.ErrorResponse{
+		Response: &http.Response{StatusCode: statusCode},
+		Message: fmt.Sprintf(
+			"GitHub GraphQL API returned no result for %s",
+			alias,
+		),
+	}
+	if response != nil && response.Response != nil {
+		errResp.Response.Request = response.Request
+	}
+	if errResp.Response.Request == nil {
+		// ErrorResponse's Error() function requires a request
+		errResp.Response.Request = &http.Request{Method: http.MethodPost}
+	}
+	return errResp
+}
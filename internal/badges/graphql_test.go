@@ -0,0 +1,562 @@
+package badges
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGraphQLService(t *testing.T) {
+	rateLimits := NewRateLimits()
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{})
+	testConfig := ServiceConfig{
+		Backend: BackendGraphQL,
+		Token:   "token",
+	}
+	g, ok := NewService(testConfig, rateLimits, breaker).(*graphqlService)
+	require.True(t, ok)
+	require.Equal(t, testConfig, g.config)
+	require.Same(t, rateLimits, g.rateLimits)
+	require.Same(t, breaker, g.breaker)
+	require.NotNil(t, g.githubClient)
+	require.NotNil(t, g.getRateLimitsFn)
+	require.NotNil(t, g.queryFn)
+}
+
+// testCheckSuite returns a check suite as represented by the REST API.
+func testCheckSuite(status, conclusion string) *github.CheckSuite {
+	checkSuite := &github.CheckSuite{Status: github.String(status)}
+	if conclusion != "" {
+		checkSuite.Conclusion = github.String(conclusion)
+	}
+	return checkSuite
+}
+
+// testCheckSuites returns the specified number of identical check suites as
+// represented by the REST API.
+func testCheckSuites(
+	count int,
+	status string,
+	conclusion string,
+) []*github.CheckSuite {
+	checkSuites := make([]*github.CheckSuite, count)
+	for i := range checkSuites {
+		checkSuites[i] = testCheckSuite(status, conclusion)
+	}
+	return checkSuites
+}
+
+// restFixtureService returns a REST-based service that serves the provided
+// check suites, keyed by repo name, a page at a time. Refs named "missing" do
+// not exist.
+func restFixtureService(fixtures map[string][]*github.CheckSuite) *service {
+	return &service{
+		config:     ServiceConfig{Concurrency: 1},
+		rateLimits: NewRateLimits(),
+		breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+		listCheckSuitesForRefFn: func(
+			_ context.Context,
+			_ string,
+			repo string,
+			ref string,
+			opts *github.ListCheckSuiteOptions,
+			_ string,
+		) (*github.ListCheckSuiteResults, *github.Response, error) {
+			checkSuites, ok := fixtures[repo]
+			if !ok {
+				return nil, nil, &github.ErrorResponse{
+					Response: &http.Response{
+						StatusCode: http.StatusNotFound,
+						Request:    &http.Request{},
+					},
+				}
+			}
+			if ref == "missing" {
+				return nil, nil, &github.ErrorResponse{
+					Response: &http.Response{
+						StatusCode: http.StatusUnprocessableEntity,
+						Request:    &http.Request{},
+					},
+				}
+			}
+			start := (opts.Page - 1) * checkSuitesPerPage
+			end := start + checkSuitesPerPage
+			if end > len(checkSuites) {
+				end = len(checkSuites)
+			}
+			return &github.ListCheckSuiteResults{
+				Total:       github.Int(len(checkSuites)),
+				CheckSuites: checkSuites[start:end],
+			}, nil, nil
+		},
+	}
+}
+
+// graphqlFixtureService returns a GraphQL-based service that serves the
+// provided check suites, keyed by repo name, a page at a time. Refs named
+// "missing" do not exist. The number of queries executed is tallied.
+func graphqlFixtureService(
+	fixtures map[string][]*github.CheckSuite,
+	queries *int,
+) *graphqlService {
+	g := &graphqlService{
+		service: &service{
+			rateLimits: NewRateLimits(),
+			breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+		},
+	}
+	g.queryFn = func(
+		_ context.Context,
+		_ string,
+		variables map[string]interface{},
+	) (*graphqlResponse, *github.Response, error) {
+		*queries++
+		// Round trip through JSON so we're working with exactly what GitHub
+		// would see and exactly what the client would decode
+		varsJSON, err := json.Marshal(variables)
+		if err != nil {
+			return nil, nil, err
+		}
+		vars := map[string]interface{}{}
+		if err = json.Unmarshal(varsJSON, &vars); err != nil {
+			return nil, nil, err
+		}
+		data := map[string]interface{}{}
+		var gqlErrors []map[string]interface{}
+		for key := range vars {
+			if !strings.HasPrefix(key, "name") {
+				continue
+			}
+			i := strings.TrimPrefix(key, "name")
+			alias := "r" + i
+			checkSuites, ok := fixtures[vars[key].(string)]
+			if !ok {
+				data[alias] = nil
+				gqlErrors = append(
+					gqlErrors,
+					map[string]interface{}{
+						"type":    "NOT_FOUND",
+						"path":    []string{alias},
+						"message": "Could not resolve to a Repository",
+					},
+				)
+				continue
+			}
+			if vars["ref"+i] == "missing" {
+				data[alias] = map[string]interface{}{"object": nil}
+				continue
+			}
+			var start int
+			if after, ok := vars["after"+i].(string); ok {
+				if start, err = strconv.Atoi(after); err != nil {
+					return nil, nil, err
+				}
+			}
+			end := start + checkSuitesPerPage
+			if end > len(checkSuites) {
+				end = len(checkSuites)
+			}
+			nodes := []map[string]interface{}{}
+			for _, checkSuite := range checkSuites[start:end] {
+				node := map[string]interface{}{
+					"status":     strings.ToUpper(checkSuite.GetStatus()),
+					"conclusion": nil,
+				}
+				if checkSuite.Conclusion != nil {
+					node["conclusion"] = strings.ToUpper(checkSuite.GetConclusion())
+				}
+				nodes = append(nodes, node)
+			}
+			data[alias] = map[string]interface{}{
+				"object": map[string]interface{}{
+					"checkSuites": map[string]interface{}{
+						"totalCount": len(checkSuites),
+						"nodes":      nodes,
+						"pageInfo": map[string]interface{}{
+							"hasNextPage": end < len(checkSuites),
+							"endCursor":   strconv.Itoa(end),
+						},
+					},
+				},
+			}
+		}
+		respJSON, err := json.Marshal(
+			map[string]interface{}{
+				"data":   data,
+				"errors": gqlErrors,
+			},
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp := &graphqlResponse{}
+		return resp, nil, json.Unmarshal(respJSON, resp)
+	}
+	return g
+}
+
+func TestGraphQLServiceCheckBadgeParity(t *testing.T) {
+	fixtures := map[string][]*github.CheckSuite{
+		"empty":  {},
+		"passed": testCheckSuites(3, "completed", "success"),
+		"mixed": {
+			testCheckSuite("completed", "success"),
+			testCheckSuite("completed", "neutral"),
+			testCheckSuite("in_progress", ""),
+			testCheckSuite("queued", ""),
+		},
+		"canceled": {
+			testCheckSuite("completed", "success"),
+			testCheckSuite("completed", "cancelled"), // nolint: misspell
+		},
+		"multi-page passed": testCheckSuites(250, "completed", "success"),
+		"multi-page failed early": append(
+			testCheckSuites(150, "completed", "failure"),
+			testCheckSuites(150, "completed", "success")...,
+		),
+		"multi-page failed late": append(
+			testCheckSuites(250, "completed", "success"),
+			testCheckSuite("completed", "timed_out"),
+		),
+	}
+	testCases := []struct {
+		name   string
+		repo   string
+		branch string
+	}{
+		{name: "no check suites", repo: "empty"},
+		{name: "all passed", repo: "passed"},
+		{name: "mixed statuses", repo: "mixed"},
+		{name: "canceled", repo: "canceled"},
+		{name: "multiple pages, all passed", repo: "multi-page passed"},
+		{name: "multiple pages, failed early", repo: "multi-page failed early"},
+		{name: "multiple pages, failed late", repo: "multi-page failed late"},
+		{name: "repo not found", repo: "nonexistent"},
+		{name: "branch not found", repo: "passed", branch: "missing"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			opts := CheckBadgeOptions{Branch: testCase.branch}
+			restOpts := opts
+			restBadge, restErr := restFixtureService(fixtures).CheckBadge(
+				context.Background(),
+				"foo",
+				testCase.repo,
+				&restOpts,
+			)
+			var queries int
+			graphqlOpts := opts
+			graphqlBadge, graphqlErr := graphqlFixtureService(
+				fixtures,
+				&queries,
+			).CheckBadge(
+				context.Background(),
+				"foo",
+				testCase.repo,
+				&graphqlOpts,
+			)
+			if restErr != nil {
+				require.Error(t, graphqlErr)
+				// Errors should be handled identically
+				require.Equal(t, classifyError(restErr), classifyError(graphqlErr))
+				return
+			}
+			require.NoError(t, graphqlErr)
+			require.Equal(t, restBadge, graphqlBadge)
+		})
+	}
+}
+
+func TestGraphQLServiceCheckSuitePages(t *testing.T) {
+	fixtures := map[string][]*github.CheckSuite{
+		"bar": testCheckSuites(250, "completed", "success"),
+		"bat": testCheckSuites(50, "completed", "success"),
+		"baz": append(
+			testCheckSuites(100, "completed", "failure"),
+			testCheckSuites(100, "completed", "success")...,
+		),
+	}
+	var queries int
+	g := graphqlFixtureService(fixtures, &queries)
+	results, errs := g.checkSuitePages(
+		context.Background(),
+		[]checkSuitesQuery{
+			{owner: "foo", repo: "bar", ref: "main"},
+			{owner: "foo", repo: "bat", ref: "main"},
+			{owner: "foo", repo: "baz", ref: "main"},
+			{owner: "foo", repo: "nonexistent", ref: "main"},
+		},
+	)
+	// One round trip per page of the ref with the most pages
+	require.Equal(t, 3, queries)
+	require.Len(t, results, 4)
+	require.Len(t, errs, 4)
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.NoError(t, errs[2])
+	// An error pertaining to one query doesn't affect the others
+	require.Error(t, errs[3])
+	require.Equal(t, NewErrBadge("repo not found"), classifyError(errs[3]).badge)
+	require.Nil(t, results[3])
+	require.Equal(
+		t,
+		[]CheckSuitePage{
+			{Count: 100, Status: CheckStatusPassed, HasNext: true},
+			{Count: 100, Status: CheckStatusPassed, HasNext: true},
+			{Count: 50, Status: CheckStatusPassed},
+		},
+		results[0],
+	)
+	require.Equal(
+		t,
+		[]CheckSuitePage{{Count: 50, Status: CheckStatusPassed}},
+		results[1],
+	)
+	// The second page wasn't needed
+	require.Equal(
+		t,
+		[]CheckSuitePage{
+			{Count: 100, Status: CheckStatusFailed, HasNext: true},
+			{},
+		},
+		results[2],
+	)
+}
+
+func TestGraphQLServiceCheckBadgeBatching(t *testing.T) {
+	fixtures := map[string][]*github.CheckSuite{
+		"bar": testCheckSuites(3, "completed", "success"),
+		"baz": testCheckSuites(3, "completed", "failure"),
+	}
+	var queries int
+	g := graphqlFixtureService(fixtures, &queries)
+	g.config.BatchWindow = 50 * time.Millisecond
+	repos := []string{"bar", "baz", "nonexistent"}
+	badges := make([]CheckBadge, len(repos))
+	errs := make([]error, len(repos))
+	wg := sync.WaitGroup{}
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo string) {
+			defer wg.Done()
+			badges[i], errs[i] = g.CheckBadge(
+				context.Background(),
+				"foo",
+				repo,
+				&CheckBadgeOptions{},
+			)
+		}(i, repo)
+	}
+	wg.Wait()
+	// All three badges were retrieved in a single round trip
+	require.Equal(t, 1, queries)
+	require.NoError(t, errs[0])
+	require.Equal(t, CheckStatusPassed, badges[0].status)
+	require.NoError(t, errs[1])
+	require.Equal(t, CheckStatusFailed, badges[1].status)
+	require.Error(t, errs[2])
+	require.Equal(t, NewErrBadge("repo not found"), classifyError(errs[2]).badge)
+}
+
+func TestGraphQLServiceCheckBadgeBatchDeadline(t *testing.T) {
+	var queries int
+	g := graphqlFixtureService(map[string][]*github.CheckSuite{}, &queries)
+	g.config.BatchWindow = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := g.CheckBadge(ctx, "foo", "bar", &CheckBadgeOptions{})
+	// The caller doesn't wait on the batch beyond its own deadline
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGraphQLServiceCheckSuitesRoundErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		resp       *graphqlResponse
+		err        error
+		assertions func(error)
+	}{
+		{
+			name: "error communicating with github",
+			err:  errors.New("something went wrong"),
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+			},
+		},
+		{
+			name: "query error",
+			resp: &graphqlResponse{
+				Errors: []graphqlError{{Message: "something went wrong"}},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Equal(
+					t,
+					NewErrBadge(http.StatusInternalServerError),
+					classifyError(err).badge,
+				)
+			},
+		},
+		{
+			name: "repo not found",
+			resp: &graphqlResponse{
+				Data: map[string]*graphqlRepository{"r0": nil},
+				Errors: []graphqlError{
+					{
+						Type:    "NOT_FOUND",
+						Path:    []interface{}{"r0"},
+						Message: "Could not resolve to a Repository",
+					},
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Equal(
+					t,
+					NewErrBadge("repo not found"),
+					classifyError(err).badge,
+				)
+			},
+		},
+		{
+			name: "branch not found",
+			resp: &graphqlResponse{
+				Data: map[string]*graphqlRepository{"r0": {}},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Equal(
+					t,
+					NewErrBadge("branch not found"),
+					classifyError(err).badge,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := &graphqlService{
+				service: &service{
+					rateLimits: NewRateLimits(),
+					breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				},
+				queryFn: func(
+					context.Context,
+					string,
+					map[string]interface{},
+				) (*graphqlResponse, *github.Response, error) {
+					return testCase.resp, nil, testCase.err
+				},
+			}
+			_, queryErrs, err := g.checkSuitesRound(
+				context.Background(),
+				[]checkSuitesQuery{{owner: "foo", repo: "bar", ref: "main"}},
+				make([]*string, 1),
+				[]int{0},
+				1,
+			)
+			if err == nil {
+				err = queryErrs[0]
+			}
+			testCase.assertions(err)
+		})
+	}
+}
+
+func TestBuildCheckSuitesQuery(t *testing.T) {
+	cursor := "cursor"
+	query, variables := buildCheckSuitesQuery(
+		[]checkSuitesQuery{
+			{owner: "foo", repo: "bar", ref: "main"},
+			{owner: "foo", repo: "baz", ref: "v1", appID: 42},
+		},
+		[]*string{nil, &cursor},
+		[]int{1},
+	)
+	// Only the pending query should be included
+	require.NotContains(t, query, "r0:")
+	require.Contains(t, query, "r1: repository(owner: $owner1, name: $name1)")
+	require.Contains(t, query, "first: 100, after: $after1")
+	require.Equal(t, "foo", variables["owner1"])
+	require.Equal(t, "baz", variables["name1"])
+	require.Equal(t, "v1", variables["ref1"])
+	require.Equal(t, 42, *variables["appId1"].(*int))
+	require.Equal(t, &cursor, variables["after1"])
+	require.NotContains(t, variables, "owner0")
+}
+
+func TestGraphQLServiceQuery(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/graphql", r.URL.Path)
+			body := struct {
+				Query     string                 `json:"query"`
+				Variables map[string]interface{} `json:"variables"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, "query", body.Query)
+			require.Equal(t, "bar", body.Variables["name0"])
+			_, err := w.Write(
+				[]byte(
+					`{"data":{"r0":{"object":{"checkSuites":{"totalCount":1,` +
+						`"nodes":[{"status":"QUEUED","conclusion":null}]}}}}}`,
+				),
+			)
+			require.NoError(t, err)
+		}),
+	)
+	defer server.Close()
+	githubClient := github.NewClient(nil)
+	var err error
+	githubClient.BaseURL, err = url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	g := &graphqlService{service: &service{githubClient: githubClient}}
+	resp, response, err := g.query(
+		context.Background(),
+		"query",
+		map[string]interface{}{"name0": "bar"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, 1, resp.Data["r0"].Object.CheckSuites.TotalCount)
+	require.Equal(
+		t,
+		[]*github.CheckSuite{testCheckSuite("queued", "")},
+		resp.Data["r0"].Object.CheckSuites.checkSuites(),
+	)
+}
+
+func TestGraphQLServiceQueryEnterprise(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/api/graphql", r.URL.Path)
+			_, err := w.Write([]byte(`{"data":{}}`))
+			require.NoError(t, err)
+		}),
+	)
+	defer server.Close()
+	g := &graphqlService{
+		service: newService(
+			ServiceConfig{BaseURL: server.URL},
+			NewRateLimits(),
+			NewCircuitBreaker(CircuitBreakerConfig{}),
+		),
+	}
+	_, response, err := g.query(context.Background(), "query", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
@@ -1,115 +1,276 @@
 package badges
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/brigadecore/badgr/internal/logging"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// HandlerConfig represents configuration options for a handler.
+type HandlerConfig struct {
+	// Deadline, if non-zero, is the total time allotted to obtaining a badge
+	// from a Provider.
+	Deadline time.Duration
+}
+
 // handler is an implementation of the http.handler interface that can serve
-// badges by by delegating to a transport-agnostic Service interface.
+// badges from any Provider, taking care of caching, errors, rendering,
+// deadlines, and metrics on the Provider's behalf.
 type handler struct {
-	service Service
-	cache   Cache
+	config   HandlerConfig
+	provider Provider
+	cache    Cache
 }
 
 // NewHandler returns an implementation of the http.handler interface that can
-// serve badges by by delegating to a transport-agnostic Service interface.
-func NewHandler(service Service, cache Cache) http.Handler {
+// serve badges from the provided Provider, taking care of caching, errors,
+// rendering, deadlines, and metrics on the Provider's behalf.
+func NewHandler(
+	config HandlerConfig,
+	provider Provider,
+	cache Cache,
+) http.Handler {
 	return &handler{
-		service: service,
-		cache:   cache,
+		config:   config,
+		provider: provider,
+		cache:    cache,
 	}
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Search the warm cache
-	if url, err := h.cache.GetWarm(r.URL.String()); err != nil {
-		log.Printf(
-			"error retrieving result for key %q from warm cache: %s",
-			r.URL.String(),
-			err,
-		)
-		// Don't return yet. We can still ask the service for a fresh result.
-	} else if url != "" { // Warm cache hit!
-		http.Redirect(w, r, url, http.StatusSeeOther)
+	start := time.Now()
+	route := routeTemplate(r)
+	ctx := otel.GetTextMapPropagator().Extract(
+		r.Context(),
+		propagation.HeaderCarrier(r.Header),
+	)
+	ctx, span := tracer.Start(
+		ctx,
+		fmt.Sprintf("%s %s", r.Method, route),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.target", cacheKey(r)),
+		),
+	)
+	defer span.End()
+	outcome := h.serve(w, r.WithContext(ctx))
+	span.SetAttributes(attribute.String("badgr.outcome", outcome))
+	if tracked := trackedFromContext(ctx); tracked != nil {
+		tracked.outcome = outcome
+	}
+	if refresh := refreshFromContext(ctx); refresh != nil {
+		// This request was made by the Prewarmer, which keeps its own metrics
+		refresh.outcome = outcome
 		return
 	}
+	logging.AddAccessLogAttrs(
+		r.Context(),
+		slog.String("badgeKey", normalizedBadgeKey(r)),
+		slog.String("cache", outcome),
+	)
+	badgeRequestsTotal.WithLabelValues(route, outcome).Inc()
+	badgeRequestDuration.WithLabelValues(route, outcome).Observe(
+		time.Since(start).Seconds(),
+	)
+}
 
-	// If we get to here, either the warm cache lookup failed or we had a warm
-	// cache miss. Either way we'll ask the service for a fresh result.
-	appIDStr := r.URL.Query().Get("appID")
-	var appID int
-	if appIDStr != "" {
-		var err error
-		appID, err = strconv.Atoi(appIDStr)
+// serve serves a badge and returns the outcome for use in metrics.
+func (h *handler) serve(w http.ResponseWriter, r *http.Request) string {
+	subject := subjectFromRequest(r)
+	branch := r.URL.Query().Get("branch")
+	key := cacheKey(r)
+	logger := logging.LoggerFromContext(r.Context()).With(
+		"provider", h.provider.Name(),
+		"owner", subject.Owner,
+		"repo", subject.Repo,
+		"branch", branch,
+	)
+
+	// Search the warm cache, unless the Prewarmer is asking us to refresh it
+	if refreshFromContext(r.Context()) == nil {
+		record, err := h.cache.GetWarm(r.Context(), key)
+		logger = logger.With(
+			"warmCache",
+			observeCacheLookup(cacheLayerWarm, record, err),
+		)
 		if err != nil {
-			http.Redirect(
-				w,
-				r,
-				badgeURL(NewErrBadge(http.StatusBadRequest)),
-				http.StatusSeeOther,
+			logger.Error(
+				"error retrieving result from warm cache",
+				"key", key,
+				"error", err,
 			)
-			return
+			// Don't return yet. We can still ask the service for a fresh result.
+		} else if record != nil { // Warm cache hit!
+			return h.redirect(w, r, logger, record.Badge(), outcomeWarm)
 		}
 	}
-	if badge, err := h.service.CheckBadge(
-		r.Context(),
-		mux.Vars(r)["owner"],
-		mux.Vars(r)["repo"],
-		&CheckBadgeOptions{
-			BadgeName:   r.URL.Query().Get("name"),
-			GitHubAppID: appID,
-			Branch:      r.URL.Query().Get("branch"),
+
+	// If we get to here, either the warm cache lookup failed or we had a warm
+	// cache miss. Either way we'll ask the provider for a fresh result.
+	opts, err := h.provider.ParseOptions(r.URL.Query())
+	if err != nil {
+		logger.Debug("error parsing badge options", "error", err)
+		return h.redirect(
+			w,
+			r,
+			logger,
+			NewErrBadge(http.StatusBadRequest),
+			outcomeBadRequest,
+		)
+	}
+
+	// Search the cold cache before asking the provider for a fresh result. A
+	// cold cache hit is our fallback if the provider fails, but it also permits
+	// the provider to ask only for what has changed since.
+	coldRecord, coldErr := h.cache.GetCold(r.Context(), key)
+	logger = logger.With(
+		"coldCache",
+		observeColdCachePrefetch(coldRecord, coldErr),
+	)
+	if coldErr != nil {
+		logger.Error(
+			"error retrieving result from cold cache",
+			"key", key,
+			"error", coldErr,
+		)
+	}
+
+	fetchCtx := logging.ContextWithLogger(r.Context(), logger)
+	if h.config.Deadline > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(fetchCtx, h.config.Deadline)
+		defer cancel()
+	}
+	badge, err := h.provider.Fetch(
+		fetchCtx,
+		BadgeRequest{
+			Owner:   subject.Owner,
+			Repo:    subject.Repo,
+			Options: opts,
+			Cached:  coldRecord,
 		},
-	); err != nil {
-		log.Printf("error getting check badge: %s", err)
-		// Don't return yet. We can still check the cold cache.
-	} else { // A fresh badge
+	)
+	if err == nil { // A fresh badge
 		// Try to cache this
-		badgeURL := badgeURL(badge)
-		if err := h.cache.Set(r.URL.String(), badgeURL); err != nil {
-			log.Printf(
-				"error writing result for key %q to cache: %s",
-				r.URL.String(),
-				err,
+		record := NewBadgeRecord(badge, time.Now())
+		if err = h.cache.Set(r.Context(), key, record); err != nil {
+			cacheWriteErrorsTotal.WithLabelValues(cacheOperationSet).Inc()
+			logger.Error(
+				"error writing result to cache",
+				"key", key,
+				"error", err,
 			)
 		}
-		http.Redirect(w, r, badgeURL, http.StatusSeeOther)
-		return
+		return h.redirect(w, r, logger, badge, outcomeFresh)
 	}
 
-	// If we get to here, we didn't get anything from the warm cache and the
-	// service errorred. Try the cold cache.
-	if url, err := h.cache.GetCold(r.URL.String()); err != nil {
-		log.Printf(
-			"error retrieving result for key %q from cold cache: %s",
-			r.URL.String(),
-			err,
-		)
-	} else if url != "" { // Cold cache hit!
-		http.Redirect(w, r, url, http.StatusSeeOther)
-		return
+	// If we get to here, the provider errored. What we do next depends on why.
+	f := classifyError(err)
+	logger.Log(
+		r.Context(),
+		f.logLevel,
+		"error fetching badge",
+		"error", err,
+	)
+	if f.warmTTL > 0 {
+		if err = h.cache.SetWarm(
+			r.Context(),
+			key,
+			NewBadgeRecord(f.badge, time.Now()),
+			f.warmTTL,
+		); err != nil {
+			cacheWriteErrorsTotal.WithLabelValues(cacheOperationSetWarm).Inc()
+			logger.Error(
+				"error writing result to warm cache",
+				"key", key,
+				"error", err,
+			)
+		}
+	}
+
+	// If the failure was transient, fall back to the cold cache. Only now is
+	// the earlier lookup counted as a lookup of the cold cache.
+	if f.useColdCache {
+		observeCacheLookup(cacheLayerCold, coldRecord, coldErr)
+		if coldRecord != nil { // Cold cache hit!
+			return h.redirect(w, r, logger, coldRecord.Badge(), outcomeCold)
+		}
 	}
 
 	// If we get to here, we have been completely unsuccessful.
-	http.Redirect(
-		w,
-		r,
-		badgeURL(NewErrBadge(http.StatusInternalServerError)),
-		http.StatusSeeOther,
-	)
+	return h.redirect(w, r, logger, f.badge, outcomeError)
 }
 
-func badgeURL(badge Badge) string {
-	return fmt.Sprintf(
-		"https://img.shields.io/static/v1?label=%s&message=%s&color=%s",
-		url.PathEscape(badge.Name()),
-		url.PathEscape(badge.Status()),
-		url.PathEscape(string(badge.Color())),
+// redirect redirects the client to the rendered badge, logs the outcome, and
+// returns the outcome for use in metrics.
+func (h *handler) redirect(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *slog.Logger,
+	badge Badge,
+	outcome string,
+) string {
+	redirectToBadge(w, r, badge)
+	logger.Debug(
+		"served badge",
+		"outcome", outcome,
+		"status", badge.Status(),
 	)
+	return outcome
+}
+
+// routeTemplate returns the path template of the route that matched the
+// request, for use in metrics and span names.
+func routeTemplate(r *http.Request) string {
+	if currentRoute := mux.CurrentRoute(r); currentRoute != nil {
+		if tpl, err := currentRoute.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
+
+// cacheKey returns the key under which the badge requested is cached. This is
+// the path and query of the request, less any signature or API key token, with
+// query parameters in a canonical order. All requests for the same badge thus
+// share a cache entry however they were authenticated and however their
+// parameters were ordered, and tokens never find their way into the cache.
+// Since the key captures everything that determines the badge served, it is
+// also what signatures cover.
+func cacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del(signatureParam)
+	query.Del(expiresParam)
+	query.Del(tokenParam)
+	u := url.URL{
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// normalizedBadgeKey returns a key that identifies the badge requested
+// irrespective of superficial differences between requests for the same badge,
+// such as the case of the owner and repository names, which GitHub ignores.
+// This is suitable for aggregating requests in the access log.
+func normalizedBadgeKey(r *http.Request) string {
+	key := strings.ToLower(subjectFromRequest(r).String())
+	if branch := r.URL.Query().Get("branch"); branch != "" {
+		key = fmt.Sprintf("%s@%s", key, branch)
+	}
+	return key
 }
@@ -1,20 +1,38 @@
 package badges
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/google/go-github/v33/github"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestNewHandler(t *testing.T) {
-	handler, ok := NewHandler(&service{}, &mockCache{}).(*handler)
+	testConfig := HandlerConfig{Deadline: time.Second}
+	handler, ok :=
+		NewHandler(testConfig, &mockProvider{}, &mockCache{}).(*handler)
 	require.True(t, ok)
-	require.NotNil(t, handler.service)
+	require.Equal(t, testConfig, handler.config)
+	require.NotNil(t, handler.provider)
 	require.NotNil(t, handler.cache)
 }
 
@@ -28,7 +46,15 @@ func TestHandlerServeHTTP(t *testing.T) {
 	testBadge := CheckBadge{
 		name:   "foo",
 		status: CheckStatusQueued,
+		pages: []CheckSuitePage{
+			{
+				ETag:   "etag",
+				Count:  1,
+				Status: CheckStatusQueued,
+			},
+		},
 	}
+	testRecord := NewBadgeRecord(testBadge, time.Now())
 	testCases := []struct {
 		name       string
 		handler    *handler
@@ -38,8 +64,8 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache hit",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return badgeURL(testBadge), nil // Hit
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
 					},
 				},
 			},
@@ -49,26 +75,55 @@ func TestHandlerServeHTTP(t *testing.T) {
 			},
 		},
 		{
-			name: "warm cache error; service error; cold cache hit",
+			name: "warm cache miss; invalid options",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					GetColdFn: func(string) (string, error) {
-						return badgeURL(testBadge), nil // Hit
+				},
+				provider: &mockProvider{
+					NameFn: func() string {
+						return "foo"
+					},
+					ParseOptionsFn: func(url.Values) (interface{}, error) {
+						return nil, errors.New("something went wrong")
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+			},
+			assertions: func(r *http.Response) {
+				require.Equal(t, http.StatusSeeOther, r.StatusCode)
+				require.Equal(
+					t,
+					badgeURL(NewErrBadge(http.StatusBadRequest)),
+					r.Header.Get("Location"),
+				)
+			},
+		},
+		{
+			name: "warm cache error; service error; cold cache hit",
+			handler: &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
 					},
 				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
+					},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -79,23 +134,26 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache error; service error; cold cache error",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
 					},
-					GetColdFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -110,23 +168,26 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache error; service error; cold cache miss",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
 					},
-					GetColdFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -141,23 +202,29 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache error; service success; cache set error",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					SetFn: func(string, string) error {
+					SetFn: func(context.Context, string, BadgeRecord) error {
 						return errors.New("something went wrong")
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return testBadge, nil
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return testBadge, nil
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -168,23 +235,29 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache error; service success; cache set success",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					SetFn: func(string, string) error {
+					SetFn: func(context.Context, string, BadgeRecord) error {
 						return nil
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return testBadge, nil
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return testBadge, nil
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -195,23 +268,26 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache miss; service error; cold cache hit",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					GetColdFn: func(string) (string, error) {
-						return badgeURL(testBadge), nil // Hit
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -222,23 +298,26 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache miss; service error; cold cache error",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					GetColdFn: func(string) (string, error) {
-						return "", errors.New("something went wrong") // Error
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, errors.New("something went wrong") // Error
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -253,23 +332,26 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache miss; service error; cold cache miss",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					GetColdFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return CheckBadge{}, errors.New("something went wrong")
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, errors.New("something went wrong")
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -284,23 +366,29 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache miss; service success; cache set error",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
 					},
-					SetFn: func(key, value string) error {
+					SetFn: func(context.Context, string, BadgeRecord) error {
 						return errors.New("something went wrong")
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return testBadge, nil
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return testBadge, nil
+						},
 					},
-				},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -311,23 +399,190 @@ func TestHandlerServeHTTP(t *testing.T) {
 			name: "warm cache miss; service success; cache set success",
 			handler: &handler{
 				cache: &mockCache{
-					GetWarmFn: func(string) (string, error) {
-						return "", nil // Miss
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					SetFn: func(context.Context, string, BadgeRecord) error {
+						return nil
+					},
+				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return testBadge, nil
+						},
+					},
+					nil,
+				),
+			},
+			assertions: func(r *http.Response) {
+				require.Equal(t, http.StatusSeeOther, r.StatusCode)
+				require.Equal(t, badgeURL(testBadge), r.Header.Get("Location"))
+			},
+		},
+		{
+			name: "warm cache miss; service rate limited; cold cache hit",
+			handler: &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
+					},
+				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, &github.RateLimitError{
+								Response: &http.Response{
+									Request:    testRequest,
+									StatusCode: http.StatusForbidden,
+								},
+							}
+						},
+					},
+					nil,
+				),
+			},
+			assertions: func(r *http.Response) {
+				require.Equal(t, http.StatusSeeOther, r.StatusCode)
+				require.Equal(t, badgeURL(testBadge), r.Header.Get("Location"))
+			},
+		},
+		{
+			name: "warm cache miss; service rate limited; cold cache miss",
+			handler: &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, &github.RateLimitError{
+								Response: &http.Response{
+									Request:    testRequest,
+									StatusCode: http.StatusForbidden,
+								},
+							}
+						},
+					},
+					nil,
+				),
+			},
+			assertions: func(r *http.Response) {
+				require.Equal(t, http.StatusSeeOther, r.StatusCode)
+				require.Equal(
+					t,
+					badgeURL(NewErrBadge("rate limited")),
+					r.Header.Get("Location"),
+				)
+			},
+		},
+		{
+			name: "warm cache miss; service repo not found",
+			handler: &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						// A definitive answer from GitHub should trump this
+						return &testRecord, nil // Hit
 					},
-					SetFn: func(key, value string) error {
+					SetWarmFn: func(
+						_ context.Context,
+						_ string,
+						record BadgeRecord,
+						ttl time.Duration,
+					) error {
+						require.Equal(t, NewErrBadge("repo not found"), record.Badge())
+						require.Equal(t, notFoundTTL, ttl)
 						return nil
 					},
 				},
-				service: &mockService{
-					CheckBadgeFn: func(
-						context.Context,
-						string,
-						string,
-						*CheckBadgeOptions,
-					) (CheckBadge, error) {
-						return testBadge, nil
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, &github.ErrorResponse{
+								Response: &http.Response{
+									Request:    testRequest,
+									StatusCode: http.StatusNotFound,
+								},
+							}
+						},
+					},
+					nil,
+				),
+			},
+			assertions: func(r *http.Response) {
+				require.Equal(t, http.StatusSeeOther, r.StatusCode)
+				require.Equal(
+					t,
+					badgeURL(NewErrBadge("repo not found")),
+					r.Header.Get("Location"),
+				)
+			},
+		},
+		{
+			name: "warm cache miss; cold cache hit; service success",
+			handler: &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
+					},
+					SetFn: func(_ context.Context, _ string, record BadgeRecord) error {
+						require.Equal(t, testBadge, record.Badge())
+						return nil
 					},
 				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							_ context.Context,
+							_ string,
+							_ string,
+							opts *CheckBadgeOptions,
+						) (CheckBadge, error) {
+							// The service should have been given what it needs to make
+							// conditional requests
+							require.Equal(t, testBadge.pages, opts.CachedPages)
+							return testBadge, nil
+						},
+					},
+					nil,
+				),
 			},
 			assertions: func(r *http.Response) {
 				require.Equal(t, http.StatusSeeOther, r.StatusCode)
@@ -337,6 +592,10 @@ func TestHandlerServeHTTP(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.handler.provider == nil {
+				// Cases that never reach the provider needn't specify one
+				testCase.handler.provider = NewGitHubChecksProvider(nil, nil)
+			}
 			testRouter := mux.NewRouter()
 			testRouter.HandleFunc(
 				"/v1/github/checks/{owner}/{repo}/badge.svg",
@@ -351,6 +610,373 @@ func TestHandlerServeHTTP(t *testing.T) {
 	}
 }
 
+func TestHandlerServeHTTPColdCacheMetrics(t *testing.T) {
+	testRecord := NewBadgeRecord(CheckBadge{name: "foo"}, time.Now())
+	testCases := []struct {
+		name                string
+		err                 error
+		expectedColdLookups float64
+	}{
+		{
+			name:                "provider success",
+			expectedColdLookups: 0,
+		},
+		{
+			name:                "provider error",
+			err:                 errors.New("something went wrong"),
+			expectedColdLookups: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			coldLookups :=
+				cacheLookupsTotal.WithLabelValues(cacheLayerCold, cacheResultHit)
+			prefetches := coldCachePrefetchesTotal.WithLabelValues(cacheResultHit)
+			coldLookupsBefore := testutil.ToFloat64(coldLookups)
+			prefetchesBefore := testutil.ToFloat64(prefetches)
+			testHandler := &handler{
+				cache: &mockCache{
+					GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+						return nil, nil // Miss
+					},
+					GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+						return &testRecord, nil // Hit
+					},
+					SetFn: func(context.Context, string, BadgeRecord) error {
+						return nil
+					},
+				},
+				provider: NewGitHubChecksProvider(
+					&mockService{
+						CheckBadgeFn: func(
+							context.Context,
+							string,
+							string,
+							*CheckBadgeOptions,
+						) (CheckBadge, error) {
+							return CheckBadge{}, testCase.err
+						},
+					},
+					nil,
+				),
+			}
+			testHandler.ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest(
+					http.MethodGet,
+					"/v1/github/checks/krancour/foo/badge.svg",
+					nil,
+				),
+			)
+			require.Equal(t, prefetchesBefore+1, testutil.ToFloat64(prefetches))
+			require.Equal(
+				t,
+				coldLookupsBefore+testCase.expectedColdLookups,
+				testutil.ToFloat64(coldLookups),
+			)
+		})
+	}
+}
+
+func TestHandlerServeHTTPTracing(t *testing.T) {
+	const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanRecorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)),
+	)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+	testRequest, err := http.NewRequest(
+		http.MethodGet,
+		"/v1/github/checks/krancour/foo/badge.svg",
+		nil,
+	)
+	require.NoError(t, err)
+	testRequest.Header.Set(
+		"traceparent",
+		"00-"+testTraceID+"-00f067aa0ba902b7-01",
+	)
+	testHandler := &handler{
+		cache: &mockCache{
+			GetWarmFn: func(ctx context.Context, _ string) (*BadgeRecord, error) {
+				// The request's span should be available to the cache
+				require.True(t, trace.SpanContextFromContext(ctx).IsValid())
+				return nil, nil // Miss
+			},
+			GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+			SetFn: func(context.Context, string, BadgeRecord) error {
+				return nil
+			},
+		},
+		provider: NewGitHubChecksProvider(
+			&mockService{
+				CheckBadgeFn: func(
+					context.Context,
+					string,
+					string,
+					*CheckBadgeOptions,
+				) (CheckBadge, error) {
+					return CheckBadge{}, nil
+				},
+			},
+			nil,
+		),
+	}
+	testRouter := mux.NewRouter()
+	testRouter.HandleFunc(
+		"/v1/github/checks/{owner}/{repo}/badge.svg",
+		testHandler.ServeHTTP,
+	).Methods(http.MethodGet)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, testRequest)
+	spans := spanRecorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(
+		t,
+		"GET /v1/github/checks/{owner}/{repo}/badge.svg",
+		spans[0].Name(),
+	)
+	// The span should belong to the trace propagated by the caller
+	require.Equal(t, testTraceID, spans[0].SpanContext().TraceID().String())
+	require.True(t, spans[0].Parent().IsRemote())
+}
+
+func TestHandlerServeHTTPLogging(t *testing.T) {
+	logs := &bytes.Buffer{}
+	testLogger := slog.New(
+		slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	)
+	testRequest, err := http.NewRequest(
+		http.MethodGet,
+		"/v1/github/checks/krancour/foo/badge.svg?branch=main",
+		nil,
+	)
+	require.NoError(t, err)
+	testRequest.Header.Set(logging.RequestIDHeader, "test-request-id")
+	testRequest = testRequest.WithContext(
+		logging.ContextWithLogger(testRequest.Context(), testLogger),
+	)
+	testHandler := &handler{
+		cache: &mockCache{
+			GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+			GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+		},
+		provider: NewGitHubChecksProvider(
+			&mockService{
+				CheckBadgeFn: func(
+					context.Context,
+					string,
+					string,
+					*CheckBadgeOptions,
+				) (CheckBadge, error) {
+					return CheckBadge{}, errors.New("something went wrong")
+				},
+			},
+			nil,
+		),
+	}
+	testRouter := mux.NewRouter()
+	testRouter.HandleFunc(
+		"/v1/github/checks/{owner}/{repo}/badge.svg",
+		testHandler.ServeHTTP,
+	).Methods(http.MethodGet)
+	rr := httptest.NewRecorder()
+	logging.RequestID(testRouter).ServeHTTP(rr, testRequest)
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.Equal(t, "test-request-id", entry["requestID"])
+		require.Equal(t, "krancour", entry["owner"])
+		require.Equal(t, "foo", entry["repo"])
+		require.Equal(t, "main", entry["branch"])
+		require.Equal(t, cacheResultMiss, entry["warmCache"])
+		require.Equal(t, cacheResultMiss, entry["coldCache"])
+	}
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "ERROR", entry["level"])
+	require.Equal(t, "something went wrong", entry["error"])
+	entry = map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, outcomeError, entry["outcome"])
+}
+
+func TestHandlerServeHTTPRefresh(t *testing.T) {
+	testBadge := CheckBadge{
+		name:   "foo",
+		status: CheckStatusPassed,
+	}
+	var cached bool
+	testHandler := &handler{
+		cache: &mockCache{
+			GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+				require.Fail(t, "warm cache should not have been searched")
+				return nil, nil
+			},
+			GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+			SetFn: func(context.Context, string, BadgeRecord) error {
+				cached = true
+				return nil
+			},
+		},
+		provider: NewGitHubChecksProvider(
+			&mockService{
+				CheckBadgeFn: func(
+					context.Context,
+					string,
+					string,
+					*CheckBadgeOptions,
+				) (CheckBadge, error) {
+					return testBadge, nil
+				},
+			},
+			nil,
+		),
+	}
+	testRefresh := &refresh{}
+	testRequest, err := http.NewRequestWithContext(
+		contextWithRefresh(context.Background(), testRefresh),
+		http.MethodGet,
+		"/v1/github/checks/krancour/foo/badge.svg",
+		nil,
+	)
+	require.NoError(t, err)
+	testRouter := mux.NewRouter()
+	testRouter.HandleFunc(
+		"/v1/github/checks/{owner}/{repo}/badge.svg",
+		testHandler.ServeHTTP,
+	).Methods(http.MethodGet)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, testRequest)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.True(t, cached)
+	require.Equal(t, outcomeFresh, testRefresh.outcome)
+}
+
+func TestHandlerServeHTTPDeadline(t *testing.T) {
+	testHandler := &handler{
+		config: HandlerConfig{Deadline: 10 * time.Millisecond},
+		cache: &mockCache{
+			GetWarmFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+			GetColdFn: func(context.Context, string) (*BadgeRecord, error) {
+				return nil, nil // Miss
+			},
+		},
+		provider: &mockProvider{
+			NameFn: func() string {
+				return "mock"
+			},
+			ParseOptionsFn: func(url.Values) (interface{}, error) {
+				return nil, nil
+			},
+			FetchFn: func(ctx context.Context, _ BadgeRequest) (Badge, error) {
+				_, ok := ctx.Deadline()
+				require.True(t, ok)
+				// Simulate a very slow backend
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	}
+	testRouter := mux.NewRouter()
+	testRouter.HandleFunc(
+		"/v1/mock/{owner}/{repo}/badge.svg",
+		testHandler.ServeHTTP,
+	).Methods(http.MethodGet)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(
+		rr,
+		httptest.NewRequest(http.MethodGet, "/v1/mock/krancour/foo/badge.svg", nil),
+	)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.Equal(
+		t,
+		badgeURL(NewErrBadge("timeout")),
+		rr.Header().Get("Location"),
+	)
+}
+
+func TestCacheKey(t *testing.T) {
+	testCases := []struct {
+		name        string
+		url         string
+		expectedKey string
+	}{
+		{
+			name:        "unsigned",
+			url:         "/v1/github/checks/krancour/foo/badge.svg?name=b&branch=a",
+			expectedKey: "/v1/github/checks/krancour/foo/badge.svg?branch=a&name=b",
+		},
+		{
+			name:        "absolute URL",
+			url:         "https://badgr.example.com/v1/github/checks/a/b/badge.svg",
+			expectedKey: "/v1/github/checks/a/b/badge.svg",
+		},
+		{
+			name:        "signed",
+			url:         "/v1/github/checks/krancour/foo/badge.svg?sig=x&expires=1",
+			expectedKey: "/v1/github/checks/krancour/foo/badge.svg",
+		},
+		{
+			name:        "API key",
+			url:         "/v1/github/checks/krancour/foo/badge.svg?token=x&name=b",
+			expectedKey: "/v1/github/checks/krancour/foo/badge.svg?name=b",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testRequest, err := http.NewRequest(http.MethodGet, testCase.url, nil)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedKey, cacheKey(testRequest))
+		})
+	}
+}
+
+func TestNormalizedBadgeKey(t *testing.T) {
+	testCases := []struct {
+		name        string
+		url         string
+		expectedKey string
+	}{
+		{
+			name:        "no branch",
+			url:         "/v1/github/checks/Krancour/Foo/badge.svg?name=bar",
+			expectedKey: "krancour/foo",
+		},
+		{
+			name:        "branch",
+			url:         "/v1/github/checks/Krancour/Foo/badge.svg?branch=Main",
+			expectedKey: "krancour/foo@Main",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testRequest, err := http.NewRequest(http.MethodGet, testCase.url, nil)
+			require.NoError(t, err)
+			testRequest = mux.SetURLVars(
+				testRequest,
+				map[string]string{"owner": "Krancour", "repo": "Foo"},
+			)
+			require.Equal(t, testCase.expectedKey, normalizedBadgeKey(testRequest))
+		})
+	}
+}
+
 type mockService struct {
 	CheckBadgeFn func(
 		ctx context.Context,
@@ -358,6 +984,7 @@ type mockService struct {
 		repo string,
 		opts *CheckBadgeOptions,
 	) (CheckBadge, error)
+	PingFn func(ctx context.Context) error
 }
 
 func (m *mockService) CheckBadge(
@@ -369,20 +996,62 @@ func (m *mockService) CheckBadge(
 	return m.CheckBadgeFn(ctx, owner, repo, opts)
 }
 
+func (m *mockService) Ping(ctx context.Context) error {
+	return m.PingFn(ctx)
+}
+
 type mockCache struct {
-	SetFn     func(key string, value string) error
-	GetWarmFn func(key string) (string, error)
-	GetColdFn func(key string) (string, error)
+	SetFn     func(ctx context.Context, key string, record BadgeRecord) error
+	SetWarmFn func(
+		ctx context.Context,
+		key string,
+		record BadgeRecord,
+		ttl time.Duration,
+	) error
+	GetWarmFn func(ctx context.Context, key string) (*BadgeRecord, error)
+	GetColdFn func(ctx context.Context, key string) (*BadgeRecord, error)
+	WarmTTLFn func(ctx context.Context, key string) (time.Duration, error)
+	PingFn    func(ctx context.Context) error
 }
 
-func (m *mockCache) Set(key string, value string) error {
-	return m.SetFn(key, value)
+func (m *mockCache) Set(
+	ctx context.Context,
+	key string,
+	record BadgeRecord,
+) error {
+	return m.SetFn(ctx, key, record)
 }
 
-func (m *mockCache) GetWarm(key string) (string, error) {
-	return m.GetWarmFn(key)
+func (m *mockCache) SetWarm(
+	ctx context.Context,
+	key string,
+	record BadgeRecord,
+	ttl time.Duration,
+) error {
+	return m.SetWarmFn(ctx, key, record, ttl)
+}
+
+func (m *mockCache) GetWarm(
+	ctx context.Context,
+	key string,
+) (*BadgeRecord, error) {
+	return m.GetWarmFn(ctx, key)
+}
+
+func (m *mockCache) GetCold(
+	ctx context.Context,
+	key string,
+) (*BadgeRecord, error) {
+	return m.GetColdFn(ctx, key)
+}
+
+func (m *mockCache) WarmTTL(
+	ctx context.Context,
+	key string,
+) (time.Duration, error) {
+	return m.WarmTTLFn(ctx, key)
 }
 
-func (m *mockCache) GetCold(key string) (string, error) {
-	return m.GetColdFn(key)
+func (m *mockCache) Ping(ctx context.Context) error {
+	return m.PingFn(ctx)
 }
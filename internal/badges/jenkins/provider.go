@@ -0,0 +1,223 @@
+package jenkins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/pkg/errors"
+)
+
+// Route is the route of badges based on Jenkins builds. The job is identified
+// by its full name, i.e. its path through any folders containing it.
+const Route = "/v1/jenkins/jobs/{job:.+}/badge.svg"
+
+const (
+	// BuildLast selects the last build of a job, whether or not it's complete.
+	BuildLast = "last"
+	// BuildLastCompleted selects the last completed build of a job.
+	BuildLastCompleted = "lastCompleted"
+)
+
+// Config represents configuration options for the Jenkins provider.
+type Config struct {
+	// BaseURL is the base URL of the Jenkins controller, e.g.
+	// https://jenkins.example.com.
+	BaseURL string
+	// Username, if non-empty, is the name of the user as whom Badgr
+	// authenticates to Jenkins using basic auth.
+	Username string
+	// APIToken is the API token (or password) of the user specified by Username.
+	APIToken string
+}
+
+func init() {
+	badges.RegisterProviderFactory("jenkins", newConfiguredProvider)
+}
+
+// configFromSettings populates configuration for the Jenkins provider from
+// the provided settings.
+func configFromSettings(settings badges.Settings) (Config, error) {
+	config := Config{
+		Username: settings.Setting("JENKINS_USERNAME"),
+		APIToken: settings.Setting("JENKINS_API_TOKEN"),
+	}
+	var err error
+	config.BaseURL, err = badges.URLSetting(settings, "JENKINS_BASE_URL")
+	return config, err
+}
+
+// newConfiguredProvider returns a Jenkins provider configured from the
+// provided settings, or nil if JENKINS_BASE_URL is unset.
+func newConfiguredProvider(
+	settings badges.Settings,
+) (badges.Provider, error) {
+	config, err := configFromSettings(settings)
+	if err != nil || config.BaseURL == "" {
+		return nil, err
+	}
+	return NewProvider(config), nil
+}
+
+// Options represents options for a badge based on Jenkins builds.
+type Options struct {
+	// BadgeName specifies a name that should be applied to the badge. If left
+	// unspecified, it will default to "build".
+	BadgeName string
+	// Branch, if non-empty, indicates that the job is a multibranch job and
+	// selects the branch upon whose build the badge should be based.
+	Branch string
+	// Build selects the build upon which the badge should be based. It must be
+	// either BuildLast or BuildLastCompleted. If left unspecified, it will
+	// default to BuildLast.
+	Build string
+}
+
+// build is the subset of a Jenkins build that Badgr is interested in.
+type build struct {
+	Building bool   `json:"building"`
+	Result   string `json:"result"`
+}
+
+type provider struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// NewProvider returns an implementation of the badges.Provider interface that
+// serves badges based on the result of the last build of a Jenkins job.
+func NewProvider(config Config) badges.Provider {
+	return &provider{
+		config:     config,
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *provider) Name() string {
+	return "jenkins-jobs"
+}
+
+func (p *provider) Route() string {
+	return Route
+}
+
+// Subject returns the path of the folder containing the job as the owner and
+// the job's name as the repo. A job that is not in any folder is the owner
+// alone.
+func (p *provider) Subject(vars map[string]string) badges.Subject {
+	return badges.PathSubject(vars["job"])
+}
+
+func (p *provider) ParseOptions(query url.Values) (interface{}, error) {
+	opts := &Options{
+		BadgeName: query.Get("name"),
+		Branch:    query.Get("branch"),
+		Build:     query.Get("build"),
+	}
+	switch opts.Build {
+	case "", BuildLast, BuildLastCompleted:
+	default:
+		return nil, errors.Errorf(
+			"invalid build %q; must be %q or %q",
+			opts.Build,
+			BuildLast,
+			BuildLastCompleted,
+		)
+	}
+	return opts, nil
+}
+
+func (p *provider) Fetch(
+	ctx context.Context,
+	req badges.BadgeRequest,
+) (badges.Badge, error) {
+	opts, ok := req.Options.(*Options)
+	if !ok {
+		return nil, errors.Errorf("unexpected options type %T", req.Options)
+	}
+	buildName := opts.Build
+	if buildName == "" {
+		buildName = BuildLast
+	}
+
+	// Jobs are identified by their full name, folders and all
+	job := badges.Subject{Owner: req.Owner, Repo: req.Repo}.String()
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s%s/%sBuild/api/json?tree=building,result",
+			strings.TrimSuffix(p.config.BaseURL, "/"),
+			jobPath(job, opts.Branch),
+			buildName,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Jenkins request")
+	}
+	if p.config.Username != "" {
+		httpReq.SetBasicAuth(p.config.Username, p.config.APIToken)
+	}
+	b := build{}
+	if err = badges.GetJSON(ctx, p.httpClient, httpReq, &b); err != nil {
+		// A 404 for a branch of a multibranch job means the branch doesn't exist.
+		// Otherwise, it's treated as the job not existing, which Jenkins doesn't
+		// distinguish from a job that has never been built.
+		upstreamErr := &badges.UpstreamError{}
+		if opts.Branch != "" && errors.As(err, &upstreamErr) &&
+			upstreamErr.StatusCode == http.StatusNotFound {
+			err = badges.ErrBranchNotFound
+		}
+		return nil, errors.Wrapf(
+			err,
+			"error retrieving %s build of Jenkins job %s",
+			buildName,
+			job,
+		)
+	}
+	return badges.NewCheckBadge(opts.BadgeName, buildStatus(b)), nil
+}
+
+// jobPath returns the path of the job with the specified full name relative to
+// the Jenkins base URL. Every folder, the job itself, and, for multibranch
+// jobs, the branch are each addressed by a "job" segment.
+func jobPath(job, branch string) string {
+	names := strings.Split(job, "/")
+	if branch != "" {
+		// Multibranch jobs name the job for each branch by URL-encoding the
+		// branch's name, so that, for instance, "feature/foo" becomes
+		// "feature%2Ffoo". That name must then be escaped again to be used in a
+		// path.
+		names = append(names, url.PathEscape(branch))
+	}
+	var path strings.Builder
+	for _, name := range names {
+		path.WriteString("/job/")
+		path.WriteString(url.PathEscape(name))
+	}
+	return path.String()
+}
+
+// buildStatus maps the state of a Jenkins build onto a CheckStatus.
+func buildStatus(b build) badges.CheckStatus {
+	if b.Building {
+		return badges.CheckStatusInProgress
+	}
+	switch b.Result {
+	case "SUCCESS":
+		return badges.CheckStatusPassed
+	case "FAILURE", "UNSTABLE":
+		// Builds are usually unstable because of test failures
+		return badges.CheckStatusFailed
+	case "ABORTED":
+		return badges.CheckStatusCanceled
+	case "NOT_BUILT":
+		return badges.CheckStatusNeutral
+	default:
+		return badges.CheckStatusUnknown
+	}
+}
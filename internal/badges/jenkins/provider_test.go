@@ -0,0 +1,252 @@
+package jenkins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle(Route, http.NotFoundHandler())
+	match := mux.RouteMatch{}
+	require.True(
+		t,
+		router.Match(
+			httptest.NewRequest(
+				http.MethodGet,
+				"/v1/jenkins/jobs/folder/subfolder/job/badge.svg",
+				nil,
+			),
+			&match,
+		),
+	)
+	require.Equal(t, "folder/subfolder/job", match.Vars["job"])
+	require.Equal(
+		t,
+		badges.Subject{Owner: "folder/subfolder", Repo: "job"},
+		NewProvider(Config{}).Subject(match.Vars),
+	)
+}
+
+func TestNewConfiguredProvider(t *testing.T) {
+	// Providers whose backends aren't configured aren't registered at all
+	p, err := newConfiguredProvider(badges.SettingsMap{})
+	require.NoError(t, err)
+	require.Nil(t, p)
+	p, err = newConfiguredProvider(
+		badges.SettingsMap{
+			"JENKINS_BASE_URL":  "https://jenkins.example.com",
+			"JENKINS_USERNAME":  "foo",
+			"JENKINS_API_TOKEN": "bar",
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		Config{
+			BaseURL:  "https://jenkins.example.com",
+			Username: "foo",
+			APIToken: "bar",
+		},
+		p.(*provider).config,
+	)
+}
+
+func TestProviderParseOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      url.Values
+		assertions func(interface{}, error)
+	}{
+		{
+			name:  "invalid build",
+			query: url.Values{"build": []string{"bogus"}},
+			assertions: func(_ interface{}, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid build")
+			},
+		},
+		{
+			name: "success",
+			query: url.Values{
+				"name":   []string{"tests"},
+				"branch": []string{"feature/foo"},
+				"build":  []string{BuildLastCompleted},
+			},
+			assertions: func(opts interface{}, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					&Options{
+						BadgeName: "tests",
+						Branch:    "feature/foo",
+						Build:     BuildLastCompleted,
+					},
+					opts,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				NewProvider(Config{}).ParseOptions(testCase.query),
+			)
+		})
+	}
+}
+
+func TestProviderFetch(t *testing.T) {
+	const testUsername = "foo"
+	const testAPIToken = "bar"
+	testCases := []struct {
+		name       string
+		job        string
+		opts       *Options
+		handler    http.HandlerFunc
+		assertions func(badges.Badge, error)
+	}{
+		{
+			name: "branch not found",
+			job:  "folder/job",
+			opts: &Options{Branch: "foo"},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			assertions: func(_ badges.Badge, err error) {
+				require.ErrorIs(t, err, badges.ErrBranchNotFound)
+			},
+		},
+		{
+			name: "defaults",
+			job:  "job",
+			opts: &Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(
+					t,
+					"/job/job/lastBuild/api/json",
+					r.URL.EscapedPath(),
+				)
+				fmt.Fprint(w, `{"building":true,"result":null}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("build", badges.CheckStatusInProgress),
+					badge,
+				)
+			},
+		},
+		{
+			name: "last completed build of multibranch job",
+			job:  "folder/subfolder/job",
+			opts: &Options{
+				BadgeName: "tests",
+				Branch:    "feature/foo",
+				Build:     BuildLastCompleted,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				username, apiToken, ok := r.BasicAuth()
+				require.True(t, ok)
+				require.Equal(t, testUsername, username)
+				require.Equal(t, testAPIToken, apiToken)
+				require.Equal(
+					t,
+					"/job/folder/job/subfolder/job/job/job/feature%252Ffoo/"+
+						"lastCompletedBuild/api/json",
+					r.URL.EscapedPath(),
+				)
+				fmt.Fprint(w, `{"building":false,"result":"SUCCESS"}`)
+			},
+			assertions: func(badge badges.Badge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					badges.NewCheckBadge("tests", badges.CheckStatusPassed),
+					badge,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+			subject := badges.PathSubject(testCase.job)
+			testCase.assertions(
+				NewProvider(
+					Config{
+						BaseURL:  server.URL + "/",
+						Username: testUsername,
+						APIToken: testAPIToken,
+					},
+				).Fetch(
+					context.Background(),
+					badges.BadgeRequest{
+						Owner:   subject.Owner,
+						Repo:    subject.Repo,
+						Options: testCase.opts,
+					},
+				),
+			)
+		})
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	testCases := []struct {
+		name           string
+		build          build
+		expectedStatus badges.CheckStatus
+	}{
+		{
+			name:           "building",
+			build:          build{Building: true},
+			expectedStatus: badges.CheckStatusInProgress,
+		},
+		{
+			name:           "success",
+			build:          build{Result: "SUCCESS"},
+			expectedStatus: badges.CheckStatusPassed,
+		},
+		{
+			name:           "failure",
+			build:          build{Result: "FAILURE"},
+			expectedStatus: badges.CheckStatusFailed,
+		},
+		{
+			name:           "unstable",
+			build:          build{Result: "UNSTABLE"},
+			expectedStatus: badges.CheckStatusFailed,
+		},
+		{
+			name:           "aborted",
+			build:          build{Result: "ABORTED"},
+			expectedStatus: badges.CheckStatusCanceled,
+		},
+		{
+			name:           "not built",
+			build:          build{Result: "NOT_BUILT"},
+			expectedStatus: badges.CheckStatusNeutral,
+		},
+		{
+			name:           "no result",
+			build:          build{},
+			expectedStatus: badges.CheckStatusUnknown,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expectedStatus, buildStatus(testCase.build))
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package badges
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "badgr"
+
+// Outcomes of a badge request, used as metric label values
+const (
+	outcomeWarm       = "warm"
+	outcomeFresh      = "fresh"
+	outcomeCold       = "cold"
+	outcomeError      = "error"
+	outcomeBadRequest = "bad_request"
+	outcomeForbidden  = "forbidden"
+	outcomeNotFound   = "not_found"
+)
+
+// outcomeSkipped is the outcome of a pre-warming refresh that was skipped to
+// preserve the GitHub API budget, used as a metric label value
+const outcomeSkipped = "skipped"
+
+// Cache layers, used as metric label values
+const (
+	cacheLayerWarm = "warm"
+	cacheLayerCold = "cold"
+)
+
+// Cache write operations, used as metric label values
+const (
+	cacheOperationSet     = "set"
+	cacheOperationSetWarm = "set_warm"
+)
+
+// Results of a cache lookup, used as metric label values
+const (
+	cacheResultHit   = "hit"
+	cacheResultMiss  = "miss"
+	cacheResultError = "error"
+)
+
+// Names of GitHub API endpoints, used as metric label values
+const (
+	githubEndpointListCheckSuitesForRef = "list_check_suites_for_ref"
+	githubEndpointRateLimit             = "rate_limit"
+	githubEndpointGraphQL               = "graphql"
+	githubEndpointGetRepository         = "get_repository"
+)
+
+var (
+	badgeRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "badge_requests_total",
+			Help:      "Number of badge requests served, by route and outcome.",
+		},
+		[]string{"route", "outcome"},
+	)
+	badgeRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "badge_request_duration_seconds",
+			Help:      "Time taken to serve badge requests, by route and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"route", "outcome"},
+	)
+	cacheLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Number of cache lookups, by layer and result.",
+		},
+		[]string{"layer", "result"},
+	)
+	coldCachePrefetchesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cold_cache_prefetches_total",
+			Help: "Number of cold cache lookups made ahead of fetching a fresh " +
+				"badge, so that only what has changed need be requested, by " +
+				"result.",
+		},
+		[]string{"result"},
+	)
+	cacheWriteErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_write_errors_total",
+			Help:      "Number of failed cache writes, by operation.",
+		},
+		[]string{"operation"},
+	)
+	githubRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_requests_total",
+			Help: "Number of requests made to the GitHub API, by endpoint and " +
+				"HTTP status code.",
+		},
+		[]string{"endpoint", "code"},
+	)
+	githubRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_request_duration_seconds",
+			Help:      "Latency of requests made to the GitHub API, by endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	checkBadgePages = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "check_badge_pages",
+			Help:      "Number of pages of check suites retrieved per check badge.",
+			Buckets:   []float64{1, 2, 3, 5, 8, 13},
+		},
+	)
+	githubRateLimitLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_rate_limit_limit",
+			Help: "Maximum number of GitHub API requests permitted per window, " +
+				"by resource.",
+		},
+		[]string{"resource"},
+	)
+	githubRateLimitRemaining = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_rate_limit_remaining",
+			Help: "Number of GitHub API requests remaining in the current " +
+				"window, by resource.",
+		},
+		[]string{"resource"},
+	)
+	githubCircuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_circuit_breaker_state",
+			Help: "State of the circuit breaker guarding requests to the GitHub " +
+				"API. The current state has a value of 1.",
+		},
+		[]string{"state"},
+	)
+	prewarmRefreshesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "prewarm_refreshes_total",
+			Help: "Number of popular badges refreshed ahead of expiring from the " +
+				"warm cache, by outcome.",
+		},
+		[]string{"outcome"},
+	)
+	clientRateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "client_rate_limited_total",
+			Help: "Number of requests refused for exceeding a client rate limit, " +
+				"by scope.",
+		},
+		[]string{"scope"},
+	)
+	githubRateLimitReset = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "github_rate_limit_reset_timestamp_seconds",
+			Help: "Time at which the current GitHub API rate limit window " +
+				"resets, by resource.",
+		},
+		[]string{"resource"},
+	)
+)
+
+// observeGitHubRequest records metrics for a single request made to the GitHub
+// API.
+func observeGitHubRequest(
+	endpoint string,
+	start time.Time,
+	response *github.Response,
+) {
+	// If there was no response, the request failed before GitHub could answer
+	code := "none"
+	if response != nil && response.Response != nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+	githubRequestsTotal.WithLabelValues(endpoint, code).Inc()
+	githubRequestDuration.WithLabelValues(endpoint).Observe(
+		time.Since(start).Seconds(),
+	)
+}
+
+// observeCacheLookup records metrics for a single cache lookup and returns the
+// result of the lookup.
+func observeCacheLookup(
+	layer string,
+	record *BadgeRecord,
+	err error,
+) string {
+	result := cacheLookupResult(record, err)
+	cacheLookupsTotal.WithLabelValues(layer, result).Inc()
+	return result
+}
+
+// observeColdCachePrefetch records metrics for a single lookup of the cold
+// cache made ahead of fetching a fresh badge and returns the result of the
+// lookup. These are kept apart from other cache lookups so that cold cache
+// lookups continue to reflect only fallbacks to the cold cache.
+func observeColdCachePrefetch(record *BadgeRecord, err error) string {
+	result := cacheLookupResult(record, err)
+	coldCachePrefetchesTotal.WithLabelValues(result).Inc()
+	return result
+}
+
+// cacheLookupResult returns the result of a cache lookup for use in metrics.
+func cacheLookupResult(record *BadgeRecord, err error) string {
+	if err != nil {
+		return cacheResultError
+	}
+	if record != nil {
+		return cacheResultHit
+	}
+	return cacheResultMiss
+}
@@ -0,0 +1,105 @@
+package badges
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveGitHubRequest(t *testing.T) {
+	const testEndpoint = "test_endpoint"
+	testCases := []struct {
+		name         string
+		response     *github.Response
+		expectedCode string
+	}{
+		{
+			name:         "no response",
+			expectedCode: "none",
+		},
+		{
+			name: "response",
+			response: &github.Response{
+				Response: &http.Response{StatusCode: http.StatusNotModified},
+			},
+			expectedCode: "304",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			counter := githubRequestsTotal.WithLabelValues(
+				testEndpoint,
+				testCase.expectedCode,
+			)
+			before := testutil.ToFloat64(counter)
+			observeGitHubRequest(testEndpoint, time.Now(), testCase.response)
+			require.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func TestObserveCacheLookup(t *testing.T) {
+	testCases := []struct {
+		name           string
+		record         *BadgeRecord
+		err            error
+		expectedResult string
+	}{
+		{
+			name:           "error",
+			err:            errors.New("something went wrong"),
+			expectedResult: cacheResultError,
+		},
+		{
+			name:           "miss",
+			expectedResult: cacheResultMiss,
+		},
+		{
+			name:           "hit",
+			record:         &BadgeRecord{},
+			expectedResult: cacheResultHit,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			counter := cacheLookupsTotal.WithLabelValues(
+				cacheLayerWarm,
+				testCase.expectedResult,
+			)
+			before := testutil.ToFloat64(counter)
+			require.Equal(
+				t,
+				testCase.expectedResult,
+				observeCacheLookup(cacheLayerWarm, testCase.record, testCase.err),
+			)
+			require.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func TestObserveColdCachePrefetch(t *testing.T) {
+	counter := coldCachePrefetchesTotal.WithLabelValues(cacheResultHit)
+	before := testutil.ToFloat64(counter)
+	coldBefore := testutil.ToFloat64(
+		cacheLookupsTotal.WithLabelValues(cacheLayerCold, cacheResultHit),
+	)
+	require.Equal(
+		t,
+		cacheResultHit,
+		observeColdCachePrefetch(&BadgeRecord{}, nil),
+	)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	// Prefetches are not counted as lookups of the cold cache
+	require.Equal(
+		t,
+		coldBefore,
+		testutil.ToFloat64(
+			cacheLookupsTotal.WithLabelValues(cacheLayerCold, cacheResultHit),
+		),
+	)
+}
@@ -0,0 +1,270 @@
+package badges
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Popularity is the public interface for any component that can track how
+// frequently badges are requested.
+type Popularity interface {
+	// Record records a request for the badge with the specified cache key.
+	Record(ctx context.Context, key string) error
+	// Top returns the cache keys of the n most frequently requested badges, most
+	// popular first.
+	Top(ctx context.Context, n int) ([]string, error)
+}
+
+// PrewarmConfig represents configuration options for the Prewarmer.
+type PrewarmConfig struct {
+	// TopN is the number of most frequently requested badges to keep warm. If
+	// zero, pre-warming is disabled.
+	TopN int
+	// Interval is how often the Prewarmer looks for badges in need of
+	// refreshing. It must be shorter than Lead, or badges may expire from the
+	// warm cache between looks.
+	Interval time.Duration
+	// Lead is how long before a badge expires from the warm cache the Prewarmer
+	// refreshes it.
+	Lead time.Duration
+	// BudgetShare is the fraction, between 0 and 1, of the GitHub API rate limit
+	// the Prewarmer may use. The Prewarmer refrains from refreshing badges based
+	// on GitHub check suites once less than the remainder of the rate limit is
+	// left, reserving that for requests from viewers.
+	BudgetShare float64
+}
+
+// Prewarmer refreshes popular badges shortly before they expire from the warm
+// cache so that viewers of popular badges never wait on GitHub. Badges are
+// refreshed by replaying requests for them through the handler that serves
+// them, bypassing the warm cache, so that they are refreshed in exactly the
+// same manner as they would be upon a viewer's request.
+type Prewarmer struct {
+	config     PrewarmConfig
+	popularity Popularity
+	cache      Cache
+	rateLimits *RateLimits
+	handler    http.Handler
+}
+
+// NewPrewarmer returns a new Prewarmer that refreshes popular badges by
+// replaying requests for them through the provided handler.
+func NewPrewarmer(
+	config PrewarmConfig,
+	popularity Popularity,
+	cache Cache,
+	rateLimits *RateLimits,
+	handler http.Handler,
+) *Prewarmer {
+	return &Prewarmer{
+		config:     config,
+		popularity: popularity,
+		cache:      cache,
+		rateLimits: rateLimits,
+		handler:    handler,
+	}
+}
+
+// Track decorates the provided handler, recording requests it serves so that
+// the Prewarmer knows which badges are popular. Only requests for badges that
+// were served from the warm cache or fetched afresh are recorded, so requests
+// refused by client rate limits, or for repositories that don't exist, cannot
+// crowd out badges that are genuinely popular.
+func (p *Prewarmer) Track(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Don't count our own refreshes
+		if refreshFromContext(r.Context()) != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		t := &tracked{}
+		handler.ServeHTTP(w, r.WithContext(contextWithTracked(r.Context(), t)))
+		if t.outcome != outcomeWarm && t.outcome != outcomeFresh {
+			return
+		}
+		key := cacheKey(r)
+		if err := p.popularity.Record(r.Context(), key); err != nil {
+			slog.Error(
+				"error recording badge request",
+				"key", key,
+				"error", err,
+			)
+		}
+	})
+}
+
+// Run refreshes popular badges every p.config.Interval until the provided
+// context is canceled.
+func (p *Prewarmer) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.prewarm(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prewarm refreshes those of the most popular badges that are due to expire
+// from the warm cache. Badges based on GitHub check suites are refreshed only
+// for as long as the GitHub API budget allows.
+func (p *Prewarmer) prewarm(ctx context.Context) {
+	keys, err := p.popularity.Top(ctx, p.config.TopN)
+	if err != nil {
+		slog.Error("error retrieving popular badges", "error", err)
+		return
+	}
+	for _, key := range keys {
+		ttl, err := p.cache.WarmTTL(ctx, key)
+		if err != nil {
+			slog.Error(
+				"error retrieving TTL from warm cache",
+				"key", key,
+				"error", err,
+			)
+			continue
+		}
+		if ttl > p.config.Lead {
+			continue // Not due yet
+		}
+		// Only GitHub's rate limit is known to us, so only GitHub badges are
+		// budgeted
+		if isGitHubChecksBadge(key) && !p.withinBudget() {
+			prewarmRefreshesTotal.WithLabelValues(outcomeSkipped).Inc()
+			slog.Debug(
+				"GitHub API budget reserved; not pre-warming badge",
+				"key", key,
+			)
+			continue
+		}
+		p.refresh(ctx, key)
+	}
+}
+
+// withinBudget returns a bool indicating whether the Prewarmer may make
+// requests to GitHub without eating into the share of the rate limit reserved
+// for requests from viewers. Since a refresh may draw on any of the GitHub API
+// resources Badgr uses, every one of them must be within budget.
+func (p *Prewarmer) withinBudget() bool {
+	now := p.rateLimits.nowFn()
+	for _, status := range p.rateLimits.Statuses() {
+		if status.Limit == 0 || now.After(status.Reset) {
+			// Either we know nothing of the budget yet or it has since been reset
+			continue
+		}
+		if float64(status.Remaining) <=
+			float64(status.Limit)*(1-p.config.BudgetShare) {
+			return false
+		}
+	}
+	return true
+}
+
+// githubChecksRouter matches the cache keys of badges based on GitHub check
+// suites.
+var githubChecksRouter = func() *mux.Router {
+	router := mux.NewRouter()
+	router.Handle(GitHubChecksRoute, http.NotFoundHandler())
+	return router
+}()
+
+// isGitHubChecksBadge returns a bool indicating whether the specified cache
+// key is that of a badge based on GitHub check suites.
+func isGitHubChecksBadge(key string) bool {
+	u, err := url.Parse(key)
+	if err != nil {
+		return false
+	}
+	return githubChecksRouter.Match(
+		&http.Request{Method: http.MethodGet, URL: u},
+		&mux.RouteMatch{},
+	)
+}
+
+// refresh refreshes the badge with the specified cache key by replaying a
+// request for it through the handler.
+func (p *Prewarmer) refresh(ctx context.Context, key string) {
+	logger := slog.Default().With("prewarm", true, "key", key)
+	r := &refresh{}
+	req, err := http.NewRequestWithContext(
+		contextWithRefresh(ctx, r),
+		http.MethodGet,
+		key,
+		nil,
+	)
+	if err != nil {
+		logger.Error("error building request to refresh badge", "error", err)
+		return
+	}
+	p.handler.ServeHTTP(discardResponseWriter{}, req)
+	prewarmRefreshesTotal.WithLabelValues(r.outcome).Inc()
+	logger.Debug("pre-warmed badge", "outcome", r.outcome)
+}
+
+// refresh carries information between the Prewarmer and the handler about a
+// request the Prewarmer has replayed to refresh a badge.
+type refresh struct {
+	// outcome is the outcome of the request, set by the handler
+	outcome string
+}
+
+type refreshContextKey struct{}
+
+// contextWithRefresh returns a context indicating that the request it is
+// associated with was made by the Prewarmer to refresh a badge.
+func contextWithRefresh(ctx context.Context, r *refresh) context.Context {
+	return context.WithValue(ctx, refreshContextKey{}, r)
+}
+
+// refreshFromContext returns the refresh associated with the provided context,
+// or nil if the request the context is associated with was not made by the
+// Prewarmer.
+func refreshFromContext(ctx context.Context) *refresh {
+	r, _ := ctx.Value(refreshContextKey{}).(*refresh)
+	return r
+}
+
+// tracked carries the outcome of a request tracked by the Prewarmer from the
+// handler back to the Prewarmer.
+type tracked struct {
+	// outcome is the outcome of the request, set by the handler
+	outcome string
+}
+
+type trackedContextKey struct{}
+
+// contextWithTracked returns a context indicating that the request it is
+// associated with is tracked by the Prewarmer.
+func contextWithTracked(ctx context.Context, t *tracked) context.Context {
+	return context.WithValue(ctx, trackedContextKey{}, t)
+}
+
+// trackedFromContext returns the tracked associated with the provided context,
+// or nil if the request the context is associated with is not tracked by the
+// Prewarmer.
+func trackedFromContext(ctx context.Context) *tracked {
+	t, _ := ctx.Value(trackedContextKey{}).(*tracked)
+	return t
+}
+
+// discardResponseWriter is an http.ResponseWriter that discards everything
+// written to it.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (discardResponseWriter) WriteHeader(int) {}
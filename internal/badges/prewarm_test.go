@@ -0,0 +1,324 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPrewarmer(t *testing.T) {
+	testConfig := PrewarmConfig{TopN: 10}
+	testPopularity := &mockPopularity{}
+	testCache := &mockCache{}
+	testRateLimits := NewRateLimits()
+	testHandler := http.NotFoundHandler()
+	p := NewPrewarmer(
+		testConfig,
+		testPopularity,
+		testCache,
+		testRateLimits,
+		testHandler,
+	)
+	require.Equal(t, testConfig, p.config)
+	require.Same(t, testPopularity, p.popularity)
+	require.Same(t, testCache, p.cache)
+	require.Same(t, testRateLimits, p.rateLimits)
+	require.NotNil(t, p.handler)
+}
+
+func TestPrewarmerTrack(t *testing.T) {
+	const testKey = "/v1/github/checks/krancour/foo/badge.svg?branch=main"
+	testCases := []struct {
+		name             string
+		refresh          bool
+		outcome          string
+		expectedRecorded []string
+	}{
+		{
+			name:             "served from warm cache",
+			outcome:          outcomeWarm,
+			expectedRecorded: []string{testKey},
+		},
+		{
+			name:             "fetched afresh",
+			outcome:          outcomeFresh,
+			expectedRecorded: []string{testKey},
+		},
+		{
+			// For instance, because a client rate limit was exceeded
+			name:    "error",
+			outcome: outcomeError,
+		},
+		{
+			name:    "not found",
+			outcome: outcomeNotFound,
+		},
+		{
+			name:    "refresh",
+			refresh: true,
+			outcome: outcomeFresh,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var recorded []string
+			p := &Prewarmer{
+				popularity: &mockPopularity{
+					RecordFn: func(_ context.Context, key string) error {
+						recorded = append(recorded, key)
+						return nil
+					},
+				},
+			}
+			var served bool
+			handler := p.Track(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					served = true
+					// The handler reports the outcome of requests that are tracked
+					if tracked := trackedFromContext(r.Context()); tracked != nil {
+						tracked.outcome = testCase.outcome
+					}
+				}),
+			)
+			req := httptest.NewRequest(http.MethodGet, testKey, nil)
+			if testCase.refresh {
+				req = req.WithContext(contextWithRefresh(req.Context(), &refresh{}))
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			require.True(t, served)
+			require.Equal(t, testCase.expectedRecorded, recorded)
+		})
+	}
+}
+
+func TestPrewarmerPrewarm(t *testing.T) {
+	testCases := []struct {
+		name       string
+		prewarmer  *Prewarmer
+		assertions func(refreshed []string)
+	}{
+		{
+			name: "error retrieving popular badges",
+			prewarmer: &Prewarmer{
+				popularity: &mockPopularity{
+					TopFn: func(context.Context, int) ([]string, error) {
+						return nil, errors.New("something went wrong")
+					},
+				},
+			},
+			assertions: func(refreshed []string) {
+				require.Empty(t, refreshed)
+			},
+		},
+		{
+			name: "only badges about to expire are refreshed",
+			prewarmer: &Prewarmer{
+				popularity: &mockPopularity{
+					TopFn: func(_ context.Context, n int) ([]string, error) {
+						require.Equal(t, 3, n)
+						return []string{"/fresh", "/expiring", "/expired"}, nil
+					},
+				},
+				cache: &mockCache{
+					WarmTTLFn: func(
+						_ context.Context,
+						key string,
+					) (time.Duration, error) {
+						switch key {
+						case "/fresh":
+							return 50 * time.Second, nil
+						case "/expiring":
+							return 10 * time.Second, nil
+						}
+						return 0, nil
+					},
+				},
+			},
+			assertions: func(refreshed []string) {
+				require.Equal(t, []string{"/expiring", "/expired"}, refreshed)
+			},
+		},
+		{
+			name: "error retrieving TTL",
+			prewarmer: &Prewarmer{
+				popularity: &mockPopularity{
+					TopFn: func(context.Context, int) ([]string, error) {
+						return []string{"/foo", "/bar"}, nil
+					},
+				},
+				cache: &mockCache{
+					WarmTTLFn: func(
+						_ context.Context,
+						key string,
+					) (time.Duration, error) {
+						if key == "/foo" {
+							return 0, errors.New("something went wrong")
+						}
+						return 0, nil
+					},
+				},
+			},
+			assertions: func(refreshed []string) {
+				require.Equal(t, []string{"/bar"}, refreshed)
+			},
+		},
+		{
+			name: "budget reserved for GitHub badges",
+			prewarmer: &Prewarmer{
+				rateLimits: &RateLimits{
+					statuses: map[string]RateLimitStatus{
+						rateLimitResourceCore: {
+							Limit:     5000,
+							Remaining: 4000,
+							Reset:     time.Now().Add(time.Hour),
+						},
+					},
+				},
+				popularity: &mockPopularity{
+					TopFn: func(context.Context, int) ([]string, error) {
+						return []string{
+							"/v1/github/checks/krancour/foo/badge.svg",
+							"/v1/gitlab/pipelines/krancour/foo/badge.svg",
+							"/v1/github/checks/krancour/bar/badge.svg?branch=main",
+						}, nil
+					},
+				},
+				cache: &mockCache{
+					WarmTTLFn: func(context.Context, string) (time.Duration, error) {
+						return 0, nil
+					},
+				},
+			},
+			assertions: func(refreshed []string) {
+				// Only GitHub's budget is known, so other badges are refreshed
+				require.Equal(
+					t,
+					[]string{"/v1/gitlab/pipelines/krancour/foo/badge.svg"},
+					refreshed,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var refreshed []string
+			testCase.prewarmer.config = PrewarmConfig{
+				TopN:        3,
+				Lead:        20 * time.Second,
+				BudgetShare: 0.2,
+			}
+			if testCase.prewarmer.rateLimits == nil {
+				testCase.prewarmer.rateLimits = NewRateLimits()
+			}
+			testCase.prewarmer.rateLimits.nowFn = time.Now
+			testCase.prewarmer.handler = http.HandlerFunc(
+				func(_ http.ResponseWriter, r *http.Request) {
+					// Every refresh should be identifiable as such
+					refresh := refreshFromContext(r.Context())
+					require.NotNil(t, refresh)
+					refresh.outcome = outcomeFresh
+					refreshed = append(refreshed, r.URL.String())
+				},
+			)
+			testCase.prewarmer.prewarm(context.Background())
+			testCase.assertions(refreshed)
+		})
+	}
+}
+
+func TestPrewarmerWithinBudget(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name     string
+		statuses map[string]RateLimitStatus
+		expected bool
+	}{
+		{
+			name:     "budget unknown",
+			expected: true,
+		},
+		{
+			name: "budget reset",
+			statuses: map[string]RateLimitStatus{
+				rateLimitResourceCore: {
+					Limit: 5000,
+					Reset: now.Add(-time.Minute),
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "within share",
+			statuses: map[string]RateLimitStatus{
+				rateLimitResourceCore: {
+					Limit:     5000,
+					Remaining: 4500,
+					Reset:     now.Add(time.Minute),
+				},
+				rateLimitResourceGraphQL: {
+					Limit:     5000,
+					Remaining: 4500,
+					Reset:     now.Add(time.Minute),
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "share used up",
+			statuses: map[string]RateLimitStatus{
+				rateLimitResourceCore: {
+					Limit:     5000,
+					Remaining: 4000,
+					Reset:     now.Add(time.Minute),
+				},
+			},
+		},
+		{
+			name: "share of one resource used up",
+			statuses: map[string]RateLimitStatus{
+				rateLimitResourceCore: {
+					Limit:     5000,
+					Remaining: 4500,
+					Reset:     now.Add(time.Minute),
+				},
+				rateLimitResourceGraphQL: {
+					Limit:     5000,
+					Remaining: 4000,
+					Reset:     now.Add(time.Minute),
+				},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p := &Prewarmer{
+				config: PrewarmConfig{BudgetShare: 0.2},
+				rateLimits: &RateLimits{
+					statuses: testCase.statuses,
+					nowFn: func() time.Time {
+						return now
+					},
+				},
+			}
+			require.Equal(t, testCase.expected, p.withinBudget())
+		})
+	}
+}
+
+type mockPopularity struct {
+	RecordFn func(ctx context.Context, key string) error
+	TopFn    func(ctx context.Context, n int) ([]string, error)
+}
+
+func (m *mockPopularity) Record(ctx context.Context, key string) error {
+	return m.RecordFn(ctx, key)
+}
+
+func (m *mockPopularity) Top(ctx context.Context, n int) ([]string, error) {
+	return m.TopFn(ctx, n)
+}
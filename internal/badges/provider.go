@@ -0,0 +1,240 @@
+package badges
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Provider is the public interface for any source of badges, such as GitHub
+// check suites. Every provider's badges are served by the same handler, which
+// takes care of caching, errors, rendering, and metrics, so a provider need
+// only know how to identify what a badge is about, parse options, and fetch a
+// fresh badge. Providers that can determine whether a repository is private
+// should also implement the RepoVisibility interface, so that signed URLs may
+// be required for badges for private repositories.
+type Provider interface {
+	// Name returns a short name that identifies the provider in logs and spans.
+	Name() string
+	// Route returns the path template, as understood by gorilla/mux, at which
+	// the provider's badges are served.
+	Route() string
+	// Subject returns what a badge is about, given the variables of the
+	// provider's route. Access control, signing, API key scopes, and per-owner
+	// rate limits are all based on it.
+	Subject(vars map[string]string) Subject
+	// ParseOptions parses provider-specific options from the query string of a
+	// request for a badge. If an error is returned, a "bad request" badge is
+	// served.
+	ParseOptions(query url.Values) (interface{}, error)
+	// Fetch obtains a fresh badge. Errors are classified by the handler to
+	// determine what is served instead.
+	Fetch(ctx context.Context, req BadgeRequest) (Badge, error)
+}
+
+// Subject identifies what a badge is about, such as a GitHub repository.
+type Subject struct {
+	// Owner is the owner of the repository the badge is about. For badges about
+	// something owned by nothing more specific than the provider's backend
+	// itself, such as a Brigade project, it is that thing, and Repo is empty.
+	Owner string
+	// Repo is the name of the repository the badge is about, if any.
+	Repo string
+}
+
+// RepoSubject returns the Subject identified by the "owner" and "repo"
+// variables of a route, as used by GitHub, for instance.
+func RepoSubject(vars map[string]string) Subject {
+	return Subject{
+		Owner: vars["owner"],
+		Repo:  vars["repo"],
+	}
+}
+
+// PathSubject returns the Subject identified by a slash-delimited path, such as
+// that of a GitLab project, with everything before the last slash as the owner
+// and the rest as the repo. A path without any slash is the owner alone.
+func PathSubject(path string) Subject {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return Subject{Owner: path}
+	}
+	return Subject{
+		Owner: path[:i],
+		Repo:  path[i+1:],
+	}
+}
+
+// String returns the Subject as "owner/repo", or as just the owner if Repo is
+// empty. This is what access rules and the scopes of API keys are matched
+// against.
+func (s Subject) String() string {
+	if s.Repo == "" {
+		return s.Owner
+	}
+	return s.Owner + "/" + s.Repo
+}
+
+type subjectContextKey struct{}
+
+// subjectHandler is an http.Handler that makes the Subject of each request,
+// as determined by a Provider, available to another http.Handler through the
+// request's context.
+type subjectHandler struct {
+	provider Provider
+	handler  http.Handler
+}
+
+// NewSubjectHandler returns an http.Handler that determines the Subject of
+// each request for a badge using the provided Provider and delegates the
+// request to the provided http.Handler, which, along with anything it
+// delegates to in turn, such as access control, can then find the Subject in
+// the request's context.
+func NewSubjectHandler(provider Provider, handler http.Handler) http.Handler {
+	return &subjectHandler{
+		provider: provider,
+		handler:  handler,
+	}
+}
+
+func (s *subjectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subject := s.provider.Subject(mux.Vars(r))
+	s.handler.ServeHTTP(
+		w,
+		r.WithContext(context.WithValue(r.Context(), subjectContextKey{}, subject)),
+	)
+}
+
+// subjectFromRequest returns the Subject of the provided request for a badge.
+// If none was determined by a handler returned from NewSubjectHandler, the
+// Subject is identified by the request's "owner" and "repo" route variables.
+func subjectFromRequest(r *http.Request) Subject {
+	if subject, ok := r.Context().Value(subjectContextKey{}).(Subject); ok {
+		return subject
+	}
+	return RepoSubject(mux.Vars(r))
+}
+
+// BadgeRequest represents a request for a fresh badge from a Provider.
+type BadgeRequest struct {
+	// Owner is the owner of the badge's Subject.
+	Owner string
+	// Repo is the repository of the badge's Subject, if any.
+	Repo string
+	// Options are the options returned by the Provider's ParseOptions function.
+	Options interface{}
+	// Cached, if non-nil, is the last known result for an otherwise identical
+	// request. Providers may use it to ask only for what has changed since.
+	Cached *BadgeRecord
+}
+
+// ProviderFactory returns a new Provider configured from the provided
+// Settings, or nil if the Settings leave the provider disabled.
+type ProviderFactory func(settings Settings) (Provider, error)
+
+var (
+	providerFactoriesMu sync.RWMutex
+	providerFactories   = map[string]ProviderFactory{}
+)
+
+// RegisterProviderFactory makes a ProviderFactory available, under the
+// specified name, to every Registry. It is intended to be called from the init
+// function of the package that implements the provider, so that the provider
+// is available wherever that package is imported. It panics if a
+// ProviderFactory with the same name has already been registered.
+func RegisterProviderFactory(name string, factory ProviderFactory) {
+	providerFactoriesMu.Lock()
+	defer providerFactoriesMu.Unlock()
+	if _, ok := providerFactories[name]; ok {
+		panic("a provider factory named " + name + " is already registered")
+	}
+	providerFactories[name] = factory
+}
+
+// Registry is a collection of Providers, each of which serves badges at its own
+// route.
+type Registry struct {
+	providers []Provider
+	// router matches requests for badges from any registered Provider. A route
+	// is added to it as each Provider is registered.
+	router *mux.Router
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		router: mux.NewRouter(),
+	}
+}
+
+// Register adds the provided Provider to the Registry. An error is returned if
+// a Provider with the same name or route has already been registered.
+func (r *Registry) Register(provider Provider) error {
+	for _, registered := range r.providers {
+		if registered.Name() == provider.Name() {
+			return errors.Errorf(
+				"a provider named %q is already registered",
+				provider.Name(),
+			)
+		}
+		if registered.Route() == provider.Route() {
+			return errors.Errorf(
+				"provider %q is already registered at route %q",
+				registered.Name(),
+				provider.Route(),
+			)
+		}
+	}
+	r.providers = append(r.providers, provider)
+	r.router.Handle(provider.Route(), http.NotFoundHandler()).
+		Methods(http.MethodGet)
+	return nil
+}
+
+// RegisterConfigured adds to the Registry every Provider that a registered
+// ProviderFactory creates from the provided Settings, in order of the names of
+// the factories.
+func (r *Registry) RegisterConfigured(settings Settings) error {
+	providerFactoriesMu.RLock()
+	defer providerFactoriesMu.RUnlock()
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		provider, err := providerFactories[name](settings)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring provider %q", name)
+		}
+		if provider == nil {
+			continue
+		}
+		if err = r.Register(provider); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Providers returns every registered Provider in the order in which they were
+// registered.
+func (r *Registry) Providers() []Provider {
+	return append([]Provider{}, r.providers...)
+}
+
+// Match returns the route variables of the provided request and true if it is
+// a request for a badge from a registered Provider, and false otherwise.
+func (r *Registry) Match(req *http.Request) (map[string]string, bool) {
+	match := mux.RouteMatch{}
+	if !r.router.Match(req, &match) || match.MatchErr != nil {
+		return nil, false
+	}
+	return match.Vars, true
+}
@@ -0,0 +1,215 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectString(t *testing.T) {
+	require.Equal(
+		t,
+		"brigadecore/badgr",
+		Subject{Owner: "brigadecore", Repo: "badgr"}.String(),
+	)
+	require.Equal(t, "badgr", Subject{Owner: "badgr"}.String())
+}
+
+func TestPathSubject(t *testing.T) {
+	require.Equal(
+		t,
+		Subject{Owner: "group/subgroup", Repo: "project"},
+		PathSubject("group/subgroup/project"),
+	)
+	require.Equal(t, Subject{Owner: "42"}, PathSubject("42"))
+}
+
+func TestSubjectHandler(t *testing.T) {
+	router := mux.NewRouter()
+	var subject Subject
+	router.Handle(
+		"/v1/foo/{project}/badge.svg",
+		NewSubjectHandler(
+			&mockProvider{
+				SubjectFn: func(vars map[string]string) Subject {
+					return Subject{Owner: vars["project"]}
+				},
+			},
+			http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				subject = subjectFromRequest(r)
+			}),
+		),
+	)
+	router.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/v1/foo/badgr/badge.svg", nil),
+	)
+	require.Equal(t, Subject{Owner: "badgr"}, subject)
+
+	// Without a subject handler, the subject is identified by route variables
+	router = mux.NewRouter()
+	router.HandleFunc(
+		GitHubChecksRoute,
+		func(_ http.ResponseWriter, r *http.Request) {
+			subject = subjectFromRequest(r)
+		},
+	)
+	router.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest(
+			http.MethodGet,
+			"/v1/github/checks/brigadecore/badgr/badge.svg",
+			nil,
+		),
+	)
+	require.Equal(t, Subject{Owner: "brigadecore", Repo: "badgr"}, subject)
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := NewRegistry()
+	foo := &mockProvider{
+		NameFn:  func() string { return "foo" },
+		RouteFn: func() string { return "/v1/foo/{owner}/{repo}/badge.svg" },
+	}
+	require.NoError(t, registry.Register(foo))
+
+	err := registry.Register(
+		&mockProvider{
+			NameFn:  func() string { return "foo" },
+			RouteFn: func() string { return "/v1/bar/{owner}/{repo}/badge.svg" },
+		},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already registered")
+
+	err = registry.Register(
+		&mockProvider{
+			NameFn:  func() string { return "bar" },
+			RouteFn: func() string { return "/v1/foo/{owner}/{repo}/badge.svg" },
+		},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already registered at route")
+
+	bar := &mockProvider{
+		NameFn:  func() string { return "bar" },
+		RouteFn: func() string { return "/v1/bar/{owner}/{repo}/badge.svg" },
+	}
+	require.NoError(t, registry.Register(bar))
+	require.Equal(t, []Provider{foo, bar}, registry.Providers())
+}
+
+func TestRegistryRegisterConfigured(t *testing.T) {
+	foo := &mockProvider{
+		NameFn:  func() string { return "foo" },
+		RouteFn: func() string { return "/v1/foo/{owner}/{repo}/badge.svg" },
+	}
+	RegisterProviderFactory(
+		"test-foo",
+		func(settings Settings) (Provider, error) {
+			switch settings.Setting("FOO") {
+			case "":
+				return nil, nil
+			case "invalid":
+				return nil, errors.New("something went wrong")
+			}
+			return foo, nil
+		},
+	)
+	require.Panics(t, func() {
+		RegisterProviderFactory(
+			"test-foo",
+			func(Settings) (Provider, error) { return nil, nil },
+		)
+	})
+
+	registry := NewRegistry()
+	require.NoError(t, registry.RegisterConfigured(SettingsMap{}))
+	require.Empty(t, registry.Providers())
+
+	registry = NewRegistry()
+	err := registry.RegisterConfigured(SettingsMap{"FOO": "invalid"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `error configuring provider "test-foo"`)
+
+	registry = NewRegistry()
+	require.NoError(t, registry.RegisterConfigured(SettingsMap{"FOO": "yes"}))
+	require.Equal(t, []Provider{foo}, registry.Providers())
+}
+
+func TestRegistryMatch(t *testing.T) {
+	registry := NewRegistry()
+	require.NoError(
+		t,
+		registry.Register(NewGitHubChecksProvider(&mockService{}, nil)),
+	)
+	testCases := []struct {
+		name         string
+		url          string
+		expectedVars map[string]string
+	}{
+		{
+			name: "not a badge",
+			url:  "/healthz",
+		},
+		{
+			name: "badge",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			expectedVars: map[string]string{
+				"owner": "krancour",
+				"repo":  "foo",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			u, err := url.Parse(testCase.url)
+			require.NoError(t, err)
+			vars, ok := registry.Match(
+				&http.Request{Method: http.MethodGet, URL: u},
+			)
+			require.Equal(t, testCase.expectedVars != nil, ok)
+			require.Equal(t, testCase.expectedVars, vars)
+		})
+	}
+}
+
+type mockProvider struct {
+	NameFn         func() string
+	RouteFn        func() string
+	SubjectFn      func(vars map[string]string) Subject
+	ParseOptionsFn func(query url.Values) (interface{}, error)
+	FetchFn        func(ctx context.Context, req BadgeRequest) (Badge, error)
+}
+
+func (m *mockProvider) Name() string {
+	return m.NameFn()
+}
+
+func (m *mockProvider) Route() string {
+	return m.RouteFn()
+}
+
+func (m *mockProvider) Subject(vars map[string]string) Subject {
+	if m.SubjectFn == nil {
+		return RepoSubject(vars)
+	}
+	return m.SubjectFn(vars)
+}
+
+func (m *mockProvider) ParseOptions(query url.Values) (interface{}, error) {
+	return m.ParseOptionsFn(query)
+}
+
+func (m *mockProvider) Fetch(
+	ctx context.Context,
+	req BadgeRequest,
+) (Badge, error) {
+	return m.FetchFn(ctx, req)
+}
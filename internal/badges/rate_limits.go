@@ -0,0 +1,164 @@
+package badges
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+)
+
+// defaultRetryAfter is how long Badgr refrains from querying GitHub after
+// tripping a secondary rate limit when GitHub did not say how long to wait.
+// GitHub's documentation recommends waiting at least one minute in such cases.
+const defaultRetryAfter = time.Minute
+
+// GitHub meters requests to its REST and GraphQL APIs against separate budgets,
+// which it calls resources. These are the resources Badgr tracks.
+const (
+	rateLimitResourceCore    = "core"
+	rateLimitResourceGraphQL = "graphql"
+)
+
+// RateLimitStatus is a point-in-time snapshot of Badgr's budget for a single
+// GitHub API resource.
+type RateLimitStatus struct {
+	// Limit is the maximum number of requests permitted per rate limit window.
+	Limit int `json:"limit"`
+	// Remaining is the number of requests remaining in the current rate limit
+	// window.
+	Remaining int `json:"remaining"`
+	// Reset is the time at which the current rate limit window resets.
+	Reset time.Time `json:"reset"`
+	// RetryAfter, if non-zero, is the time before which no requests should be
+	// made because a secondary rate limit has been tripped.
+	RetryAfter time.Time `json:"retryAfter,omitempty"`
+}
+
+// RateLimits tracks Badgr's GitHub API budget for each resource as reported by
+// GitHub so that Badgr can refrain from making requests that are certain to
+// fail. It is safe for concurrent use.
+type RateLimits struct {
+	mu       sync.RWMutex
+	statuses map[string]RateLimitStatus
+	// nowFn is overridable for testing purposes
+	nowFn func() time.Time
+}
+
+// NewRateLimits returns a new RateLimits for tracking Badgr's GitHub API
+// budget.
+func NewRateLimits() *RateLimits {
+	return &RateLimits{
+		statuses: map[string]RateLimitStatus{},
+		nowFn:    time.Now,
+	}
+}
+
+// Status returns a snapshot of Badgr's current budget for the specified GitHub
+// API resource.
+func (r *RateLimits) Status(resource string) RateLimitStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.statuses[resource]
+}
+
+// Statuses returns a snapshot of Badgr's current budget for every GitHub API
+// resource it has heard from GitHub about, indexed by resource.
+func (r *RateLimits) Statuses() map[string]RateLimitStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	statuses := make(map[string]RateLimitStatus, len(r.statuses))
+	for resource, status := range r.statuses {
+		statuses[resource] = status
+	}
+	return statuses
+}
+
+// ServeHTTP writes a snapshot of Badgr's current GitHub API budget for every
+// resource to the response as JSON. It is intended for use as a debug
+// endpoint.
+func (r *RateLimits) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// There's nothing useful to do with an error here
+	_ = json.NewEncoder(w).Encode(r.Statuses())
+}
+
+// record updates the tracked budget for the specified resource using the rate
+// limit headers from a response from GitHub and/or the error returned
+// alongside it.
+func (r *RateLimits) record(
+	resource string,
+	response *github.Response,
+	err error,
+) {
+	var rate *github.Rate
+	if response != nil && response.Response != nil &&
+		response.Header.Get("X-RateLimit-Remaining") != "" {
+		rate = &response.Rate
+	}
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		rate = &rateLimitErr.Rate
+	}
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	isAbuseRateLimitErr := errors.As(err, &abuseRateLimitErr)
+	if rate == nil && !isAbuseRateLimitErr {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses == nil {
+		r.statuses = map[string]RateLimitStatus{}
+	}
+	status := r.statuses[resource]
+	if rate != nil {
+		status.Limit = rate.Limit
+		status.Remaining = rate.Remaining
+		status.Reset = rate.Reset.Time
+		githubRateLimitLimit.WithLabelValues(resource).Set(float64(rate.Limit))
+		githubRateLimitRemaining.WithLabelValues(resource).Set(
+			float64(rate.Remaining),
+		)
+		githubRateLimitReset.WithLabelValues(resource).Set(
+			float64(rate.Reset.Unix()),
+		)
+	}
+	if isAbuseRateLimitErr {
+		retryAfter := defaultRetryAfter
+		if abuseRateLimitErr.RetryAfter != nil {
+			retryAfter = *abuseRateLimitErr.RetryAfter
+		}
+		status.RetryAfter = r.nowFn().Add(retryAfter)
+	}
+	r.statuses[resource] = status
+}
+
+// check returns an error if the tracked budget for the specified resource
+// indicates that a request to GitHub made right now is certain to fail.
+func (r *RateLimits) check(resource string) error {
+	now := r.nowFn()
+	status := r.Status(resource)
+	if now.Before(status.RetryAfter) {
+		return &rateLimitedError{until: status.RetryAfter}
+	}
+	if status.Remaining == 0 && now.Before(status.Reset) {
+		return &rateLimitedError{until: status.Reset}
+	}
+	return nil
+}
+
+// rateLimitedError is returned when Badgr refrains from making a request to
+// GitHub because its budget has been exhausted.
+type rateLimitedError struct {
+	until time.Time
+}
+
+func (r *rateLimitedError) Error() string {
+	return fmt.Sprintf(
+		"GitHub API rate limit exhausted; not querying GitHub until %s",
+		r.until.Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,233 @@
+package badges
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRateLimits(t *testing.T) {
+	rateLimits := NewRateLimits()
+	require.NotNil(t, rateLimits.nowFn)
+	require.Empty(t, rateLimits.Statuses())
+}
+
+func TestRateLimitsRecord(t *testing.T) {
+	testNow := time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC)
+	testReset := testNow.Add(30 * time.Minute)
+	testRetryAfter := 2 * time.Minute
+	testCases := []struct {
+		name           string
+		response       *github.Response
+		err            error
+		expectedStatus RateLimitStatus
+	}{
+		{
+			name:           "no response",
+			err:            errors.New("something went wrong"),
+			expectedStatus: RateLimitStatus{},
+		},
+		{
+			name: "response without rate limit headers",
+			response: &github.Response{
+				Response: &http.Response{Header: http.Header{}},
+			},
+			expectedStatus: RateLimitStatus{},
+		},
+		{
+			name: "response with rate limit headers",
+			response: &github.Response{
+				Response: &http.Response{
+					Header: http.Header{
+						"X-Ratelimit-Remaining": []string{"42"},
+					},
+				},
+				Rate: github.Rate{
+					Limit:     60,
+					Remaining: 42,
+					Reset:     github.Timestamp{Time: testReset},
+				},
+			},
+			expectedStatus: RateLimitStatus{
+				Limit:     60,
+				Remaining: 42,
+				Reset:     testReset,
+			},
+		},
+		{
+			name: "rate limit error",
+			err: &github.RateLimitError{
+				Rate: github.Rate{
+					Limit:     60,
+					Remaining: 0,
+					Reset:     github.Timestamp{Time: testReset},
+				},
+			},
+			expectedStatus: RateLimitStatus{
+				Limit: 60,
+				Reset: testReset,
+			},
+		},
+		{
+			name: "secondary rate limit error with retry after",
+			err: &github.AbuseRateLimitError{
+				RetryAfter: &testRetryAfter,
+			},
+			expectedStatus: RateLimitStatus{
+				RetryAfter: testNow.Add(testRetryAfter),
+			},
+		},
+		{
+			name: "secondary rate limit error without retry after",
+			err:  &github.AbuseRateLimitError{},
+			expectedStatus: RateLimitStatus{
+				RetryAfter: testNow.Add(defaultRetryAfter),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rateLimits := &RateLimits{
+				nowFn: func() time.Time { return testNow },
+			}
+			rateLimits.record(
+				rateLimitResourceCore,
+				testCase.response,
+				testCase.err,
+			)
+			require.Equal(
+				t,
+				testCase.expectedStatus,
+				rateLimits.Status(rateLimitResourceCore),
+			)
+		})
+	}
+}
+
+func TestRateLimitsCheck(t *testing.T) {
+	testNow := time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name       string
+		status     RateLimitStatus
+		assertions func(error)
+	}{
+		{
+			name:   "nothing known yet",
+			status: RateLimitStatus{},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "budget remaining",
+			status: RateLimitStatus{
+				Limit:     60,
+				Remaining: 1,
+				Reset:     testNow.Add(time.Minute),
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "budget exhausted",
+			status: RateLimitStatus{
+				Limit: 60,
+				Reset: testNow.Add(time.Minute),
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "rate limit exhausted")
+			},
+		},
+		{
+			name: "budget exhausted; window has reset",
+			status: RateLimitStatus{
+				Limit: 60,
+				Reset: testNow.Add(-time.Minute),
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "secondary rate limit in effect",
+			status: RateLimitStatus{
+				Limit:      60,
+				Remaining:  42,
+				Reset:      testNow.Add(time.Minute),
+				RetryAfter: testNow.Add(time.Second),
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "rate limit exhausted")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rateLimits := &RateLimits{
+				statuses: map[string]RateLimitStatus{
+					rateLimitResourceCore: testCase.status,
+				},
+				nowFn: func() time.Time { return testNow },
+			}
+			testCase.assertions(rateLimits.check(rateLimitResourceCore))
+		})
+	}
+}
+
+func TestRateLimitsResourcesAreIndependent(t *testing.T) {
+	testNow := time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC)
+	rateLimits := &RateLimits{
+		nowFn: func() time.Time { return testNow },
+	}
+	rateLimits.record(
+		rateLimitResourceGraphQL,
+		nil,
+		&github.RateLimitError{
+			Rate: github.Rate{
+				Limit: 5000,
+				Reset: github.Timestamp{Time: testNow.Add(time.Minute)},
+			},
+		},
+	)
+	require.Error(t, rateLimits.check(rateLimitResourceGraphQL))
+	require.NoError(t, rateLimits.check(rateLimitResourceCore))
+	require.Equal(
+		t,
+		RateLimitStatus{},
+		rateLimits.Status(rateLimitResourceCore),
+	)
+}
+
+func TestRateLimitsServeHTTP(t *testing.T) {
+	testStatuses := map[string]RateLimitStatus{
+		rateLimitResourceCore: {
+			Limit:     60,
+			Remaining: 42,
+			Reset:     time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC),
+		},
+		rateLimitResourceGraphQL: {
+			Limit:     5000,
+			Remaining: 4999,
+			Reset:     time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+	rateLimits := &RateLimits{statuses: testStatuses}
+	rr := httptest.NewRecorder()
+	rateLimits.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	res := rr.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	statuses := map[string]RateLimitStatus{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&statuses))
+	require.Equal(t, testStatuses, statuses)
+}
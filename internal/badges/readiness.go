@@ -0,0 +1,155 @@
+package badges
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessCheckTimeout is how long any one dependency is given to respond to
+// a readiness check.
+const readinessCheckTimeout = 2 * time.Second
+
+// Statuses of a dependency or of Badgr as a whole, as reported by the readiness
+// endpoint
+const (
+	readinessStatusOK       = "ok"
+	readinessStatusError    = "error"
+	readinessStatusReady    = "ready"
+	readinessStatusNotReady = "not ready"
+)
+
+// Names of dependencies, as reported by the readiness endpoint
+const (
+	dependencyRedis  = "redis"
+	dependencyGitHub = "github"
+)
+
+// ReadinessConfig represents configuration options for the readiness endpoint.
+type ReadinessConfig struct {
+	// CheckGitHub specifies whether GitHub's reachability should be considered
+	// when determining readiness. Redis's reachability is always considered.
+	CheckGitHub bool
+	// CacheTTL is how long the result of a readiness check is reused before
+	// dependencies are checked again. This prevents frequent probes from
+	// amplifying load on the dependencies.
+	CacheTTL time.Duration
+}
+
+// DependencyStatus is the status of a single dependency.
+type DependencyStatus struct {
+	// Status is either "ok" or "error".
+	Status string `json:"status"`
+	// Error describes why the dependency is unavailable.
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessReport reports whether Badgr is ready to serve badges.
+type ReadinessReport struct {
+	// Status is either "ready" or "not ready".
+	Status string `json:"status"`
+	// Dependencies reports the status of each dependency that was checked,
+	// indexed by name.
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+	// GitHubCircuitBreaker is the state of the circuit breaker guarding requests
+	// to GitHub. This is informational only. An open circuit does not render
+	// Badgr unready since results can still be served from the cold cache.
+	GitHubCircuitBreaker CircuitState `json:"githubCircuitBreaker"`
+	// CheckedAt is the time at which dependencies were checked.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// readiness is an implementation of the http.Handler interface that reports
+// whether Badgr's dependencies are reachable.
+type readiness struct {
+	config  ReadinessConfig
+	cache   Cache
+	service Service
+	breaker *CircuitBreaker
+	mu      sync.Mutex
+	report  *ReadinessReport
+	// nowFn is overridable for testing purposes
+	nowFn func() time.Time
+}
+
+// NewReadinessHandler returns an implementation of the http.Handler interface
+// that reports, as JSON, whether the provided Cache and, optionally, the
+// provided Service's upstream are reachable, along with the state of the
+// provided CircuitBreaker. It responds with a 200 if all checked dependencies
+// are reachable and a 503 otherwise.
+func NewReadinessHandler(
+	config ReadinessConfig,
+	cache Cache,
+	service Service,
+	breaker *CircuitBreaker,
+) http.Handler {
+	return &readiness{
+		config:  config,
+		cache:   cache,
+		service: service,
+		breaker: breaker,
+		nowFn:   time.Now,
+	}
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := r.check(req.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != readinessStatusReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// There's nothing useful to do with an error here
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// check returns a report on the readiness of Badgr's dependencies. A recent
+// report is reused if one is available. Concurrent callers wait for a single
+// check to complete rather than each checking dependencies themselves.
+func (r *readiness) check(ctx context.Context) ReadinessReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.nowFn()
+	if r.report != nil && now.Before(r.report.CheckedAt.Add(r.config.CacheTTL)) {
+		return *r.report
+	}
+	report := ReadinessReport{
+		Status:               readinessStatusReady,
+		Dependencies:         map[string]DependencyStatus{},
+		GitHubCircuitBreaker: r.breaker.State(),
+		CheckedAt:            now,
+	}
+	checks := map[string]func(context.Context) error{
+		dependencyRedis: r.cache.Ping,
+	}
+	if r.config.CheckGitHub {
+		checks[dependencyGitHub] = r.service.Ping
+	}
+	for name, checkFn := range checks {
+		status := DependencyStatus{Status: readinessStatusOK}
+		if err := checkDependency(ctx, checkFn); err != nil {
+			status.Status = readinessStatusError
+			status.Error = err.Error()
+			report.Status = readinessStatusNotReady
+		}
+		report.Dependencies[name] = status
+	}
+	r.report = &report
+	return report
+}
+
+// checkDependency invokes the provided function to check a single dependency,
+// giving it a limited amount of time to respond. Since the result may be
+// reused for other callers, the check is not canceled if the caller goes away.
+func checkDependency(
+	ctx context.Context,
+	checkFn func(context.Context) error,
+) error {
+	ctx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx),
+		readinessCheckTimeout,
+	)
+	defer cancel()
+	return checkFn(ctx)
+}
@@ -0,0 +1,199 @@
+package badges
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReadinessHandler(t *testing.T) {
+	testConfig := ReadinessConfig{
+		CheckGitHub: true,
+		CacheTTL:    5 * time.Second,
+	}
+	testCache := &mockCache{}
+	testService := &mockService{}
+	testBreaker := NewCircuitBreaker(CircuitBreakerConfig{})
+	r, ok := NewReadinessHandler(
+		testConfig,
+		testCache,
+		testService,
+		testBreaker,
+	).(*readiness)
+	require.True(t, ok)
+	require.Equal(t, testConfig, r.config)
+	require.Same(t, testCache, r.cache)
+	require.Same(t, testService, r.service)
+	require.Same(t, testBreaker, r.breaker)
+	require.NotNil(t, r.nowFn)
+}
+
+func TestReadinessServeHTTP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		readiness  *readiness
+		assertions func(*httptest.ResponseRecorder)
+	}{
+		{
+			name: "redis unreachable",
+			readiness: &readiness{
+				cache: &mockCache{
+					PingFn: func(context.Context) error {
+						return errors.New("something went wrong")
+					},
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+				report := ReadinessReport{}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				require.Equal(t, readinessStatusNotReady, report.Status)
+				require.Equal(
+					t,
+					map[string]DependencyStatus{
+						dependencyRedis: {
+							Status: readinessStatusError,
+							Error:  "something went wrong",
+						},
+					},
+					report.Dependencies,
+				)
+			},
+		},
+		{
+			name: "github not checked",
+			readiness: &readiness{
+				cache: &mockCache{
+					PingFn: func(context.Context) error {
+						return nil
+					},
+				},
+				service: &mockService{
+					PingFn: func(context.Context) error {
+						require.Fail(t, "github should not have been checked")
+						return nil
+					},
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, rr.Code)
+				report := ReadinessReport{}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				require.Equal(t, readinessStatusReady, report.Status)
+				require.Equal(
+					t,
+					map[string]DependencyStatus{
+						dependencyRedis: {Status: readinessStatusOK},
+					},
+					report.Dependencies,
+				)
+			},
+		},
+		{
+			name: "github unreachable",
+			readiness: &readiness{
+				config: ReadinessConfig{CheckGitHub: true},
+				cache: &mockCache{
+					PingFn: func(context.Context) error {
+						return nil
+					},
+				},
+				service: &mockService{
+					PingFn: func(context.Context) error {
+						return errors.New("something went wrong")
+					},
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+				report := ReadinessReport{}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				require.Equal(t, readinessStatusNotReady, report.Status)
+				require.Equal(
+					t,
+					map[string]DependencyStatus{
+						dependencyRedis: {Status: readinessStatusOK},
+						dependencyGitHub: {
+							Status: readinessStatusError,
+							Error:  "something went wrong",
+						},
+					},
+					report.Dependencies,
+				)
+			},
+		},
+		{
+			name: "all dependencies reachable",
+			readiness: &readiness{
+				config: ReadinessConfig{CheckGitHub: true},
+				cache: &mockCache{
+					PingFn: func(context.Context) error {
+						return nil
+					},
+				},
+				service: &mockService{
+					PingFn: func(context.Context) error {
+						return nil
+					},
+				},
+			},
+			assertions: func(rr *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, rr.Code)
+				require.Equal(
+					t,
+					"application/json",
+					rr.Header().Get("Content-Type"),
+				)
+				report := ReadinessReport{}
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				require.Equal(t, readinessStatusReady, report.Status)
+				require.Len(t, report.Dependencies, 2)
+				require.Equal(t, CircuitStateClosed, report.GitHubCircuitBreaker)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.readiness.breaker = NewCircuitBreaker(CircuitBreakerConfig{})
+			testCase.readiness.nowFn = time.Now
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			rr := httptest.NewRecorder()
+			testCase.readiness.ServeHTTP(rr, req)
+			testCase.assertions(rr)
+		})
+	}
+}
+
+func TestReadinessCheckCaching(t *testing.T) {
+	now := time.Now()
+	var pings int
+	r := &readiness{
+		config:  ReadinessConfig{CacheTTL: 5 * time.Second},
+		breaker: NewCircuitBreaker(CircuitBreakerConfig{}),
+		cache: &mockCache{
+			PingFn: func(context.Context) error {
+				pings++
+				return nil
+			},
+		},
+		nowFn: func() time.Time {
+			return now
+		},
+	}
+	r.check(context.Background())
+	require.Equal(t, 1, pings)
+	// A recent result should be reused
+	now = now.Add(4 * time.Second)
+	r.check(context.Background())
+	require.Equal(t, 1, pings)
+	// A stale result should not
+	now = now.Add(time.Second)
+	r.check(context.Background())
+	require.Equal(t, 2, pings)
+}
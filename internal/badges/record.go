@@ -0,0 +1,110 @@
+package badges
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// badgeRecordVersion is the current version of the BadgeRecord layout. It
+// should be incremented whenever the layout changes in a way that requires
+// records of an older version to be migrated when they are read.
+const badgeRecordVersion = 1
+
+// BadgeRecord is a serializable representation of a badge along with
+// information about where it came from. Badge records, and not rendered
+// badges, are what get cached. This permits a cached result to be rendered
+// in any manner at the time it is served.
+type BadgeRecord struct {
+	// Version is the version of the record's layout.
+	Version int `json:"version"`
+	// Name is the label text that should appear on the left of the badge.
+	Name string `json:"name"`
+	// Status is the status of the check suite(s) the badge represents. It is
+	// ignored if Error is non-empty.
+	Status CheckStatus `json:"status"`
+	// Error, if non-empty, indicates that the record represents an ErrBadge
+	// and is the badge's status text.
+	Error string `json:"error,omitempty"`
+	// FetchedAt is the time at which the result was obtained. This is zero for
+	// records migrated from older versions of Badgr.
+	FetchedAt time.Time `json:"fetchedAt"`
+	// Pages summarizes each page of check suites the badge was derived from,
+	// including the ETag GitHub returned for each.
+	Pages []CheckSuitePage `json:"pages,omitempty"`
+}
+
+// NewBadgeRecord returns a BadgeRecord representing the provided Badge, fetched
+// at the specified time.
+func NewBadgeRecord(badge Badge, fetchedAt time.Time) BadgeRecord {
+	record := BadgeRecord{
+		Version:   badgeRecordVersion,
+		Name:      badge.Name(),
+		FetchedAt: fetchedAt,
+	}
+	switch b := badge.(type) {
+	case CheckBadge:
+		record.Status = b.status
+		record.Pages = b.pages
+	case ErrBadge:
+		record.Error = b.status
+	}
+	return record
+}
+
+// Badge returns the Badge represented by the record.
+func (b BadgeRecord) Badge() Badge {
+	if b.Error != "" {
+		return ErrBadge{status: b.Error}
+	}
+	return CheckBadge{
+		name:   b.Name,
+		status: b.Status,
+		pages:  b.Pages,
+	}
+}
+
+// ParseBadgeRecord parses a serialized BadgeRecord. Cache entries written by
+// Badgr 1.x, which cached rendered badge URLs instead of records, are
+// transparently migrated.
+func ParseBadgeRecord(value string) (BadgeRecord, error) {
+	// Badgr 1.x cached nothing but the rendered badge URL
+	if !strings.HasPrefix(value, "{") {
+		return badgeRecordFromURL(value)
+	}
+	record := BadgeRecord{}
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return BadgeRecord{}, errors.Wrap(err, "error unmarshaling badge record")
+	}
+	if record.Version != badgeRecordVersion {
+		return BadgeRecord{},
+			errors.Errorf("unsupported badge record version %d", record.Version)
+	}
+	return record, nil
+}
+
+// badgeRecordFromURL reconstructs a BadgeRecord from a rendered badge URL.
+func badgeRecordFromURL(badgeURL string) (BadgeRecord, error) {
+	u, err := url.Parse(badgeURL)
+	if err != nil {
+		return BadgeRecord{},
+			errors.Wrapf(err, "error parsing legacy badge URL %q", badgeURL)
+	}
+	record := BadgeRecord{
+		Version: badgeRecordVersion,
+		Name:    u.Query().Get("label"),
+	}
+	message := u.Query().Get("message")
+	if record.Name == (ErrBadge{}).Name() {
+		record.Error = message
+		return record, nil
+	}
+	if err = record.Status.UnmarshalText([]byte(message)); err != nil {
+		return BadgeRecord{},
+			errors.Wrapf(err, "error parsing legacy badge URL %q", badgeURL)
+	}
+	return record, nil
+}
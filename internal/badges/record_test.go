@@ -0,0 +1,162 @@
+package badges
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBadgeRecord(t *testing.T) {
+	testFetchedAt := time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name           string
+		badge          Badge
+		expectedRecord BadgeRecord
+	}{
+		{
+			name: "check badge",
+			badge: CheckBadge{
+				name:   "build",
+				status: CheckStatusFailed,
+				pages: []CheckSuitePage{
+					{
+						ETag:   "etag",
+						Count:  1,
+						Status: CheckStatusFailed,
+					},
+				},
+			},
+			expectedRecord: BadgeRecord{
+				Version:   badgeRecordVersion,
+				Name:      "build",
+				Status:    CheckStatusFailed,
+				FetchedAt: testFetchedAt,
+				Pages: []CheckSuitePage{
+					{
+						ETag:   "etag",
+						Count:  1,
+						Status: CheckStatusFailed,
+					},
+				},
+			},
+		},
+		{
+			name:  "error badge",
+			badge: NewErrBadge("repo not found"),
+			expectedRecord: BadgeRecord{
+				Version:   badgeRecordVersion,
+				Name:      "error",
+				Error:     "repo not found",
+				FetchedAt: testFetchedAt,
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			record := NewBadgeRecord(testCase.badge, testFetchedAt)
+			require.Equal(t, testCase.expectedRecord, record)
+			// Round trip
+			require.Equal(t, testCase.badge, record.Badge())
+		})
+	}
+}
+
+func TestParseBadgeRecord(t *testing.T) {
+	testRecord := BadgeRecord{
+		Version:   badgeRecordVersion,
+		Name:      "build",
+		Status:    CheckStatusInProgress,
+		FetchedAt: time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC),
+		Pages: []CheckSuitePage{
+			{
+				ETag:   "etag",
+				Count:  1,
+				Status: CheckStatusInProgress,
+			},
+		},
+	}
+	testRecordJSON, err := json.Marshal(testRecord)
+	require.NoError(t, err)
+	testCases := []struct {
+		name       string
+		value      string
+		assertions func(BadgeRecord, error)
+	}{
+		{
+			name:  "invalid JSON",
+			value: "{",
+			assertions: func(_ BadgeRecord, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error unmarshaling badge record")
+			},
+		},
+		{
+			name:  "current version",
+			value: string(testRecordJSON),
+			assertions: func(record BadgeRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(t, testRecord, record)
+			},
+		},
+		{
+			name:  "legacy URL; unrecognized status",
+			value: "https://img.shields.io/static/v1?label=build&message=bogus",
+			assertions: func(_ BadgeRecord, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing legacy badge URL")
+			},
+		},
+		{
+			name: "legacy URL; check badge",
+			value: badgeURL(
+				CheckBadge{
+					name:   "build",
+					status: CheckStatusInProgress,
+				},
+			),
+			assertions: func(record BadgeRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					BadgeRecord{
+						Version: badgeRecordVersion,
+						Name:    "build",
+						Status:  CheckStatusInProgress,
+					},
+					record,
+				)
+			},
+		},
+		{
+			name:  "legacy URL; error badge",
+			value: badgeURL(NewErrBadge(500)),
+			assertions: func(record BadgeRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					BadgeRecord{
+						Version: badgeRecordVersion,
+						Name:    "error",
+						Error:   "500",
+					},
+					record,
+				)
+			},
+		},
+		{
+			name:  "unsupported version",
+			value: `{"version":0,"name":"build"}`,
+			assertions: func(_ BadgeRecord, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unsupported badge record version")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(ParseBadgeRecord(testCase.value))
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// createAPIKeyScript atomically claims an API key's name in the index of names
+// and stores the key under the hash of its token. It returns 0 if the name was
+// already taken and 1 otherwise.
+var createAPIKeyScript = redis.NewScript(`
+if redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[3])
+return 1
+`)
+
+// revokeAPIKeyScript atomically removes an API key's name from the index of
+// names and deletes the key stored under the hash of its token, provided the
+// name still maps to that hash. Since a script may only touch keys it is
+// given, the hash must be looked up beforehand. It returns 0 if the name does
+// not map to the hash and 1 otherwise.
+var revokeAPIKeyScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+type apiKeyStore struct {
+	redisClient *redis.Client
+	prefix      string
+	// The following internal functions are overridable for testing purposes
+	getFn    func(ctx context.Context, key string) ([]byte, error)
+	createFn func(
+		ctx context.Context,
+		indexKey string,
+		key string,
+		name string,
+		hash string,
+		value []byte,
+	) (bool, error)
+	revokeFn func(
+		ctx context.Context,
+		indexKey string,
+		keyPrefix string,
+		name string,
+	) (bool, error)
+}
+
+// NewAPIKeyStore returns a new Redis-based implementation of the
+// badges.APIKeyStore interface. Each key is stored as JSON under the hash of
+// its token, and a hash maps the names of keys to the hashes of their tokens so
+// that keys can be revoked by name.
+func NewAPIKeyStore(config CacheConfig) badges.APIKeyStore {
+	a := &apiKeyStore{
+		redisClient: newRedisClient(config),
+		prefix:      config.RedisPrefix,
+	}
+	a.getFn = a.get
+	a.createFn = a.create
+	a.revokeFn = a.revoke
+	return a
+}
+
+func (a *apiKeyStore) Create(
+	ctx context.Context,
+	hash string,
+	key badges.APIKey,
+) (err error) {
+	ctx, span := a.startSpan(ctx, "redis.apiKeyStore.Create")
+	defer func() { endSpan(span, err) }()
+	value, err := json.Marshal(key)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling API key %q", key.Name)
+	}
+	created, err := a.createFn(
+		ctx,
+		a.getKey("apikeys"),
+		a.getKey("apikey:"+hash),
+		key.Name,
+		hash,
+		value,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error creating API key %q", key.Name)
+	}
+	if !created {
+		return errors.Wrapf(badges.ErrAPIKeyExists, "API key %q", key.Name)
+	}
+	return nil
+}
+
+func (a *apiKeyStore) Get(
+	ctx context.Context,
+	hash string,
+) (_ *badges.APIKey, err error) {
+	ctx, span := a.startSpan(ctx, "redis.apiKeyStore.Get")
+	defer func() { endSpan(span, err) }()
+	value, err := a.getFn(ctx, a.getKey("apikey:"+hash))
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving API key")
+	}
+	if value == nil {
+		return nil, nil
+	}
+	key := &badges.APIKey{}
+	if err = json.Unmarshal(value, key); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling API key")
+	}
+	return key, nil
+}
+
+func (a *apiKeyStore) Revoke(ctx context.Context, name string) (err error) {
+	ctx, span := a.startSpan(ctx, "redis.apiKeyStore.Revoke")
+	defer func() { endSpan(span, err) }()
+	revoked, err := a.revokeFn(
+		ctx,
+		a.getKey("apikeys"),
+		a.getKey("apikey:"),
+		name,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error revoking API key %q", name)
+	}
+	if !revoked {
+		return errors.Wrapf(badges.ErrAPIKeyNotFound, "API key %q", name)
+	}
+	return nil
+}
+
+func (a *apiKeyStore) startSpan(
+	ctx context.Context,
+	name string,
+) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+}
+
+func (a *apiKeyStore) getKey(key string) string {
+	if a.prefix == "" {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", a.prefix, key)
+}
+
+func (a *apiKeyStore) get(ctx context.Context, key string) ([]byte, error) {
+	value, err := a.redisClient.WithContext(ctx).Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (a *apiKeyStore) create(
+	ctx context.Context,
+	indexKey string,
+	key string,
+	name string,
+	hash string,
+	value []byte,
+) (bool, error) {
+	created, err := createAPIKeyScript.Run(
+		a.redisClient.WithContext(ctx),
+		[]string{indexKey, key},
+		name,
+		hash,
+		value,
+	).Int64()
+	return created == 1, err
+}
+
+func (a *apiKeyStore) revoke(
+	ctx context.Context,
+	indexKey string,
+	keyPrefix string,
+	name string,
+) (bool, error) {
+	client := a.redisClient.WithContext(ctx)
+	hash, err := client.HGet(indexKey, name).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	revoked, err := revokeAPIKeyScript.Run(
+		client,
+		[]string{indexKey, keyPrefix + hash},
+		name,
+		hash,
+	).Int64()
+	return revoked == 1, err
+}
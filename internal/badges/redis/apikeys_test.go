@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIKeyStore(t *testing.T) {
+	const testPrefix = "foo"
+	a, ok := NewAPIKeyStore(CacheConfig{RedisPrefix: testPrefix}).(*apiKeyStore)
+	require.True(t, ok)
+	require.Equal(t, testPrefix, a.prefix)
+	require.NotNil(t, a.redisClient)
+	require.NotNil(t, a.getFn)
+	require.NotNil(t, a.createFn)
+	require.NotNil(t, a.revokeFn)
+}
+
+func TestAPIKeyStoreCreate(t *testing.T) {
+	testKey := badges.APIKey{Name: "ci", Scopes: []string{"brigadecore/*"}}
+	testCases := []struct {
+		name     string
+		createFn func(
+			ctx context.Context,
+			indexKey string,
+			key string,
+			name string,
+			hash string,
+			value []byte,
+		) (bool, error)
+		assertions func(error)
+	}{
+		{
+			name: "error running script",
+			createFn: func(
+				context.Context,
+				string,
+				string,
+				string,
+				string,
+				[]byte,
+			) (bool, error) {
+				return false, errors.New("something went wrong")
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error creating API key")
+			},
+		},
+		{
+			name: "name taken",
+			createFn: func(
+				context.Context,
+				string,
+				string,
+				string,
+				string,
+				[]byte,
+			) (bool, error) {
+				return false, nil
+			},
+			assertions: func(err error) {
+				require.ErrorIs(t, err, badges.ErrAPIKeyExists)
+			},
+		},
+		{
+			name: "success",
+			createFn: func(
+				_ context.Context,
+				indexKey string,
+				key string,
+				name string,
+				hash string,
+				value []byte,
+			) (bool, error) {
+				require.Equal(t, "foo:apikeys", indexKey)
+				require.Equal(t, "foo:apikey:abc", key)
+				require.Equal(t, "ci", name)
+				require.Equal(t, "abc", hash)
+				require.JSONEq(
+					t,
+					`{"name":"ci","scopes":["brigadecore/*"]}`,
+					string(value),
+				)
+				return true, nil
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a := &apiKeyStore{
+				prefix:   "foo",
+				createFn: testCase.createFn,
+			}
+			testCase.assertions(a.Create(context.Background(), "abc", testKey))
+		})
+	}
+}
+
+func TestAPIKeyStoreGet(t *testing.T) {
+	testCases := []struct {
+		name       string
+		getFn      func(context.Context, string) ([]byte, error)
+		assertions func(*badges.APIKey, error)
+	}{
+		{
+			name: "error retrieving key",
+			getFn: func(context.Context, string) ([]byte, error) {
+				return nil, errors.New("something went wrong")
+			},
+			assertions: func(_ *badges.APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error retrieving API key")
+			},
+		},
+		{
+			name: "key not found",
+			getFn: func(context.Context, string) ([]byte, error) {
+				return nil, nil
+			},
+			assertions: func(key *badges.APIKey, err error) {
+				require.NoError(t, err)
+				require.Nil(t, key)
+			},
+		},
+		{
+			name: "error unmarshaling key",
+			getFn: func(context.Context, string) ([]byte, error) {
+				return []byte("{"), nil
+			},
+			assertions: func(_ *badges.APIKey, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error unmarshaling API key")
+			},
+		},
+		{
+			name: "key found",
+			getFn: func(_ context.Context, key string) ([]byte, error) {
+				require.Equal(t, "foo:apikey:abc", key)
+				return []byte(`{"name":"ci","scopes":["brigadecore/*"]}`), nil
+			},
+			assertions: func(key *badges.APIKey, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					&badges.APIKey{Name: "ci", Scopes: []string{"brigadecore/*"}},
+					key,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a := &apiKeyStore{
+				prefix: "foo",
+				getFn:  testCase.getFn,
+			}
+			testCase.assertions(a.Get(context.Background(), "abc"))
+		})
+	}
+}
+
+func TestAPIKeyStoreRevoke(t *testing.T) {
+	testCases := []struct {
+		name       string
+		revokeFn   func(context.Context, string, string, string) (bool, error)
+		assertions func(error)
+	}{
+		{
+			name: "error running script",
+			revokeFn: func(context.Context, string, string, string) (bool, error) {
+				return false, errors.New("something went wrong")
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error revoking API key")
+			},
+		},
+		{
+			name: "key not found",
+			revokeFn: func(context.Context, string, string, string) (bool, error) {
+				return false, nil
+			},
+			assertions: func(err error) {
+				require.ErrorIs(t, err, badges.ErrAPIKeyNotFound)
+			},
+		},
+		{
+			name: "success",
+			revokeFn: func(
+				_ context.Context,
+				indexKey string,
+				keyPrefix string,
+				name string,
+			) (bool, error) {
+				require.Equal(t, "foo:apikeys", indexKey)
+				require.Equal(t, "foo:apikey:", keyPrefix)
+				require.Equal(t, "ci", name)
+				return true, nil
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a := &apiKeyStore{
+				prefix:   "foo",
+				revokeFn: testCase.revokeFn,
+			}
+			testCase.assertions(a.Revoke(context.Background(), "ci"))
+		})
+	}
+}
@@ -1,13 +1,19 @@
 package redis
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/brigadecore/badgr/internal/badges"
 	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -15,6 +21,8 @@ const (
 	tempWarm = "warm"
 )
 
+var tracer = otel.Tracer("github.com/brigadecore/badgr/internal/badges/redis")
+
 // CacheConfig represents configuration options for the Redis-based
 // implementation of the badges.Cache interface
 type CacheConfig struct {
@@ -24,19 +32,45 @@ type CacheConfig struct {
 	RedisDB        int
 	RedisEnableTLS bool
 	RedisPrefix    string
+	// WarmTTL is how long a fresh result is served from the warm cache before
+	// GitHub is queried again.
+	WarmTTL time.Duration
+	// ColdTTL is how long a result is retained in the cold cache as a fallback
+	// for when GitHub cannot be queried.
+	ColdTTL time.Duration
 }
 
 type cache struct {
 	redisClient *redis.Client
 	prefix      string
+	warmTTL     time.Duration
+	coldTTL     time.Duration
 	// The following internal functions are overridable for testing purposes
-	getFn func(key string) (string, error)
-	setFn func(key, value string, ttl time.Duration) error
+	getFn  func(ctx context.Context, key string) (string, error)
+	setFn  func(ctx context.Context, key, value string, ttl time.Duration) error
+	ttlFn  func(ctx context.Context, key string) (time.Duration, error)
+	pingFn func(ctx context.Context) error
 }
 
 // NewCache returns a new Redis-based implementation of the badges.Cache
 // interface.
 func NewCache(config CacheConfig) badges.Cache {
+	cache := &cache{
+		redisClient: newRedisClient(config),
+		prefix:      config.RedisPrefix,
+		warmTTL:     config.WarmTTL,
+		coldTTL:     config.ColdTTL,
+	}
+	cache.getFn = cache.get
+	cache.setFn = cache.set
+	cache.ttlFn = cache.ttl
+	cache.pingFn = cache.ping
+	return cache
+}
+
+// newRedisClient returns a new Redis client configured using the provided
+// CacheConfig.
+func newRedisClient(config CacheConfig) *redis.Client {
 	redisOpts := &redis.Options{
 		Addr:       fmt.Sprintf("%s:%d", config.RedisHost, config.RedisPort),
 		Password:   config.RedisPassword,
@@ -49,18 +83,22 @@ func NewCache(config CacheConfig) badges.Cache {
 			ServerName: config.RedisHost,
 		}
 	}
-	cache := &cache{
-		redisClient: redis.NewClient(redisOpts),
-		prefix:      config.RedisPrefix,
-	}
-	cache.getFn = cache.get
-	cache.setFn = cache.set
-	return cache
+	return redis.NewClient(redisOpts)
 }
 
-func (c *cache) Set(key, value string) error {
+func (c *cache) Set(
+	ctx context.Context,
+	key string,
+	record badges.BadgeRecord,
+) (err error) {
+	ctx, span := startSpan(ctx, "Set", key)
+	defer func() { endSpan(span, err) }()
+	value, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling result for key %q", key)
+	}
 	warmKey := c.getKey(key, true)
-	if err := c.setFn(warmKey, value, time.Minute); err != nil {
+	if err = c.setFn(ctx, warmKey, string(value), c.warmTTL); err != nil {
 		return errors.Wrapf(
 			err,
 			"error writing result for %s to warm cache",
@@ -68,7 +106,7 @@ func (c *cache) Set(key, value string) error {
 		)
 	}
 	coldKey := c.getKey(key, false)
-	if err := c.setFn(coldKey, value, 24*time.Hour); err != nil {
+	if err = c.setFn(ctx, coldKey, string(value), c.coldTTL); err != nil {
 		return errors.Wrapf(
 			err,
 			"error writing result for key %q to cold cache",
@@ -78,32 +116,110 @@ func (c *cache) Set(key, value string) error {
 	return nil
 }
 
-func (c *cache) GetWarm(key string) (string, error) {
-	return c.getInternal(key, true)
+func (c *cache) SetWarm(
+	ctx context.Context,
+	key string,
+	record badges.BadgeRecord,
+	ttl time.Duration,
+) (err error) {
+	ctx, span := startSpan(ctx, "SetWarm", key)
+	defer func() { endSpan(span, err) }()
+	value, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling result for key %q", key)
+	}
+	if err = c.setFn(ctx, c.getKey(key, true), string(value), ttl); err != nil {
+		return errors.Wrapf(
+			err,
+			"error writing result for key %q to warm cache",
+			key,
+		)
+	}
+	return nil
+}
+
+func (c *cache) GetWarm(
+	ctx context.Context,
+	key string,
+) (*badges.BadgeRecord, error) {
+	return c.getInternal(ctx, key, true)
+}
+
+func (c *cache) GetCold(
+	ctx context.Context,
+	key string,
+) (*badges.BadgeRecord, error) {
+	return c.getInternal(ctx, key, false)
+}
+
+func (c *cache) WarmTTL(
+	ctx context.Context,
+	key string,
+) (_ time.Duration, err error) {
+	ctx, span := startSpan(ctx, "WarmTTL", key)
+	defer func() { endSpan(span, err) }()
+	ttl, err := c.ttlFn(ctx, c.getKey(key, true))
+	if err != nil {
+		return 0, errors.Wrapf(
+			err,
+			"error retrieving TTL for key %q from warm cache",
+			key,
+		)
+	}
+	// Redis reports a negative TTL for keys that do not exist
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
 }
 
-func (c *cache) GetCold(key string) (string, error) {
-	return c.getInternal(key, false)
+func (c *cache) Ping(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"redis.cache.Ping",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+	defer func() { endSpan(span, err) }()
+	return errors.Wrap(c.pingFn(ctx), "error pinging Redis")
 }
 
-func (c *cache) getInternal(key string, warm bool) (string, error) {
+func (c *cache) getInternal(
+	ctx context.Context,
+	key string,
+	warm bool,
+) (_ *badges.BadgeRecord, err error) {
 	temp := tempCold
 	if warm {
 		temp = tempWarm
 	}
+	ctx, span := startSpan(ctx, "Get", key)
+	span.SetAttributes(attribute.String("badgr.cache.layer", temp))
+	defer func() { endSpan(span, err) }()
 	key = c.getKey(key, warm)
-	value, err := c.getFn(key)
+	value, err := c.getFn(ctx, key)
 	if err == redis.Nil {
-		return "", nil // This isn't an error; it's just a cache miss
+		span.SetAttributes(attribute.Bool("badgr.cache.hit", false))
+		return nil, nil // This isn't an error; it's just a cache miss
 	} else if err != nil {
-		return "", errors.Wrapf(
+		return nil, errors.Wrapf(
 			err,
 			"error retrieving result for key %q from %s cache",
 			key,
 			temp,
 		)
 	}
-	return value, nil
+	span.SetAttributes(attribute.Bool("badgr.cache.hit", true))
+	record, err := badges.ParseBadgeRecord(value)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error parsing result for key %q from %s cache",
+			key,
+			temp,
+		)
+	}
+	return &record, nil
 }
 
 func (c *cache) getKey(key string, warm bool) string {
@@ -118,11 +234,50 @@ func (c *cache) getKey(key string, warm bool) string {
 	return fmt.Sprintf("%s:%s", c.prefix, key)
 }
 
-func (c *cache) get(key string) (string, error) {
-	strCmd := c.redisClient.Get(key)
+func (c *cache) get(ctx context.Context, key string) (string, error) {
+	strCmd := c.redisClient.WithContext(ctx).Get(key)
 	return strCmd.Val(), strCmd.Err()
 }
 
-func (c *cache) set(key, value string, ttl time.Duration) error {
-	return c.redisClient.Set(key, value, ttl).Err()
+func (c *cache) set(
+	ctx context.Context,
+	key string,
+	value string,
+	ttl time.Duration,
+) error {
+	return c.redisClient.WithContext(ctx).Set(key, value, ttl).Err()
+}
+
+func (c *cache) ttl(ctx context.Context, key string) (time.Duration, error) {
+	return c.redisClient.WithContext(ctx).TTL(key).Result()
+}
+
+func (c *cache) ping(ctx context.Context) error {
+	return c.redisClient.WithContext(ctx).Ping().Err()
+}
+
+// startSpan starts a span for a cache operation.
+func startSpan(
+	ctx context.Context,
+	operation string,
+	key string,
+) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		"redis.cache."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("badgr.cache.key", key),
+		),
+	)
+}
+
+// endSpan ends a span for a cache operation, recording the error, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
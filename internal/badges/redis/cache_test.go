@@ -1,11 +1,14 @@
 package redis
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/brigadecore/badgr/internal/badges"
 	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/require"
 )
@@ -23,11 +26,106 @@ func TestNewCache(t *testing.T) {
 	require.NotNil(t, cache.redisClient)
 	require.NotNil(t, cache.getFn)
 	require.NotNil(t, cache.setFn)
+	require.NotNil(t, cache.ttlFn)
+	require.NotNil(t, cache.pingFn)
+}
+
+func TestPing(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cache      *cache
+		assertions func(error)
+	}{
+		{
+			name: "error pinging redis",
+			cache: &cache{
+				pingFn: func(context.Context) error {
+					return errors.New("something went wrong")
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error pinging Redis")
+			},
+		},
+		{
+			name: "success",
+			cache: &cache{
+				pingFn: func(context.Context) error {
+					return nil
+				},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(testCase.cache.Ping(context.Background()))
+		})
+	}
+}
+
+func TestWarmTTL(t *testing.T) {
+	const testKey = "key"
+	testCases := []struct {
+		name       string
+		cache      *cache
+		assertions func(time.Duration, error)
+	}{
+		{
+			name: "error retrieving TTL",
+			cache: &cache{
+				ttlFn: func(context.Context, string) (time.Duration, error) {
+					return 0, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ time.Duration, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error retrieving TTL for key")
+			},
+		},
+		{
+			name: "key does not exist",
+			cache: &cache{
+				ttlFn: func(context.Context, string) (time.Duration, error) {
+					return -2, nil
+				},
+			},
+			assertions: func(ttl time.Duration, err error) {
+				require.NoError(t, err)
+				require.Zero(t, ttl)
+			},
+		},
+		{
+			name: "success",
+			cache: &cache{
+				ttlFn: func(_ context.Context, key string) (time.Duration, error) {
+					require.Equal(t, "warm:key", key)
+					return 30 * time.Second, nil
+				},
+			},
+			assertions: func(ttl time.Duration, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 30*time.Second, ttl)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				testCase.cache.WarmTTL(context.Background(), testKey),
+			)
+		})
+	}
 }
 
 func TestSet(t *testing.T) {
 	const testKey = "key"
-	const testValue = "value"
+	testRecord := badges.BadgeRecord{Name: "build"}
 	testCases := []struct {
 		name       string
 		cache      *cache
@@ -36,7 +134,7 @@ func TestSet(t *testing.T) {
 		{
 			name: "error writing to warm cache",
 			cache: &cache{
-				setFn: func(string, string, time.Duration) error {
+				setFn: func(context.Context, string, string, time.Duration) error {
 					return errors.New("something went wrong")
 				},
 			},
@@ -50,7 +148,12 @@ func TestSet(t *testing.T) {
 		{
 			name: "error writing to cold cache",
 			cache: &cache{
-				setFn: func(key string, _ string, _ time.Duration) error {
+				setFn: func(
+					_ context.Context,
+					key string,
+					_ string,
+					_ time.Duration,
+				) error {
 					if strings.Contains(key, "cold") {
 						return errors.New("something went wrong")
 					}
@@ -67,7 +170,58 @@ func TestSet(t *testing.T) {
 		{
 			name: "success",
 			cache: &cache{
-				setFn: func(string, string, time.Duration) error {
+				setFn: func(context.Context, string, string, time.Duration) error {
+					return nil
+				},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				testCase.cache.Set(context.Background(), testKey, testRecord),
+			)
+		})
+	}
+}
+
+func TestSetWarm(t *testing.T) {
+	const testKey = "key"
+	testRecord := badges.BadgeRecord{Name: "build"}
+	const testTTL = 5 * time.Minute
+	testCases := []struct {
+		name       string
+		cache      *cache
+		assertions func(error)
+	}{
+		{
+			name: "error writing to warm cache",
+			cache: &cache{
+				setFn: func(context.Context, string, string, time.Duration) error {
+					return errors.New("something went wrong")
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error writing result for key")
+				require.Contains(t, err.Error(), "to warm cache")
+			},
+		},
+		{
+			name: "success",
+			cache: &cache{
+				setFn: func(
+					_ context.Context,
+					key string,
+					_ string,
+					ttl time.Duration,
+				) error {
+					require.Contains(t, key, "warm")
+					require.Equal(t, testTTL, ttl)
 					return nil
 				},
 			},
@@ -78,27 +232,46 @@ func TestSet(t *testing.T) {
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			testCase.assertions(testCase.cache.Set(testKey, testValue))
+			testCase.assertions(
+				testCase.cache.SetWarm(
+					context.Background(),
+					testKey,
+					testRecord,
+					testTTL,
+				),
+			)
 		})
 	}
 }
 
 func TestGet(t *testing.T) {
 	const testKey = "key"
-	const testValue = "value"
+	testRecord := &badges.BadgeRecord{
+		Version:   1,
+		Name:      "build",
+		Status:    badges.CheckStatusPassed,
+		FetchedAt: time.Date(2021, time.October, 1, 12, 0, 0, 0, time.UTC),
+		Pages: []badges.CheckSuitePage{
+			{
+				ETag:   "etag",
+				Count:  1,
+				Status: badges.CheckStatusPassed,
+			},
+		},
+	}
 	testCases := []struct {
 		name       string
 		cache      *cache
-		assertions func(string, error)
+		assertions func(*badges.BadgeRecord, error)
 	}{
 		{
 			name: "error reading from cache",
 			cache: &cache{
-				getFn: func(key string) (string, error) {
+				getFn: func(context.Context, string) (string, error) {
 					return "", errors.New("something went wrong")
 				},
 			},
-			assertions: func(_ string, err error) {
+			assertions: func(_ *badges.BadgeRecord, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "something went wrong")
 				require.Contains(t, err.Error(), "error retrieving result for key")
@@ -108,31 +281,46 @@ func TestGet(t *testing.T) {
 		{
 			name: "cache miss",
 			cache: &cache{
-				getFn: func(key string) (string, error) {
+				getFn: func(context.Context, string) (string, error) {
 					return "", redis.Nil
 				},
 			},
-			assertions: func(value string, err error) {
+			assertions: func(record *badges.BadgeRecord, err error) {
 				require.NoError(t, err)
-				require.Empty(t, value)
+				require.Nil(t, record)
+			},
+		},
+		{
+			name: "cache hit; unparsable result",
+			cache: &cache{
+				getFn: func(context.Context, string) (string, error) {
+					return "{", nil
+				},
+			},
+			assertions: func(_ *badges.BadgeRecord, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing result for key")
 			},
 		},
 		{
 			name: "cache hit",
 			cache: &cache{
-				getFn: func(key string) (string, error) {
-					return testValue, nil
+				getFn: func(context.Context, string) (string, error) {
+					value, err := json.Marshal(testRecord)
+					return string(value), err
 				},
 			},
-			assertions: func(value string, err error) {
+			assertions: func(record *badges.BadgeRecord, err error) {
 				require.NoError(t, err)
-				require.Equal(t, testValue, value)
+				require.Equal(t, testRecord, record)
 			},
 		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			testCase.assertions(testCase.cache.getInternal(testKey, true))
+			testCase.assertions(
+				testCase.cache.getInternal(context.Background(), testKey, true),
+			)
 		})
 	}
 }
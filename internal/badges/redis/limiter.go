@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tokenBucketScript atomically refills a token bucket according to the time
+// elapsed since it was last touched and then takes a token from it, if one is
+// available. It returns 1 if a token was taken and 0 otherwise. Buckets expire
+// once they would have refilled completely, since a full bucket and a missing
+// one are equivalent.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call(
+	"HMSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now)
+)
+redis.call("EXPIRE", KEYS[1], ttl)
+return allowed
+`)
+
+type limiter struct {
+	redisClient *redis.Client
+	prefix      string
+	nowFn       func() time.Time
+	// allowFn is overridable for testing purposes
+	allowFn func(
+		ctx context.Context,
+		key string,
+		limit badges.ClientLimit,
+		now time.Time,
+	) (bool, error)
+}
+
+// NewLimiter returns a new Redis-based implementation of the badges.Limiter
+// interface. Because token buckets are stored in Redis, limits hold across all
+// replicas of Badgr.
+func NewLimiter(config CacheConfig) badges.Limiter {
+	l := &limiter{
+		redisClient: newRedisClient(config),
+		prefix:      config.RedisPrefix,
+		nowFn:       time.Now,
+	}
+	l.allowFn = l.allow
+	return l
+}
+
+func (l *limiter) Allow(
+	ctx context.Context,
+	key string,
+	limit badges.ClientLimit,
+) (_ bool, err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"redis.limiter.Allow",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+	defer func() { endSpan(span, err) }()
+	allowed, err := l.allowFn(ctx, l.getKey(key), limit, l.nowFn())
+	if err != nil {
+		return false, errors.Wrapf(
+			err,
+			"error taking token from bucket for key %q",
+			key,
+		)
+	}
+	span.SetAttributes(attribute.Bool("badgr.rate_limit.allowed", allowed))
+	return allowed, nil
+}
+
+func (l *limiter) getKey(key string) string {
+	key = fmt.Sprintf("ratelimit:%s", key)
+	if l.prefix == "" {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", l.prefix, key)
+}
+
+func (l *limiter) allow(
+	ctx context.Context,
+	key string,
+	limit badges.ClientLimit,
+	now time.Time,
+) (bool, error) {
+	ttl := int64(math.Ceil(float64(limit.Burst)/limit.Rate)) + 1
+	allowed, err := tokenBucketScript.Run(
+		l.redisClient.WithContext(ctx),
+		[]string{key},
+		limit.Rate,
+		limit.Burst,
+		float64(now.UnixNano())/float64(time.Second),
+		ttl,
+	).Int64()
+	return allowed == 1, err
+}
@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter(t *testing.T) {
+	const testPrefix = "foo"
+	l, ok := NewLimiter(CacheConfig{RedisPrefix: testPrefix}).(*limiter)
+	require.True(t, ok)
+	require.Equal(t, testPrefix, l.prefix)
+	require.NotNil(t, l.redisClient)
+	require.NotNil(t, l.nowFn)
+	require.NotNil(t, l.allowFn)
+}
+
+func TestLimiterAllow(t *testing.T) {
+	testLimit := badges.ClientLimit{Rate: 1, Burst: 10}
+	testCases := []struct {
+		name       string
+		limiter    *limiter
+		assertions func(bool, error)
+	}{
+		{
+			name: "error running script",
+			limiter: &limiter{
+				allowFn: func(
+					context.Context,
+					string,
+					badges.ClientLimit,
+					time.Time,
+				) (bool, error) {
+					return false, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error taking token from bucket")
+			},
+		},
+		{
+			name: "success",
+			limiter: &limiter{
+				prefix: "foo",
+				allowFn: func(
+					_ context.Context,
+					key string,
+					limit badges.ClientLimit,
+					_ time.Time,
+				) (bool, error) {
+					require.Equal(t, "foo:ratelimit:ip:192.0.2.1", key)
+					require.Equal(t, testLimit, limit)
+					return true, nil
+				},
+			},
+			assertions: func(allowed bool, err error) {
+				require.NoError(t, err)
+				require.True(t, allowed)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.limiter.nowFn = time.Now
+			testCase.assertions(
+				testCase.limiter.Allow(
+					context.Background(),
+					"ip:192.0.2.1",
+					testLimit,
+				),
+			)
+		})
+	}
+}
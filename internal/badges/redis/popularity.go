@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PopularityConfig represents configuration options for the Redis-based
+// implementation of the badges.Popularity interface.
+type PopularityConfig struct {
+	// Window is the period over which requests are tallied. Badges are ranked
+	// using the tallies from the current and previous windows, so requests are
+	// forgotten after two windows have elapsed.
+	Window time.Duration
+	// Capacity is the number of badges tallied in each window, so the memory
+	// used by Redis is bounded. Once a window is full, a badge not yet tallied
+	// replaces the one with the lowest tally, inheriting that tally, as in the
+	// Space-Saving algorithm. If zero, every badge is tallied.
+	Capacity int
+}
+
+type popularity struct {
+	config      PopularityConfig
+	redisClient *redis.Client
+	prefix      string
+	nowFn       func() time.Time
+	// The following internal functions are overridable for testing purposes
+	incrFn func(
+		ctx context.Context,
+		key string,
+		member string,
+		capacity int,
+		ttl time.Duration,
+	) error
+	topFn func(ctx context.Context, key string, n int) ([]redis.Z, error)
+}
+
+// NewPopularity returns a new Redis-based implementation of the
+// badges.Popularity interface. Request tallies for each window are kept in a
+// sorted set that holds no more than the configured capacity, and that expires
+// once it is no longer needed.
+func NewPopularity(
+	cacheConfig CacheConfig,
+	config PopularityConfig,
+) badges.Popularity {
+	p := &popularity{
+		config:      config,
+		redisClient: newRedisClient(cacheConfig),
+		prefix:      cacheConfig.RedisPrefix,
+		nowFn:       time.Now,
+	}
+	p.incrFn = p.incr
+	p.topFn = p.top
+	return p
+}
+
+func (p *popularity) Record(ctx context.Context, key string) (err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"redis.popularity.Record",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("badgr.cache.key", key),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+	if err = p.incrFn(
+		ctx,
+		p.getKey(p.nowFn()),
+		key,
+		p.config.Capacity,
+		2*p.config.Window,
+	); err != nil {
+		return errors.Wrapf(err, "error recording request for key %q", key)
+	}
+	return nil
+}
+
+func (p *popularity) Top(ctx context.Context, n int) (_ []string, err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"redis.popularity.Top",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+	defer func() { endSpan(span, err) }()
+	// Sum the tallies from the current and previous windows. Strictly speaking,
+	// a key outside the top n of both windows could belong among the top n of
+	// the sums, but that's close enough.
+	now := p.nowFn()
+	scores := map[string]float64{}
+	for _, t := range []time.Time{now, now.Add(-p.config.Window)} {
+		var members []redis.Z
+		if members, err = p.topFn(ctx, p.getKey(t), n); err != nil {
+			return nil, errors.Wrap(err, "error retrieving popular keys")
+		}
+		for _, member := range members {
+			if key, ok := member.Member.(string); ok {
+				scores[key] += member.Score
+			}
+		}
+	}
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys, nil
+}
+
+// getKey returns the key of the sorted set holding tallies for the window
+// containing the specified time.
+func (p *popularity) getKey(t time.Time) string {
+	key := fmt.Sprintf(
+		"popularity:%d",
+		t.Truncate(p.config.Window).Unix(),
+	)
+	if p.prefix == "" {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", p.prefix, key)
+}
+
+// maxIncrAttempts is the number of times incrementing a tally is attempted
+// before giving up, should other replicas keep modifying the same window.
+const maxIncrAttempts = 3
+
+func (p *popularity) incr(
+	ctx context.Context,
+	key string,
+	member string,
+	capacity int,
+	ttl time.Duration,
+) error {
+	client := p.redisClient.WithContext(ctx)
+	var err error
+	for i := 0; i < maxIncrAttempts; i++ {
+		if err = client.Watch(func(tx *redis.Tx) error {
+			evict, increment, err := spaceSaving(tx, key, member, capacity)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+				if evict != "" {
+					pipe.ZRem(key, evict)
+				}
+				pipe.ZIncrBy(key, increment, member)
+				pipe.Expire(key, ttl)
+				return nil
+			})
+			return err
+		}, key); err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+// sortedSet is the subset of Redis' sorted set commands that spaceSaving
+// relies upon.
+type sortedSet interface {
+	ZScore(key, member string) *redis.FloatCmd
+	ZCard(key string) *redis.IntCmd
+	ZRangeWithScores(key string, start, stop int64) *redis.ZSliceCmd
+}
+
+// spaceSaving implements the eviction policy of the Space-Saving algorithm for
+// a sorted set holding at most the specified number of tallies. It returns the
+// member that must be evicted to make room for the specified member, if any,
+// and the amount by which the specified member's tally must be incremented.
+// A member that is not yet tallied in a full set replaces the member with the
+// lowest tally and inherits that tally, so that badges only beginning to gain
+// popularity are never evicted the moment they are recorded. Tallies may thus
+// overestimate, but never underestimate, how often a badge was requested.
+func spaceSaving(
+	set sortedSet,
+	key string,
+	member string,
+	capacity int,
+) (string, float64, error) {
+	if capacity <= 0 {
+		return "", 1, nil
+	}
+	err := set.ZScore(key, member).Err()
+	if err == nil {
+		return "", 1, nil // Already tallied
+	}
+	if err != redis.Nil {
+		return "", 0, err
+	}
+	size, err := set.ZCard(key).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	if size < int64(capacity) {
+		return "", 1, nil
+	}
+	lowest, err := set.ZRangeWithScores(key, 0, 0).Result()
+	if err != nil || len(lowest) == 0 {
+		return "", 1, err
+	}
+	evict, _ := lowest[0].Member.(string)
+	return evict, lowest[0].Score + 1, nil
+}
+
+func (p *popularity) top(
+	ctx context.Context,
+	key string,
+	n int,
+) ([]redis.Z, error) {
+	return p.redisClient.WithContext(ctx).
+		ZRevRangeWithScores(key, 0, int64(n-1)).Result()
+}
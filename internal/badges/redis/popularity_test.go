@@ -0,0 +1,281 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPopularity(t *testing.T) {
+	const testPrefix = "foo"
+	testConfig := PopularityConfig{Window: time.Hour}
+	p, ok := NewPopularity(
+		CacheConfig{RedisPrefix: testPrefix},
+		testConfig,
+	).(*popularity)
+	require.True(t, ok)
+	require.Equal(t, testConfig, p.config)
+	require.Equal(t, testPrefix, p.prefix)
+	require.NotNil(t, p.redisClient)
+	require.NotNil(t, p.nowFn)
+	require.NotNil(t, p.incrFn)
+	require.NotNil(t, p.topFn)
+}
+
+func TestPopularityRecord(t *testing.T) {
+	const testKey = "key"
+	now := time.Date(2021, time.October, 1, 12, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		name       string
+		popularity *popularity
+		assertions func(error)
+	}{
+		{
+			name: "error writing to redis",
+			popularity: &popularity{
+				incrFn: func(
+					context.Context,
+					string,
+					string,
+					int,
+					time.Duration,
+				) error {
+					return errors.New("something went wrong")
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error recording request for key")
+			},
+		},
+		{
+			name: "success",
+			popularity: &popularity{
+				incrFn: func(
+					_ context.Context,
+					key string,
+					member string,
+					capacity int,
+					ttl time.Duration,
+				) error {
+					require.Equal(
+						t,
+						"foo:popularity:1633089600", // 2021-10-01T12:00:00Z
+						key,
+					)
+					require.Equal(t, testKey, member)
+					require.Equal(t, 100, capacity)
+					require.Equal(t, 2*time.Hour, ttl)
+					return nil
+				},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.popularity.config = PopularityConfig{
+				Window:   time.Hour,
+				Capacity: 100,
+			}
+			testCase.popularity.prefix = "foo"
+			testCase.popularity.nowFn = func() time.Time {
+				return now
+			}
+			testCase.assertions(
+				testCase.popularity.Record(context.Background(), testKey),
+			)
+		})
+	}
+}
+
+func TestPopularityTop(t *testing.T) {
+	now := time.Date(2021, time.October, 1, 12, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		name       string
+		popularity *popularity
+		assertions func([]string, error)
+	}{
+		{
+			name: "error reading from redis",
+			popularity: &popularity{
+				topFn: func(context.Context, string, int) ([]redis.Z, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ []string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error retrieving popular keys")
+			},
+		},
+		{
+			name: "success",
+			popularity: &popularity{
+				topFn: func(
+					_ context.Context,
+					key string,
+					n int,
+				) ([]redis.Z, error) {
+					require.Equal(t, 2, n)
+					switch key {
+					case "popularity:1633089600": // Current window
+						return []redis.Z{
+							{Member: "foo", Score: 3},
+							{Member: "bar", Score: 2},
+						}, nil
+					case "popularity:1633086000": // Previous window
+						return []redis.Z{
+							{Member: "baz", Score: 4},
+							{Member: "bar", Score: 3},
+						}, nil
+					}
+					require.Fail(t, "unexpected key", key)
+					return nil, nil
+				},
+			},
+			assertions: func(keys []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"bar", "baz"}, keys)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.popularity.config = PopularityConfig{Window: time.Hour}
+			testCase.popularity.nowFn = func() time.Time {
+				return now
+			}
+			testCase.assertions(
+				testCase.popularity.Top(context.Background(), 2),
+			)
+		})
+	}
+}
+
+func TestSpaceSaving(t *testing.T) {
+	const testKey = "popularity:1633089600"
+	testCases := []struct {
+		name              string
+		tallies           map[string]float64
+		capacity          int
+		member            string
+		expectedEvict     string
+		expectedIncrement float64
+	}{
+		{
+			name:              "unlimited capacity",
+			tallies:           map[string]float64{"/foo": 5, "/bar": 3},
+			member:            "/baz",
+			expectedIncrement: 1,
+		},
+		{
+			name:              "already tallied",
+			tallies:           map[string]float64{"/foo": 5, "/bar": 3},
+			capacity:          2,
+			member:            "/bar",
+			expectedIncrement: 1,
+		},
+		{
+			name:              "room to spare",
+			tallies:           map[string]float64{"/foo": 5},
+			capacity:          2,
+			member:            "/bar",
+			expectedIncrement: 1,
+		},
+		{
+			name:              "newcomer in a full window",
+			tallies:           map[string]float64{"/foo": 5, "/bar": 3},
+			capacity:          2,
+			member:            "/baz",
+			expectedEvict:     "/bar",
+			expectedIncrement: 4,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			set := &mockSortedSet{key: testKey, tallies: testCase.tallies}
+			evict, increment, err := spaceSaving(
+				set,
+				testKey,
+				testCase.member,
+				testCase.capacity,
+			)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedEvict, evict)
+			require.Equal(t, testCase.expectedIncrement, increment)
+		})
+	}
+}
+
+func TestSpaceSavingNewcomerSurvives(t *testing.T) {
+	const testKey = "popularity:1633089600"
+	set := &mockSortedSet{
+		key:     testKey,
+		tallies: map[string]float64{"/foo": 5, "/bar": 3},
+	}
+	// A newcomer recorded repeatedly in a full window builds a tally, and
+	// eventually outranks everything else
+	for i := 0; i < 3; i++ {
+		evict, increment, err := spaceSaving(set, testKey, "/baz", 2)
+		require.NoError(t, err)
+		delete(set.tallies, evict)
+		set.tallies["/baz"] += increment
+		require.Len(t, set.tallies, 2)
+		require.Contains(t, set.tallies, "/baz")
+	}
+	require.Equal(t, float64(6), set.tallies["/baz"])
+	require.Equal(t, float64(5), set.tallies["/foo"])
+}
+
+// mockSortedSet is an in-memory implementation of the sortedSet interface
+// holding a single sorted set.
+type mockSortedSet struct {
+	key     string
+	tallies map[string]float64
+}
+
+func (m *mockSortedSet) ZScore(key, member string) *redis.FloatCmd {
+	if score, ok := m.tallies[member]; ok && key == m.key {
+		return redis.NewFloatResult(score, nil)
+	}
+	return redis.NewFloatResult(0, redis.Nil)
+}
+
+func (m *mockSortedSet) ZCard(key string) *redis.IntCmd {
+	if key != m.key {
+		return redis.NewIntResult(0, nil)
+	}
+	return redis.NewIntResult(int64(len(m.tallies)), nil)
+}
+
+func (m *mockSortedSet) ZRangeWithScores(
+	key string,
+	start int64,
+	stop int64,
+) *redis.ZSliceCmd {
+	members := []redis.Z{}
+	if key == m.key {
+		for member, score := range m.tallies {
+			members = append(members, redis.Z{Score: score, Member: member})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Score < members[j].Score
+	})
+	if start >= int64(len(members)) {
+		return redis.NewZSliceCmdResult(nil, nil)
+	}
+	if stop >= int64(len(members)) {
+		stop = int64(len(members)) - 1
+	}
+	return redis.NewZSliceCmdResult(members[start:stop+1], nil)
+}
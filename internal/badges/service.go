@@ -2,10 +2,19 @@ package badges
 
 import (
 	"context"
-	"math"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v33/github"
+	"github.com/google/go-querystring/query"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 // Service is an interface for components that can handle requests for a badge.
@@ -17,26 +26,188 @@ type Service interface {
 		repo string,
 		opts *CheckBadgeOptions,
 	) (CheckBadge, error)
+	// Ping verifies that the upstream the service depends upon is reachable.
+	Ping(ctx context.Context) error
+}
+
+const (
+	// BackendREST indicates that check suites should be retrieved using
+	// GitHub's REST API.
+	BackendREST = "rest"
+	// BackendGraphQL indicates that check suites should be retrieved using
+	// GitHub's GraphQL API. This requires a token.
+	BackendGraphQL = "graphql"
+)
+
+// checkSuitesPerPage is the number of check suites requested per page. This is
+// the maximum GitHub permits.
+const checkSuitesPerPage = 100
+
+// ServiceConfig represents configuration options for the Service.
+type ServiceConfig struct {
+	// Backend specifies which of GitHub's APIs check suites should be retrieved
+	// from. Valid values are BackendREST and BackendGraphQL. If unspecified,
+	// BackendREST is used.
+	Backend string
+	// Token, if non-empty, is used to authenticate to GitHub. This affords a
+	// far larger rate limit than anonymous access and is required by
+	// BackendGraphQL.
+	Token string
+	// BaseURL, if non-empty, is the base URL of the REST API of a GitHub
+	// Enterprise Server instance, e.g. https://github.example.com/api/v3/, to be
+	// queried instead of github.com.
+	BaseURL string
+	// Concurrency is the maximum number of pages of check suites that may be
+	// retrieved concurrently.
+	Concurrency int
+	// BatchWindow is how long BackendGraphQL waits for further requests for
+	// badges, after receiving one, so that check suites for all of them may be
+	// retrieved in a single query. If zero, requests are not batched.
+	BatchWindow time.Duration
 }
 
 type service struct {
-	// The following functions are usually provided by a GitHub client, but are
-	// overridable for testing purposes
+	config       ServiceConfig
+	githubClient *github.Client
+	rateLimits   *RateLimits
+	breaker      *CircuitBreaker
+	// The following functions are usually implemented using a GitHub client, but
+	// are overridable for testing purposes
 	listCheckSuitesForRefFn func(
 		ctx context.Context,
 		owner string,
 		repo string,
 		ref string,
 		opt *github.ListCheckSuiteOptions,
+		etag string,
 	) (*github.ListCheckSuiteResults, *github.Response, error)
+	getRateLimitsFn func(ctx context.Context) (*github.Response, error)
+	getRepositoryFn func(
+		ctx context.Context,
+		owner string,
+		repo string,
+	) (*github.Repository, *github.Response, error)
 }
 
 // NewService returns an implementation of the Service interface for handling
-// requests for a badge.
-func NewService() Service {
-	return &service{
-		listCheckSuitesForRefFn: github.NewClient(nil).Checks.ListCheckSuitesForRef,
+// requests for a badge. GitHub's rate limit headers are recorded to the
+// provided RateLimits and no requests are made to GitHub while it indicates the
+// budget is exhausted. Likewise, the outcome of every request for check suites
+// is recorded to the provided CircuitBreaker and no such requests are made
+// while the circuit is open.
+func NewService(
+	config ServiceConfig,
+	rateLimits *RateLimits,
+	breaker *CircuitBreaker,
+) Service {
+	s := newService(config, rateLimits, breaker)
+	if config.Backend == BackendGraphQL {
+		return newGraphQLService(s)
+	}
+	return s
+}
+
+func newService(
+	config ServiceConfig,
+	rateLimits *RateLimits,
+	breaker *CircuitBreaker,
+) *service {
+	var httpClient *http.Client
+	if config.Token != "" {
+		httpClient = &http.Client{
+			Transport: &tokenTransport{token: config.Token},
+		}
+	}
+	githubClient := github.NewClient(httpClient)
+	if config.BaseURL != "" {
+		// The URL was validated when configuration was loaded, so errors can be
+		// ignored
+		githubClient, _ = github.NewEnterpriseClient(
+			config.BaseURL,
+			config.BaseURL,
+			httpClient,
+		)
+	}
+	s := &service{
+		config:       config,
+		githubClient: githubClient,
+		rateLimits:   rateLimits,
+		breaker:      breaker,
+	}
+	s.listCheckSuitesForRefFn = s.listCheckSuitesForRef
+	s.getRateLimitsFn = s.getRateLimits
+	s.getRepositoryFn = s.githubClient.Repositories.Get
+	return s
+}
+
+// NewRepoVisibility returns an implementation of the RepoVisibility interface
+// that asks GitHub whether repositories are private. GitHub's rate limit
+// headers are recorded to the provided RateLimits and no requests are made to
+// GitHub while it indicates the budget is exhausted.
+func NewRepoVisibility(
+	config ServiceConfig,
+	rateLimits *RateLimits,
+) RepoVisibility {
+	// Repository lookups are not check suite requests, so they neither consult
+	// nor inform the circuit breaker
+	return newService(config, rateLimits, nil)
+}
+
+// Ping asks GitHub for Badgr's current rate limits. This is a cheap call that
+// does not itself count against the rate limit, so it is well-suited to
+// verifying that GitHub is reachable. As a bonus, the tracked budget is
+// refreshed.
+func (s *service) Ping(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"GitHub RateLimits",
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	response, err := s.getRateLimitsFn(ctx)
+	observeGitHubRequest(githubEndpointRateLimit, start, response)
+	s.rateLimits.record(rateLimitResourceCore, response, err)
+	return errors.Wrap(err, "error retrieving rate limits from GitHub")
+}
+
+// IsPrivate asks GitHub whether the specified repository is private. A
+// repository that the configured token cannot see is, as far as Badgr is
+// concerned, not private, since Badgr cannot serve badges for it anyway.
+func (s *service) IsPrivate(
+	ctx context.Context,
+	owner string,
+	repo string,
+) (_ bool, err error) {
+	if err = s.rateLimits.check(rateLimitResourceCore); err != nil {
+		return false, err
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"GitHub GetRepository",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("badgr.owner", owner),
+			attribute.String("badgr.repo", repo),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	repository, response, err := s.getRepositoryFn(ctx, owner, repo)
+	observeGitHubRequest(githubEndpointGetRepository, start, response)
+	s.rateLimits.record(rateLimitResourceCore, response, err)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(
+			err,
+			"error retrieving owner %q, repo %q from GitHub",
+			owner,
+			repo,
+		)
 	}
+	return repository.GetPrivate(), nil
 }
 
 func (s *service) CheckBadge(
@@ -44,7 +215,7 @@ func (s *service) CheckBadge(
 	owner string,
 	repo string,
 	opts *CheckBadgeOptions,
-) (CheckBadge, error) {
+) (_ CheckBadge, err error) {
 	if opts == nil {
 		opts = &CheckBadgeOptions{}
 	}
@@ -55,96 +226,381 @@ func (s *service) CheckBadge(
 		opts.Branch = "main"
 	}
 
+	ctx, span := tracer.Start(
+		ctx,
+		"badges.service.CheckBadge",
+		trace.WithAttributes(
+			attribute.String("badgr.owner", owner),
+			attribute.String("badgr.repo", repo),
+			attribute.String("badgr.branch", opts.Branch),
+			attribute.Int("badgr.github_app_id", opts.GitHubAppID),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+
 	badge := CheckBadge{
 		name:   opts.BadgeName,
 		status: CheckStatusUnknown,
 	}
 
-	checkSuites := []*github.CheckSuite{}
+	concurrency := s.config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// rounds returns how many rounds of requests it should take to retrieve
+	// the specified number of pages, given that the first is retrieved on its
+	// own and the remainder concurrently. This is used for dividing the deadline
+	// among pages.
+	rounds := func(pages int) int {
+		return 1 + (pages-1+concurrency-1)/concurrency
+	}
+
+	// Retrieve the first page on its own. It tells us how many more there are.
+	// Until we know, assume the number hasn't changed since we last looked.
+	firstPage, lastPage, err := s.checkSuitePage(
+		ctx,
+		owner,
+		repo,
+		opts,
+		1,
+		rounds(len(opts.CachedPages)),
+	)
+	if err != nil {
+		return badge, err
+	}
+	if lastPage == 0 { // No results at all
+		return badge, nil
+	}
+	pages := make([]CheckSuitePage, lastPage)
+	pages[0] = firstPage
+
+	if lastPage > 1 && !firstPage.final() {
+		// Retrieve the remaining pages concurrently. If we encounter a page whose
+		// status makes the overall status a foregone conclusion, there's no point
+		// in waiting for the rest.
+		fetchCtx, cancelFetches := context.WithCancel(ctx)
+		defer cancelFetches()
+		group, groupCtx := errgroup.WithContext(fetchCtx)
+		group.SetLimit(concurrency)
+		var mu sync.Mutex
+		var final bool
+		// abandoned returns a bool indicating whether an error resulted only from
+		// our having stopped waiting on pages after the outcome became final.
+		abandoned := func(err error) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return final && errors.Is(err, context.Canceled)
+		}
+		totalRounds := rounds(lastPage)
+		for p := 2; p <= lastPage; p++ {
+			p := p
+			group.Go(func() error {
+				if err := groupCtx.Err(); err != nil {
+					if abandoned(err) {
+						return nil
+					}
+					return err
+				}
+				page, _, err := s.checkSuitePage(
+					groupCtx,
+					owner,
+					repo,
+					opts,
+					p,
+					totalRounds-1-(p-2)/concurrency,
+				)
+				if err != nil {
+					if abandoned(err) {
+						return nil
+					}
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				pages[p-1] = page
+				if page.final() {
+					final = true
+					cancelFetches()
+				}
+				return nil
+			})
+		}
+		if err = group.Wait(); err != nil {
+			return badge, err
+		}
+	}
+	for i := range pages {
+		pages[i].HasNext = i < len(pages)-1
+	}
+
+	checkBadgePages.Observe(float64(len(pages)))
+	badge.status = pagesStatus(pages)
+	badge.pages = pages
+	return badge, nil
+}
+
+// checkSuitePage retrieves the specified page of check suites from GitHub and
+// summarizes it. The number of the last page is also returned. A last page of
+// zero indicates GitHub returned no results whatsoever. If a summary of the
+// same page is found among opts.CachedPages, the request is made conditionally
+// and that summary is returned if the page is unchanged. The request is
+// allotted its share of the time remaining before the provided context's
+// deadline, given the number of rounds of requests expected to remain.
+func (s *service) checkSuitePage(
+	ctx context.Context,
+	owner string,
+	repo string,
+	opts *CheckBadgeOptions,
+	pageNum int,
+	expectedRounds int,
+) (_ CheckSuitePage, _ int, err error) {
+	if err = s.rateLimits.check(rateLimitResourceCore); err != nil {
+		return CheckSuitePage{}, 0, err
+	}
+	if err = s.breaker.allow(); err != nil {
+		return CheckSuitePage{}, 0, err
+	}
 	ghOpts := &github.ListCheckSuiteOptions{
 		ListOptions: github.ListOptions{
-			Page: 1,
+			Page:    pageNum,
+			PerPage: checkSuitesPerPage,
 		},
 	}
 	if opts.GitHubAppID != 0 {
 		ghOpts.AppID = &opts.GitHubAppID
 	}
-	for {
-		results, response, err :=
-			s.listCheckSuitesForRefFn(ctx, owner, repo, opts.Branch, ghOpts)
-		if err != nil {
-			if opts.GitHubAppID == 0 {
-				return badge, errors.Wrapf(
-					err,
-					"error retrieving check suites for owner %q, repo %q, branch %q "+
-						"from GitHub",
-					owner,
-					repo,
-					opts.Branch,
-				)
+	var cachedPage *CheckSuitePage
+	if pageNum <= len(opts.CachedPages) {
+		cachedPage = &opts.CachedPages[pageNum-1]
+	}
+	var etag string
+	if cachedPage != nil {
+		etag = cachedPage.ETag
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"GitHub ListCheckSuitesForRef",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int("badgr.page", pageNum),
+			attribute.Bool("badgr.conditional", etag != ""),
+		),
+	)
+	fetchCtx, cancel := pageContext(ctx, expectedRounds)
+	start := time.Now()
+	results, response, err := s.listCheckSuitesForRefFn(
+		fetchCtx,
+		owner,
+		repo,
+		opts.Branch,
+		ghOpts,
+		etag,
+	)
+	cancel()
+	observeGitHubRequest(githubEndpointListCheckSuitesForRef, start, response)
+	s.rateLimits.record(rateLimitResourceCore, response, err)
+	s.breaker.record(time.Since(start), err)
+	var notModified bool
+	var lastPage int
+	if response != nil {
+		lastPage = response.LastPage
+	}
+	if response != nil && response.Response != nil {
+		notModified = response.StatusCode == http.StatusNotModified
+		span.SetAttributes(
+			attribute.Int("http.status_code", response.StatusCode),
+		)
+	}
+	if notModified {
+		span.End() // Not really an error
+	} else {
+		endSpan(span, err)
+	}
+
+	if cachedPage != nil && notModified {
+		// This page is unchanged since we last retrieved it
+		if lastPage == 0 {
+			lastPage = pageNum
+			if cachedPage.HasNext {
+				lastPage = len(opts.CachedPages)
 			}
-			return badge, errors.Wrapf(
+		}
+		return *cachedPage, lastPage, nil
+	}
+	if err != nil {
+		if opts.GitHubAppID == 0 {
+			return CheckSuitePage{}, 0, errors.Wrapf(
 				err,
-				"error retrieving check suites for appID %d, owner %q, repo %q, "+
-					"branch %q from GitHub",
-				opts.GitHubAppID,
+				"error retrieving check suites for owner %q, repo %q, branch %q "+
+					"from GitHub",
 				owner,
 				repo,
 				opts.Branch,
 			)
 		}
+		return CheckSuitePage{}, 0, errors.Wrapf(
+			err,
+			"error retrieving check suites for appID %d, owner %q, repo %q, "+
+				"branch %q from GitHub",
+			opts.GitHubAppID,
+			owner,
+			repo,
+			opts.Branch,
+		)
+	}
 
-		if results == nil {
-			break
-		}
-		checkSuites = append(checkSuites, results.CheckSuites...)
-		if response == nil || response.NextPage == 0 {
-			break
+	if results == nil {
+		return CheckSuitePage{}, 0, nil
+	}
+	page := CheckSuitePage{
+		Count:  len(results.CheckSuites),
+		Status: checkStatus(results.CheckSuites),
+	}
+	if response != nil && response.Response != nil {
+		page.ETag = response.Header.Get("ETag")
+	}
+	// GitHub only tells us the last page if we're not on it
+	if lastPage == 0 {
+		lastPage = pageNum
+		if total := results.GetTotal(); total > pageNum*checkSuitesPerPage {
+			lastPage = (total + checkSuitesPerPage - 1) / checkSuitesPerPage
 		}
-		ghOpts.ListOptions.Page = response.NextPage
 	}
+	return page, lastPage, nil
+}
 
-	badge.status = checkStatus(checkSuites)
-	return badge, nil
+// pageContext derives a context for retrieving a single page from the provided
+// context, allotting that page its share of whatever time remains before the
+// provided context's deadline, given the number of rounds of requests expected
+// to remain.
+func pageContext(
+	ctx context.Context,
+	expectedRounds int,
+) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || expectedRounds <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(
+		ctx,
+		time.Until(deadline)/time.Duration(expectedRounds),
+	)
 }
 
-func checkStatus(checkSuites []*github.CheckSuite) CheckStatus {
-	if len(checkSuites) == 0 {
-		return CheckStatusUnknown
+// listCheckSuitesForRef is equivalent to the GitHub client's
+// Checks.ListCheckSuitesForRef function, except that when a non-empty etag is
+// specified, the request is made conditionally.
+func (s *service) listCheckSuitesForRef(
+	ctx context.Context,
+	owner string,
+	repo string,
+	ref string,
+	opts *github.ListCheckSuiteOptions,
+	etag string,
+) (*github.ListCheckSuiteResults, *github.Response, error) {
+	refParts := strings.Split(ref, "/")
+	for i, refPart := range refParts {
+		refParts[i] = url.PathEscape(refPart)
 	}
-	// To consolidate the status of many checks into a single badge, we start with
-	// a passed badge, then we iterate over all checks, progressively degrading
-	// the badge status if/as worse outcomes are found.
+	u := fmt.Sprintf(
+		"repos/%s/%s/commits/%s/check-suites",
+		owner,
+		repo,
+		strings.Join(refParts, "/"),
+	)
+	if opts != nil {
+		qs, err := query.Values(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		u = fmt.Sprintf("%s?%s", u, qs.Encode())
+	}
+	req, err := s.githubClient.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	var results *github.ListCheckSuiteResults
+	response, err := s.githubClient.Do(ctx, req, &results)
+	if err != nil {
+		return nil, response, err
+	}
+	return results, response, nil
+}
+
+// getRateLimits wraps the GitHub client's RateLimits function, returning only
+// the response.
+func (s *service) getRateLimits(ctx context.Context) (*github.Response, error) {
+	_, response, err := s.githubClient.RateLimits(ctx)
+	return response, err
+}
+
+// pagesStatus consolidates the statuses of many pages of check suites into a
+// single status.
+func pagesStatus(pages []CheckSuitePage) CheckStatus {
+	var count int
 	status := CheckStatusPassed
-	for _, checkSuite := range checkSuites {
-		// Default to unknown if we cannot figure out the status
-		newStatus := CheckStatusUnknown
-		switch checkSuite.GetStatus() {
-		case "completed":
-			switch checkSuite.GetConclusion() {
-			case "success":
-				newStatus = CheckStatusPassed
-			case "failure":
-				newStatus = CheckStatusFailed
-			case "neutral":
-				newStatus = CheckStatusNeutral
-			case "cancelled": // nolint: misspell
-				// ^ This is how GitHub spells it
-				newStatus = CheckStatusCanceled
-			case "timed_out":
-				newStatus = CheckStatusTimedOut
-			case "action_required":
-				newStatus = CheckStatusActionRequired
-			}
-		case "in_progress":
-			newStatus = CheckStatusInProgress
-		case "queued":
-			newStatus = CheckStatusQueued
+	for _, page := range pages {
+		if page.Count == 0 {
+			continue
 		}
-		// The badge's new status is the higher severity of the two
-		// Lower value == higher severity-- that allows unknown (0) to be treated as
-		// most severe.
-		status = CheckStatus(math.Min(float64(status), float64(newStatus)))
+		count += page.Count
+		status = MostSevereCheckStatus(status, page.Status)
+	}
+	if count == 0 {
+		return CheckStatusUnknown
 	}
 	return status
 }
+
+func checkStatus(checkSuites []*github.CheckSuite) CheckStatus {
+	statuses := make([]CheckStatus, len(checkSuites))
+	for i, checkSuite := range checkSuites {
+		statuses[i] = checkSuiteStatus(checkSuite)
+	}
+	return MostSevereCheckStatus(statuses...)
+}
+
+// checkSuiteStatus maps the status and conclusion of a single check suite onto
+// a CheckStatus.
+func checkSuiteStatus(checkSuite *github.CheckSuite) CheckStatus {
+	switch checkSuite.GetStatus() {
+	case "completed":
+		switch checkSuite.GetConclusion() {
+		case "success":
+			return CheckStatusPassed
+		case "failure":
+			return CheckStatusFailed
+		case "neutral":
+			return CheckStatusNeutral
+		case "cancelled": // nolint: misspell
+			// ^ This is how GitHub spells it
+			return CheckStatusCanceled
+		case "timed_out":
+			return CheckStatusTimedOut
+		case "action_required":
+			return CheckStatusActionRequired
+		}
+	case "in_progress":
+		return CheckStatusInProgress
+	case "queued":
+		return CheckStatusQueued
+	}
+	// Default to unknown if we cannot figure out the status
+	return CheckStatusUnknown
+}
+
+// tokenTransport is an http.RoundTripper that authenticates every request
+// using a token.
+type tokenTransport struct {
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -3,16 +3,41 @@ package badges
 import (
 	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v33/github"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewService(t *testing.T) {
-	service, ok := NewService().(*service)
+	rateLimits := NewRateLimits()
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{})
+	testConfig := ServiceConfig{Concurrency: 4}
+	service, ok := NewService(testConfig, rateLimits, breaker).(*service)
 	require.True(t, ok)
+	require.Equal(t, testConfig, service.config)
+	require.Same(t, rateLimits, service.rateLimits)
+	require.Same(t, breaker, service.breaker)
+	require.NotNil(t, service.githubClient)
 	require.NotNil(t, service.listCheckSuitesForRefFn)
+	require.NotNil(t, service.getRateLimitsFn)
+}
+
+func TestNewServiceEnterprise(t *testing.T) {
+	service := newService(
+		ServiceConfig{BaseURL: "https://github.example.com"},
+		NewRateLimits(),
+		NewCircuitBreaker(CircuitBreakerConfig{}),
+	)
+	require.Equal(
+		t,
+		"https://github.example.com/api/v3/",
+		service.githubClient.BaseURL.String(),
+	)
 }
 
 func TestServiceCheckBadge(t *testing.T) {
@@ -20,20 +45,24 @@ func TestServiceCheckBadge(t *testing.T) {
 	const testRepo = "bar"
 	const testBadgeName = "build"
 	testCases := []struct {
-		name       string
-		service    *service
-		appID      int
-		assertions func(CheckBadge, error)
+		name        string
+		service     *service
+		appID       int
+		cachedPages []CheckSuitePage
+		assertions  func(CheckBadge, error)
 	}{
 		{
 			name: "no app id; error communicating with github",
 			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
 				listCheckSuitesForRefFn: func(
 					context.Context,
 					string,
 					string,
 					string,
 					*github.ListCheckSuiteOptions,
+					string,
 				) (*github.ListCheckSuiteResults, *github.Response, error) {
 					return nil, nil, errors.New("something went wrong")
 				},
@@ -51,12 +80,15 @@ func TestServiceCheckBadge(t *testing.T) {
 		{
 			name: "with app id; error communicating with github",
 			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
 				listCheckSuitesForRefFn: func(
 					context.Context,
 					string,
 					string,
 					string,
 					*github.ListCheckSuiteOptions,
+					string,
 				) (*github.ListCheckSuiteResults, *github.Response, error) {
 					return nil, nil, errors.New("something went wrong")
 				},
@@ -72,15 +104,77 @@ func TestServiceCheckBadge(t *testing.T) {
 				)
 			},
 		},
+		{
+			name: "rate limit exhausted",
+			service: &service{
+				rateLimits: &RateLimits{
+					statuses: map[string]RateLimitStatus{
+						rateLimitResourceCore: {
+							Limit: 60,
+							Reset: time.Now().Add(time.Hour),
+						},
+					},
+					nowFn: time.Now,
+				},
+				breaker: NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					context.Context,
+					string,
+					string,
+					string,
+					*github.ListCheckSuiteOptions,
+					string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					require.Fail(t, "GitHub should not have been queried")
+					return nil, nil, nil
+				},
+			},
+			assertions: func(_ CheckBadge, err error) {
+				require.Error(t, err)
+				var rateLimitedErr *rateLimitedError
+				require.ErrorAs(t, err, &rateLimitedErr)
+			},
+		},
+		{
+			name: "circuit open",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker: &CircuitBreaker{
+					config:   CircuitBreakerConfig{OpenDuration: time.Minute},
+					state:    CircuitStateOpen,
+					openedAt: time.Now(),
+					nowFn:    time.Now,
+				},
+				listCheckSuitesForRefFn: func(
+					context.Context,
+					string,
+					string,
+					string,
+					*github.ListCheckSuiteOptions,
+					string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					require.Fail(t, "GitHub should not have been queried")
+					return nil, nil, nil
+				},
+			},
+			assertions: func(_ CheckBadge, err error) {
+				require.Error(t, err)
+				var circuitOpenErr *circuitOpenError
+				require.ErrorAs(t, err, &circuitOpenErr)
+			},
+		},
 		{
 			name: "no result from github",
 			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
 				listCheckSuitesForRefFn: func(
 					context.Context,
 					string,
 					string,
 					string,
 					*github.ListCheckSuiteOptions,
+					string,
 				) (*github.ListCheckSuiteResults, *github.Response, error) {
 					return nil, nil, nil
 				},
@@ -100,12 +194,15 @@ func TestServiceCheckBadge(t *testing.T) {
 		{
 			name: "success",
 			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
 				listCheckSuitesForRefFn: func(
 					context.Context,
 					string,
 					string,
 					string,
 					*github.ListCheckSuiteOptions,
+					string,
 				) (*github.ListCheckSuiteResults, *github.Response, error) {
 					return &github.ListCheckSuiteResults{
 						CheckSuites: []*github.CheckSuite{
@@ -124,6 +221,273 @@ func TestServiceCheckBadge(t *testing.T) {
 					CheckBadge{
 						name:   testBadgeName,
 						status: CheckStatusPassed,
+						pages: []CheckSuitePage{
+							{
+								Count:  1,
+								Status: CheckStatusPassed,
+							},
+						},
+					},
+					badge,
+				)
+			},
+		},
+		{
+			name: "success; multiple pages",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					_ context.Context,
+					_ string,
+					_ string,
+					_ string,
+					opts *github.ListCheckSuiteOptions,
+					_ string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					if opts.Page == 1 {
+						return &github.ListCheckSuiteResults{
+							CheckSuites: []*github.CheckSuite{
+								{
+									Status:     github.String("completed"),
+									Conclusion: github.String("success"),
+								},
+							},
+						}, &github.Response{
+							Response: &http.Response{
+								Header: http.Header{"Etag": []string{"page-1"}},
+							},
+							LastPage: 2,
+						}, nil
+					}
+					return &github.ListCheckSuiteResults{
+						CheckSuites: []*github.CheckSuite{
+							{
+								Status: github.String("in_progress"),
+							},
+						},
+					}, &github.Response{
+						Response: &http.Response{
+							Header: http.Header{"Etag": []string{"page-2"}},
+						},
+					}, nil
+				},
+			},
+			assertions: func(badge CheckBadge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					CheckBadge{
+						name:   testBadgeName,
+						status: CheckStatusInProgress,
+						pages: []CheckSuitePage{
+							{
+								ETag:    "page-1",
+								Count:   1,
+								Status:  CheckStatusPassed,
+								HasNext: true,
+							},
+							{
+								ETag:   "page-2",
+								Count:  1,
+								Status: CheckStatusInProgress,
+							},
+						},
+					},
+					badge,
+				)
+			},
+		},
+		{
+			name: "success; many pages retrieved concurrently",
+			service: &service{
+				config:     ServiceConfig{Concurrency: 2},
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					_ context.Context,
+					_ string,
+					_ string,
+					_ string,
+					opts *github.ListCheckSuiteOptions,
+					_ string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					require.Equal(t, checkSuitesPerPage, opts.PerPage)
+					status := "queued"
+					if opts.Page == 3 {
+						status = "in_progress"
+					}
+					return &github.ListCheckSuiteResults{
+						// Only the total count tells us how many pages there are
+						Total: github.Int(4*checkSuitesPerPage - 1),
+						CheckSuites: []*github.CheckSuite{
+							{
+								Status: github.String(status),
+							},
+						},
+					}, nil, nil
+				},
+			},
+			assertions: func(badge CheckBadge, err error) {
+				require.NoError(t, err)
+				require.Equal(t, CheckStatusQueued, badge.status)
+				require.Len(t, badge.pages, 4)
+				for i, page := range badge.pages {
+					require.Equal(t, 1, page.Count)
+					require.Equal(t, i < 3, page.HasNext)
+				}
+				require.Equal(t, CheckStatusInProgress, badge.pages[2].Status)
+			},
+		},
+		{
+			name: "failure makes the result final",
+			service: &service{
+				config:     ServiceConfig{Concurrency: 1},
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					ctx context.Context,
+					_ string,
+					_ string,
+					_ string,
+					opts *github.ListCheckSuiteOptions,
+					_ string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					switch opts.Page {
+					case 1:
+						return &github.ListCheckSuiteResults{
+							CheckSuites: []*github.CheckSuite{
+								{
+									Status: github.String("queued"),
+								},
+							},
+						}, &github.Response{LastPage: 5}, nil
+					case 2:
+						return &github.ListCheckSuiteResults{
+							CheckSuites: []*github.CheckSuite{
+								{
+									Status:     github.String("completed"),
+									Conclusion: github.String("failure"),
+								},
+							},
+						}, nil, nil
+					}
+					require.Fail(t, "pages after a failure should not be retrieved")
+					return nil, nil, nil
+				},
+			},
+			assertions: func(badge CheckBadge, err error) {
+				require.NoError(t, err)
+				require.Equal(t, CheckStatusFailed, badge.status)
+				// Pages we didn't bother retrieving are left blank
+				require.Len(t, badge.pages, 5)
+				require.Equal(t, CheckStatusFailed, badge.pages[1].Status)
+				require.Equal(t, 0, badge.pages[2].Count)
+			},
+		},
+		{
+			name: "error retrieving subsequent page",
+			service: &service{
+				config:     ServiceConfig{Concurrency: 2},
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					_ context.Context,
+					_ string,
+					_ string,
+					_ string,
+					opts *github.ListCheckSuiteOptions,
+					_ string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					if opts.Page == 1 {
+						return &github.ListCheckSuiteResults{
+							CheckSuites: []*github.CheckSuite{
+								{
+									Status: github.String("queued"),
+								},
+							},
+						}, &github.Response{LastPage: 3}, nil
+					}
+					return nil, nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ CheckBadge, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+			},
+		},
+		{
+			name: "conditional requests; first page not modified",
+			cachedPages: []CheckSuitePage{
+				{
+					ETag:    "page-1",
+					Count:   1,
+					Status:  CheckStatusPassed,
+					HasNext: true,
+				},
+				{
+					ETag:   "page-2",
+					Count:  1,
+					Status: CheckStatusInProgress,
+				},
+			},
+			service: &service{
+				rateLimits: NewRateLimits(),
+				breaker:    NewCircuitBreaker(CircuitBreakerConfig{}),
+				listCheckSuitesForRefFn: func(
+					_ context.Context,
+					_ string,
+					_ string,
+					_ string,
+					opts *github.ListCheckSuiteOptions,
+					etag string,
+				) (*github.ListCheckSuiteResults, *github.Response, error) {
+					if opts.Page == 1 {
+						require.Equal(t, "page-1", etag)
+						response := &github.Response{
+							Response: &http.Response{
+								StatusCode: http.StatusNotModified,
+							},
+						}
+						return nil, response, &github.ErrorResponse{
+							Response: response.Response,
+						}
+					}
+					require.Equal(t, "page-2", etag)
+					return &github.ListCheckSuiteResults{
+						CheckSuites: []*github.CheckSuite{
+							{
+								Status:     github.String("completed"),
+								Conclusion: github.String("failure"),
+							},
+						},
+					}, &github.Response{
+						Response: &http.Response{
+							Header: http.Header{"Etag": []string{"page-2-new"}},
+						},
+					}, nil
+				},
+			},
+			assertions: func(badge CheckBadge, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					CheckBadge{
+						name:   testBadgeName,
+						status: CheckStatusFailed,
+						pages: []CheckSuitePage{
+							{
+								ETag:    "page-1",
+								Count:   1,
+								Status:  CheckStatusPassed,
+								HasNext: true,
+							},
+							{
+								ETag:   "page-2-new",
+								Count:  1,
+								Status: CheckStatusFailed,
+							},
+						},
 					},
 					badge,
 				)
@@ -139,6 +503,7 @@ func TestServiceCheckBadge(t *testing.T) {
 					testRepo,
 					&CheckBadgeOptions{
 						GitHubAppID: testCase.appID,
+						CachedPages: testCase.cachedPages,
 					},
 				),
 			)
@@ -146,6 +511,303 @@ func TestServiceCheckBadge(t *testing.T) {
 	}
 }
 
+func TestServicePing(t *testing.T) {
+	testCases := []struct {
+		name       string
+		service    *service
+		assertions func(*service, error)
+	}{
+		{
+			name: "error retrieving rate limits",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				getRateLimitsFn: func(context.Context) (*github.Response, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ *service, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(
+					t,
+					err.Error(),
+					"error retrieving rate limits from GitHub",
+				)
+			},
+		},
+		{
+			name: "success",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				getRateLimitsFn: func(context.Context) (*github.Response, error) {
+					return &github.Response{
+						Response: &http.Response{
+							StatusCode: http.StatusOK,
+							Header: http.Header{
+								"X-Ratelimit-Remaining": []string{"42"},
+							},
+						},
+						Rate: github.Rate{Limit: 60, Remaining: 42},
+					}, nil
+				},
+			},
+			assertions: func(s *service, err error) {
+				require.NoError(t, err)
+				// The tracked budget should have been refreshed
+				require.Equal(
+					t,
+					42,
+					s.rateLimits.Status(rateLimitResourceCore).Remaining,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.service.Ping(context.Background())
+			testCase.assertions(testCase.service, err)
+		})
+	}
+}
+
+func TestServiceIsPrivate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		service    *service
+		assertions func(bool, error)
+	}{
+		{
+			name: "rate limit exhausted",
+			service: &service{
+				rateLimits: &RateLimits{
+					statuses: map[string]RateLimitStatus{
+						rateLimitResourceCore: {
+							Limit: 60,
+							Reset: time.Now().Add(time.Hour),
+						},
+					},
+					nowFn: time.Now,
+				},
+			},
+			assertions: func(_ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "rate limit")
+			},
+		},
+		{
+			name: "not found",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				getRepositoryFn: func(
+					context.Context,
+					string,
+					string,
+				) (*github.Repository, *github.Response, error) {
+					return nil, &github.Response{
+						Response: &http.Response{StatusCode: http.StatusNotFound},
+					}, errors.New("not found")
+				},
+			},
+			assertions: func(private bool, err error) {
+				require.NoError(t, err)
+				require.False(t, private)
+			},
+		},
+		{
+			name: "error retrieving repository",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				getRepositoryFn: func(
+					context.Context,
+					string,
+					string,
+				) (*github.Repository, *github.Response, error) {
+					return nil, nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Contains(t, err.Error(), "error retrieving owner")
+			},
+		},
+		{
+			name: "success",
+			service: &service{
+				rateLimits: NewRateLimits(),
+				getRepositoryFn: func(
+					context.Context,
+					string,
+					string,
+				) (*github.Repository, *github.Response, error) {
+					return &github.Repository{Private: github.Bool(true)}, nil, nil
+				},
+			},
+			assertions: func(private bool, err error) {
+				require.NoError(t, err)
+				require.True(t, private)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				testCase.service.IsPrivate(context.Background(), "krancour", "foo"),
+			)
+		})
+	}
+}
+
+func TestPageContext(t *testing.T) {
+	// No deadline
+	ctx, cancel := pageContext(context.Background(), 2)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	require.False(t, ok)
+
+	parentCtx, parentCancel :=
+		context.WithTimeout(context.Background(), time.Minute)
+	defer parentCancel()
+	parentDeadline, _ := parentCtx.Deadline()
+
+	// Only one round expected; it may have all the remaining time
+	ctx, cancel = pageContext(parentCtx, 1)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	require.Equal(t, parentDeadline, deadline)
+
+	// Several rounds expected; each gets its share of the remaining time
+	ctx, cancel = pageContext(parentCtx, 4)
+	defer cancel()
+	deadline, ok = ctx.Deadline()
+	require.True(t, ok)
+	require.WithinDuration(
+		t,
+		time.Now().Add(15*time.Second),
+		deadline,
+		time.Second,
+	)
+}
+
+func TestServiceListCheckSuitesForRef(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(
+				t,
+				"/repos/foo/bar/commits/feature/baz/check-suites",
+				r.URL.Path,
+			)
+			require.Equal(t, "42", r.URL.Query().Get("app_id"))
+			require.Equal(t, "2", r.URL.Query().Get("page"))
+			if r.Header.Get("If-None-Match") == "etag" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", "etag")
+			_, err := w.Write(
+				[]byte(`{"total_count":1,"check_suites":[{"status":"queued"}]}`),
+			)
+			require.NoError(t, err)
+		}),
+	)
+	defer server.Close()
+	githubClient := github.NewClient(nil)
+	var err error
+	githubClient.BaseURL, err = url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	s := &service{githubClient: githubClient}
+	opts := &github.ListCheckSuiteOptions{
+		AppID: github.Int(42),
+		ListOptions: github.ListOptions{
+			Page: 2,
+		},
+	}
+
+	// Unconditional request
+	results, response, err := s.listCheckSuitesForRef(
+		context.Background(),
+		"foo",
+		"bar",
+		"feature/baz",
+		opts,
+		"",
+	)
+	require.NoError(t, err)
+	require.Equal(t, "etag", response.Header.Get("ETag"))
+	require.Len(t, results.CheckSuites, 1)
+
+	// Conditional request
+	_, response, err = s.listCheckSuitesForRef(
+		context.Background(),
+		"foo",
+		"bar",
+		"feature/baz",
+		opts,
+		"etag",
+	)
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotModified, response.StatusCode)
+}
+
+func TestPagesStatus(t *testing.T) {
+	testCases := []struct {
+		name           string
+		pages          []CheckSuitePage
+		expectedStatus CheckStatus
+	}{
+		{
+			name:           "no pages",
+			expectedStatus: CheckStatusUnknown,
+		},
+		{
+			name: "only empty pages",
+			pages: []CheckSuitePage{
+				{
+					Status: CheckStatusUnknown,
+				},
+			},
+			expectedStatus: CheckStatusUnknown,
+		},
+		{
+			name: "one page",
+			pages: []CheckSuitePage{
+				{
+					Count:  3,
+					Status: CheckStatusQueued,
+				},
+			},
+			expectedStatus: CheckStatusQueued,
+		},
+		{
+			name: "many pages",
+			pages: []CheckSuitePage{
+				{
+					Count:  3,
+					Status: CheckStatusPassed,
+				},
+				{
+					Count:  3,
+					Status: CheckStatusTimedOut,
+				},
+				{
+					Status: CheckStatusUnknown,
+				},
+			},
+			expectedStatus: CheckStatusTimedOut,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.expectedStatus,
+				pagesStatus(testCase.pages),
+			)
+		})
+	}
+}
+
 func TestCheckStatus(t *testing.T) {
 	testCases := []struct {
 		name           string
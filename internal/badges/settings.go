@@ -0,0 +1,41 @@
+package badges
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Settings is the public interface for any source of settings from which
+// providers are configured.
+type Settings interface {
+	// Setting returns the value of the setting stood in for by the specified
+	// environment variable, or an empty string if it is unset.
+	Setting(name string) string
+}
+
+// SettingsMap is an implementation of the Settings interface backed by a map
+// of values keyed by the environment variable each stands in for.
+type SettingsMap map[string]string
+
+// Setting returns the value of the specified setting from the map.
+func (s SettingsMap) Setting(name string) string {
+	return s[name]
+}
+
+// URLSetting returns the value of the specified setting, which, if set, must be
+// an absolute URL.
+func URLSetting(settings Settings, name string) (string, error) {
+	value := settings.Setting(name)
+	if value == "" {
+		return "", nil
+	}
+	if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+		return "", errors.Errorf(
+			"value %q for environment variable %s is not an absolute URL",
+			value,
+			name,
+		)
+	}
+	return value, nil
+}
@@ -0,0 +1,24 @@
+package badges
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLSetting(t *testing.T) {
+	value, err := URLSetting(SettingsMap{}, "FOO_URL")
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	_, err = URLSetting(SettingsMap{"FOO_URL": "foo.example.com"}, "FOO_URL")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not an absolute URL")
+
+	value, err = URLSetting(
+		SettingsMap{"FOO_URL": "https://foo.example.com"},
+		"FOO_URL",
+	)
+	require.NoError(t, err)
+	require.Equal(t, "https://foo.example.com", value)
+}
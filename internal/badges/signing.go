@@ -0,0 +1,233 @@
+package badges
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/pkg/errors"
+)
+
+// Query parameters carrying the signature and expiry of a signed badge URL
+const (
+	signatureParam = "sig"
+	expiresParam   = "expires"
+)
+
+// maxVisibilityEntries bounds the number of repositories whose visibility is
+// remembered at once. When the bound is reached, everything is forgotten.
+const maxVisibilityEntries = 10000
+
+var (
+	errSignatureMissing = errors.New("badge URL is not signed")
+	errSignatureInvalid = errors.New("badge URL signature is invalid")
+	errSignatureExpired = errors.New("badge URL signature has expired")
+)
+
+// RepoVisibility is the public interface for any component that can determine
+// whether a repository is private.
+type RepoVisibility interface {
+	// IsPrivate returns a bool indicating whether the specified repository is
+	// private.
+	IsPrivate(ctx context.Context, owner string, repo string) (bool, error)
+}
+
+// SigningConfig represents configuration options for requiring signed badge
+// URLs.
+type SigningConfig struct {
+	// Key is the secret with which badge URLs are signed.
+	Key []byte
+	// Owners lists the owners, matched case-insensitively, whose private
+	// repositories Badgr serves badges for only in response to requests with
+	// valid signatures or API keys. Badges for public repositories are always
+	// served.
+	Owners []string
+	// VisibilityTTL is how long Badgr remembers whether a repository is private.
+	VisibilityTTL time.Duration
+}
+
+// SignBadgeURL returns a copy of the URL of the provided request for a badge
+// with a signature added. The signature covers the badge's cache key, which
+// includes the path and every query parameter other than those used for
+// authentication, and, if non-zero, the provided expiry. It therefore remains
+// valid for requests whose parameters are merely reordered, but not for
+// requests with any parameter added, removed, or changed.
+func SignBadgeURL(key []byte, r *http.Request, expires time.Time) *url.URL {
+	u := *r.URL
+	query := u.Query()
+	query.Del(signatureParam)
+	query.Del(expiresParam)
+	var expiresStr string
+	if !expires.IsZero() {
+		expiresStr = strconv.FormatInt(expires.Unix(), 10)
+		query.Set(expiresParam, expiresStr)
+	}
+	query.Set(signatureParam, signature(key, cacheKey(r), expiresStr))
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// verifySignature returns an error if the provided request for a badge does
+// not bear a valid, unexpired signature.
+func verifySignature(key []byte, r *http.Request, now time.Time) error {
+	query := r.URL.Query()
+	sig := query.Get(signatureParam)
+	if sig == "" {
+		return errSignatureMissing
+	}
+	expiresStr := query.Get(expiresParam)
+	if !hmac.Equal(
+		[]byte(sig),
+		[]byte(signature(key, cacheKey(r), expiresStr)),
+	) {
+		return errSignatureInvalid
+	}
+	if expiresStr == "" {
+		return nil
+	}
+	// The signature is valid, so expiresStr was put there by SignBadgeURL
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return errSignatureInvalid
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return errSignatureExpired
+	}
+	return nil
+}
+
+// signature returns the encoded HMAC-SHA256 of the provided cache key and
+// expiry.
+func signature(key []byte, cacheKey string, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(cacheKey + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signatureVerifier is an http.Handler that serves an error badge in response
+// to unauthenticated requests for badges for private repositories belonging to
+// owners that require signatures and delegates all other requests to another
+// http.Handler.
+type signatureVerifier struct {
+	config     SigningConfig
+	owners     map[string]struct{}
+	visibility RepoVisibility
+	handler    http.Handler
+	nowFn      func() time.Time
+	mu         sync.Mutex
+	// known maps "owner/repo" to whether that repository is private
+	known map[string]knownVisibility
+}
+
+type knownVisibility struct {
+	private bool
+	expires time.Time
+}
+
+// NewSignatureVerifier returns an http.Handler that serves an error badge in
+// response to requests for badges for private repositories belonging to any
+// of the owners listed by the provided SigningConfig, unless those requests
+// bear a valid signature or have already been authenticated by an API key (see
+// NewAPIKeyAuthenticator). All other requests are delegated to the provided
+// http.Handler. Whether a repository is private is determined using the
+// provided RepoVisibility and is only looked up for requests that are not
+// otherwise authenticated.
+func NewSignatureVerifier(
+	config SigningConfig,
+	visibility RepoVisibility,
+	handler http.Handler,
+) http.Handler {
+	owners := make(map[string]struct{}, len(config.Owners))
+	for _, owner := range config.Owners {
+		owners[strings.ToLower(owner)] = struct{}{}
+	}
+	return &signatureVerifier{
+		config:     config,
+		owners:     owners,
+		visibility: visibility,
+		handler:    handler,
+		nowFn:      time.Now,
+		known:      map[string]knownVisibility{},
+	}
+}
+
+func (s *signatureVerifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subject := subjectFromRequest(r)
+	owner := subject.Owner
+	repo := subject.Repo
+	// The Prewarmer only replays requests that were served in the first place,
+	// and API keys have been checked against the repository already
+	if _, ok := s.owners[strings.ToLower(owner)]; !ok ||
+		refreshFromContext(r.Context()) != nil ||
+		apiKeyFromContext(r.Context()) != nil {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	err := verifySignature(s.config.Key, r, s.nowFn())
+	if err == nil {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	logger := logging.LoggerFromContext(r.Context()).With(
+		"owner", owner,
+		"repo", repo,
+	)
+	private, visibilityErr := s.isPrivate(r.Context(), owner, repo)
+	if visibilityErr != nil {
+		// Err on the side of caution
+		logger.Error(
+			"error determining whether repository is private",
+			"error", visibilityErr,
+		)
+		private = true
+	}
+	if !private {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	logger.Debug("refusing request for badge", "reason", err)
+	badge := NewErrBadge("forbidden")
+	if err == errSignatureExpired {
+		badge = NewErrBadge("signature expired")
+	}
+	refuse(w, r, badge)
+}
+
+// isPrivate returns a bool indicating whether the specified repository is
+// private, consulting GitHub only if that isn't already known.
+func (s *signatureVerifier) isPrivate(
+	ctx context.Context,
+	owner string,
+	repo string,
+) (bool, error) {
+	name := strings.ToLower(owner + "/" + repo)
+	now := s.nowFn()
+	s.mu.Lock()
+	known, ok := s.known[name]
+	s.mu.Unlock()
+	if ok && now.Before(known.expires) {
+		return known.private, nil
+	}
+	private, err := s.visibility.IsPrivate(ctx, owner, repo)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.known) >= maxVisibilityEntries {
+		s.known = map[string]knownVisibility{}
+	}
+	s.known[name] = knownVisibility{
+		private: private,
+		expires: now.Add(s.config.VisibilityTTL),
+	}
+	return private, nil
+}
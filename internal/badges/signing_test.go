@@ -0,0 +1,326 @@
+package badges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+var testSigningKey = []byte("secret")
+
+func TestSignBadgeURL(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name       string
+		url        string
+		expires    time.Time
+		verifyURL  func(signedURL string) string
+		verifyTime time.Time
+		assertions func(error)
+	}{
+		{
+			name: "valid without expiry",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			verifyURL: func(signedURL string) string {
+				return signedURL
+			},
+			verifyTime: now.Add(24 * 365 * time.Hour),
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name:    "valid with expiry",
+			url:     "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			expires: now.Add(time.Hour),
+			verifyURL: func(signedURL string) string {
+				return signedURL
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "valid with parameters reordered",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main&name=a",
+			verifyURL: func(signedURL string) string {
+				u, err := url.Parse(signedURL)
+				require.NoError(t, err)
+				query := u.Query()
+				return fmt.Sprintf(
+					"%s?sig=%s&name=a&branch=main",
+					u.Path,
+					query.Get(signatureParam),
+				)
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "parameter added",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			verifyURL: func(signedURL string) string {
+				return signedURL + "&name=tests"
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.Equal(t, errSignatureInvalid, err)
+			},
+		},
+		{
+			name: "parameter changed",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main&appID=1",
+			verifyURL: func(signedURL string) string {
+				return strings.Replace(signedURL, "appID=1", "appID=2", 1)
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.Equal(t, errSignatureInvalid, err)
+			},
+		},
+		{
+			name: "missing",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			verifyURL: func(string) string {
+				return "/v1/github/checks/krancour/foo/badge.svg?branch=main"
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.Equal(t, errSignatureMissing, err)
+			},
+		},
+		{
+			name: "signed for another branch",
+			url:  "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			verifyURL: func(signedURL string) string {
+				return strings.Replace(signedURL, "branch=main", "branch=v2", 1)
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.Equal(t, errSignatureInvalid, err)
+			},
+		},
+		{
+			name:    "expiry tampered with",
+			url:     "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			expires: now.Add(time.Hour),
+			verifyURL: func(signedURL string) string {
+				return strings.Replace(signedURL, "expires=", "expires=9", 1)
+			},
+			verifyTime: now,
+			assertions: func(err error) {
+				require.Equal(t, errSignatureInvalid, err)
+			},
+		},
+		{
+			name:    "expired",
+			url:     "/v1/github/checks/krancour/foo/badge.svg?branch=main",
+			expires: now.Add(time.Hour),
+			verifyURL: func(signedURL string) string {
+				return signedURL
+			},
+			verifyTime: now.Add(2 * time.Hour),
+			assertions: func(err error) {
+				require.Equal(t, errSignatureExpired, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			signedURL := SignBadgeURL(
+				testSigningKey,
+				newTestBadgeRequest(t, testCase.url),
+				testCase.expires,
+			)
+			testCase.assertions(
+				verifySignature(
+					testSigningKey,
+					newTestBadgeRequest(t, testCase.verifyURL(signedURL.String())),
+					testCase.verifyTime,
+				),
+			)
+		})
+	}
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	signedURL := SignBadgeURL(
+		testSigningKey,
+		newTestBadgeRequest(t, "/v1/github/checks/krancour/private/badge.svg"),
+		time.Time{},
+	).String()
+	expiredURL := SignBadgeURL(
+		testSigningKey,
+		newTestBadgeRequest(t, "/v1/github/checks/krancour/private/badge.svg"),
+		time.Now().Add(-time.Hour),
+	).String()
+	testCases := []struct {
+		name             string
+		url              string
+		refresh          bool
+		apiKey           bool
+		authorization    string
+		visibilityErr    error
+		expectedServed   bool
+		expectedLocation string
+		expectedLookups  int
+	}{
+		{
+			name:           "owner does not require signatures",
+			url:            "/v1/github/checks/brigadecore/private/badge.svg",
+			expectedServed: true,
+		},
+		{
+			name:           "refresh",
+			url:            "/v1/github/checks/krancour/private/badge.svg",
+			refresh:        true,
+			expectedServed: true,
+		},
+		{
+			name:           "valid signature",
+			url:            signedURL,
+			expectedServed: true,
+		},
+		{
+			name:            "public repository",
+			url:             "/v1/github/checks/krancour/public/badge.svg",
+			expectedServed:  true,
+			expectedLookups: 1,
+		},
+		{
+			name:             "private repository",
+			url:              "/v1/github/checks/Krancour/Private/badge.svg",
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+			expectedLookups:  1,
+		},
+		{
+			name:             "expired signature",
+			url:              expiredURL,
+			expectedLocation: badgeURL(NewErrBadge("signature expired")),
+			expectedLookups:  1,
+		},
+		{
+			name:           "authenticated by API key",
+			url:            "/v1/github/checks/krancour/private/badge.svg?token=t",
+			apiKey:         true,
+			expectedServed: true,
+		},
+		{
+			name:             "not authenticated by API key",
+			url:              "/v1/github/checks/krancour/private/badge.svg",
+			authorization:    "Bearer t",
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+			expectedLookups:  1,
+		},
+		{
+			name:             "error determining visibility",
+			url:              "/v1/github/checks/krancour/public/badge.svg",
+			visibilityErr:    errors.New("something went wrong"),
+			expectedLocation: badgeURL(NewErrBadge("forbidden")),
+			expectedLookups:  1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var served bool
+			var lookups int
+			testRouter := mux.NewRouter()
+			testRouter.Handle(
+				"/v1/github/checks/{owner}/{repo}/badge.svg",
+				NewSignatureVerifier(
+					SigningConfig{
+						Key:           testSigningKey,
+						Owners:        []string{"Krancour"},
+						VisibilityTTL: time.Minute,
+					},
+					&mockRepoVisibility{
+						IsPrivateFn: func(
+							_ context.Context,
+							_ string,
+							repo string,
+						) (bool, error) {
+							lookups++
+							return repo != "public", testCase.visibilityErr
+						},
+					},
+					http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+						served = true
+					}),
+				),
+			).Methods(http.MethodGet)
+			req := httptest.NewRequest(http.MethodGet, testCase.url, nil)
+			if testCase.authorization != "" {
+				req.Header.Set("Authorization", testCase.authorization)
+			}
+			if testCase.refresh {
+				req = req.WithContext(
+					contextWithRefresh(req.Context(), &refresh{}),
+				)
+			}
+			if testCase.apiKey {
+				req = req.WithContext(
+					contextWithAPIKey(req.Context(), &APIKey{Name: "ci"}),
+				)
+			}
+			// Make the same request twice to see that visibility is remembered
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				testRouter.ServeHTTP(rr, req)
+				require.Equal(t, testCase.expectedServed, served)
+				if testCase.expectedLocation != "" {
+					require.Equal(t, http.StatusSeeOther, rr.Code)
+					require.Equal(
+						t,
+						testCase.expectedLocation,
+						rr.Header().Get("Location"),
+					)
+				}
+			}
+			if testCase.visibilityErr != nil {
+				// Errors are not remembered
+				testCase.expectedLookups *= 2
+			}
+			require.Equal(t, testCase.expectedLookups, lookups)
+		})
+	}
+}
+
+func newTestBadgeRequest(t *testing.T, url string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	match := mux.RouteMatch{}
+	require.True(
+		t,
+		mux.NewRouter().
+			Handle(
+				"/v1/github/checks/{owner}/{repo}/badge.svg",
+				http.NotFoundHandler(),
+			).
+			Match(req, &match),
+	)
+	return mux.SetURLVars(req, match.Vars)
+}
+
+type mockRepoVisibility struct {
+	IsPrivateFn func(ctx context.Context, owner, repo string) (bool, error)
+}
+
+func (m *mockRepoVisibility) IsPrivate(
+	ctx context.Context,
+	owner string,
+	repo string,
+) (bool, error) {
+	return m.IsPrivateFn(ctx, owner, repo)
+}
@@ -0,0 +1,112 @@
+package badges
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// badgeStyles are the styles shields.io can render badges in.
+var badgeStyles = map[string]struct{}{
+	"flat":          {},
+	"flat-square":   {},
+	"plastic":       {},
+	"for-the-badge": {},
+	"social":        {},
+}
+
+// Theme represents configuration options governing how badges are rendered.
+// The zero value renders badges exactly as shields.io does by default.
+type Theme struct {
+	// Style optionally specifies the shields.io style badges are rendered in,
+	// for instance "flat-square" or "for-the-badge".
+	Style string
+	// Colors optionally maps the Color of a badge to the shields.io color it is
+	// rendered in instead, which may be any named color or hex code shields.io
+	// understands.
+	Colors map[Color]string
+}
+
+// Validate returns an error if the Theme specifies an unknown style or a
+// replacement for an unknown Color.
+func (t Theme) Validate() error {
+	if _, ok := badgeStyles[t.Style]; t.Style != "" && !ok {
+		return errors.Errorf(
+			"invalid badge style %q; valid styles are \"flat\", \"flat-square\", "+
+				"\"plastic\", \"for-the-badge\", and \"social\"",
+			t.Style,
+		)
+	}
+	for color, replacement := range t.Colors {
+		switch color {
+		case ColorGreen, ColorBlue, ColorYellow, ColorRed:
+		default:
+			return errors.Errorf(
+				"invalid badge color %q; valid colors are %q, %q, %q, and %q",
+				color,
+				ColorGreen,
+				ColorBlue,
+				ColorYellow,
+				ColorRed,
+			)
+		}
+		if replacement == "" {
+			return errors.Errorf("no replacement specified for color %q", color)
+		}
+	}
+	return nil
+}
+
+// url returns the URL of the provided badge rendered according to the Theme.
+func (t Theme) url(badge Badge) string {
+	color := string(badge.Color())
+	if replacement, ok := t.Colors[badge.Color()]; ok {
+		color = replacement
+	}
+	u := fmt.Sprintf(
+		"https://img.shields.io/static/v1?label=%s&message=%s&color=%s",
+		url.PathEscape(badge.Name()),
+		url.PathEscape(badge.Status()),
+		url.PathEscape(color),
+	)
+	if t.Style != "" {
+		u = fmt.Sprintf("%s&style=%s", u, url.PathEscape(t.Style))
+	}
+	return u
+}
+
+// themed is an http.Handler that makes a Theme available to another
+// http.Handler through the context of every request.
+type themed struct {
+	theme   Theme
+	handler http.Handler
+}
+
+// NewThemeHandler returns an http.Handler that delegates every request to the
+// provided http.Handler, which renders whatever badge it serves according to
+// the provided Theme.
+func NewThemeHandler(theme Theme, handler http.Handler) http.Handler {
+	return &themed{
+		theme:   theme,
+		handler: handler,
+	}
+}
+
+func (t *themed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.handler.ServeHTTP(
+		w,
+		r.WithContext(context.WithValue(r.Context(), themeContextKey{}, t.theme)),
+	)
+}
+
+type themeContextKey struct{}
+
+// redirectToBadge redirects the client to the provided badge, rendered
+// according to whatever Theme the request's context carries.
+func redirectToBadge(w http.ResponseWriter, r *http.Request, badge Badge) {
+	theme, _ := r.Context().Value(themeContextKey{}).(Theme)
+	http.Redirect(w, r, theme.url(badge), http.StatusSeeOther)
+}
@@ -0,0 +1,111 @@
+package badges
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// badgeURL returns the URL of the provided badge rendered according to the
+// default Theme.
+func badgeURL(badge Badge) string {
+	return Theme{}.url(badge)
+}
+
+func TestThemeValidate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		theme      Theme
+		assertions func(error)
+	}{
+		{
+			name: "invalid style",
+			theme: Theme{
+				Style: "foo",
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid badge style")
+			},
+		},
+		{
+			name: "invalid color",
+			theme: Theme{
+				Colors: map[Color]string{"purple": "orange"},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid badge color")
+			},
+		},
+		{
+			name: "missing replacement",
+			theme: Theme{
+				Colors: map[Color]string{ColorRed: ""},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no replacement specified")
+			},
+		},
+		{
+			name: "valid",
+			theme: Theme{
+				Style:  "flat-square",
+				Colors: map[Color]string{ColorGreen: "2ea44f"},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(testCase.theme.Validate())
+		})
+	}
+}
+
+func TestThemeHandler(t *testing.T) {
+	testBadge := NewCheckBadge("build", CheckStatusPassed)
+	testCases := []struct {
+		name             string
+		theme            Theme
+		expectedLocation string
+	}{
+		{
+			name:  "default theme",
+			theme: Theme{},
+			expectedLocation: "https://img.shields.io/static/v1?label=build&" +
+				"message=passed&color=brightgreen",
+		},
+		{
+			name: "custom theme",
+			theme: Theme{
+				Style:  "flat-square",
+				Colors: map[Color]string{ColorGreen: "2ea44f"},
+			},
+			expectedLocation: "https://img.shields.io/static/v1?label=build&" +
+				"message=passed&color=2ea44f&style=flat-square",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := NewThemeHandler(
+				testCase.theme,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					redirectToBadge(w, r, testBadge)
+				}),
+			)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(
+				rr,
+				httptest.NewRequest(http.MethodGet, "/", nil),
+			)
+			require.Equal(t, http.StatusSeeOther, rr.Code)
+			require.Equal(t, testCase.expectedLocation, rr.Header().Get("Location"))
+		})
+	}
+}
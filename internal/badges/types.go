@@ -88,6 +88,43 @@ func (c CheckStatus) String() string {
 	}
 }
 
+// MostSevereCheckStatus consolidates many statuses into a single status. To do
+// so, we start with a passed status, then we iterate over all statuses,
+// progressively degrading the result if/as worse outcomes are found. If no
+// statuses are provided, CheckStatusUnknown is returned.
+func MostSevereCheckStatus(statuses ...CheckStatus) CheckStatus {
+	if len(statuses) == 0 {
+		return CheckStatusUnknown
+	}
+	status := CheckStatusPassed
+	for _, newStatus := range statuses {
+		// The new status is the higher severity of the two. Lower value == higher
+		// severity-- that allows unknown (0) to be treated as most severe.
+		if newStatus < status {
+			status = newStatus
+		}
+	}
+	return status
+}
+
+// MarshalText implements encoding.TextMarshaler. CheckStatus values are
+// serialized using their textual representation so that serialized values
+// remain meaningful even if the numeric values of the constants change.
+func (c CheckStatus) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *CheckStatus) UnmarshalText(text []byte) error {
+	for status := CheckStatusUnknown; status <= CheckStatusPassed; status++ {
+		if status.String() == string(text) {
+			*c = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized check status %q", string(text))
+}
+
 // Color returns a Color constant that corresponds to the CheckStatus value.
 func (c CheckStatus) Color() Color {
 	switch c {
@@ -136,6 +173,34 @@ type CheckBadgeOptions struct {
 	// unspecified (0), the badge will reflect the combined results of multiple
 	// check suites.
 	GitHubAppID int
+	// CachedPages optionally specifies the pages of a CheckBadge previously
+	// obtained using otherwise identical options. When specified, each page is
+	// requested from GitHub conditionally and pages that GitHub reports as
+	// unchanged are reused instead of being re-fetched. Such requests do not
+	// count against the GitHub API rate limit.
+	CachedPages []CheckSuitePage
+}
+
+// CheckSuitePage summarizes a single page of check suites retrieved from
+// GitHub.
+type CheckSuitePage struct {
+	// ETag is the entity tag GitHub returned for the page.
+	ETag string `json:"etag,omitempty"`
+	// Count is the number of check suites on the page.
+	Count int `json:"count"`
+	// Status is the combined status of all check suites on the page.
+	Status CheckStatus `json:"status"`
+	// HasNext indicates whether GitHub reported that a subsequent page exists.
+	HasNext bool `json:"hasNext,omitempty"`
+}
+
+// final returns a bool indicating whether the page's status is severe enough
+// that the combined status of all pages is a foregone conclusion. Strictly,
+// only CheckStatusUnknown is guaranteed to be final, but a failure is as bad
+// as news gets and it isn't worth waiting on other pages only to learn that
+// the status is unknown instead.
+func (c CheckSuitePage) final() bool {
+	return c.Count > 0 && c.Status <= CheckStatusFailed
 }
 
 // CheckBadge is an implementation of Badge that represents the results of a
@@ -144,6 +209,20 @@ type CheckBadgeOptions struct {
 type CheckBadge struct {
 	name   string
 	status CheckStatus
+	pages  []CheckSuitePage
+}
+
+// NewCheckBadge returns a CheckBadge with the specified name and status. This
+// permits providers other than GitHub to express their results in terms of a
+// CheckStatus. If the name is empty, it defaults to "build".
+func NewCheckBadge(name string, status CheckStatus) CheckBadge {
+	if name == "" {
+		name = "build"
+	}
+	return CheckBadge{
+		name:   name,
+		status: status,
+	}
 }
 
 func (c CheckBadge) Name() string {
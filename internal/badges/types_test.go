@@ -0,0 +1,66 @@
+package badges
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCheckBadge(t *testing.T) {
+	require.Equal(
+		t,
+		CheckBadge{name: "tests", status: CheckStatusPassed},
+		NewCheckBadge("tests", CheckStatusPassed),
+	)
+	// The name defaults to "build"
+	require.Equal(
+		t,
+		CheckBadge{name: "build", status: CheckStatusPassed},
+		NewCheckBadge("", CheckStatusPassed),
+	)
+}
+
+func TestMostSevereCheckStatus(t *testing.T) {
+	testCases := []struct {
+		name           string
+		statuses       []CheckStatus
+		expectedStatus CheckStatus
+	}{
+		{
+			name:           "no statuses",
+			expectedStatus: CheckStatusUnknown,
+		},
+		{
+			name:           "one status",
+			statuses:       []CheckStatus{CheckStatusQueued},
+			expectedStatus: CheckStatusQueued,
+		},
+		{
+			name: "many statuses",
+			statuses: []CheckStatus{
+				CheckStatusPassed,
+				CheckStatusFailed,
+				CheckStatusInProgress,
+			},
+			expectedStatus: CheckStatusFailed,
+		},
+		{
+			name: "unknown trumps all",
+			statuses: []CheckStatus{
+				CheckStatusFailed,
+				CheckStatusUnknown,
+				CheckStatusPassed,
+			},
+			expectedStatus: CheckStatusUnknown,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.expectedStatus,
+				MostSevereCheckStatus(testCase.statuses...),
+			)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package badges
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxUpstreamResponseSize is the largest response body GetJSON will read.
+const maxUpstreamResponseSize = 1 << 20
+
+// Errors that Providers may return, possibly wrapped, so that the handler can
+// serve an appropriate badge and cache it as it would for GitHub
+var (
+	// ErrRepoNotFound indicates that the repository for which a badge was
+	// requested does not exist or is not visible to Badgr.
+	ErrRepoNotFound = errors.New("repository not found")
+	// ErrBranchNotFound indicates that the branch for which a badge was
+	// requested does not exist.
+	ErrBranchNotFound = errors.New("branch not found")
+)
+
+// UpstreamError is returned, possibly wrapped, by GetJSON when a provider's
+// backend responds with a status other than 200 OK. The handler decides what
+// badge to serve based upon the status, as it does for errors from GitHub.
+type UpstreamError struct {
+	// URL is the URL that was requested.
+	URL string
+	// StatusCode is the status with which the backend responded.
+	StatusCode int
+}
+
+func (u *UpstreamError) Error() string {
+	return fmt.Sprintf("%s responded with status %d", u.URL, u.StatusCode)
+}
+
+// GetJSON sends the provided request, which Providers may use to query their
+// backends, using the provided http.Client and unmarshals the JSON response
+// body into v. Each request is traced. If the backend responds with a status
+// other than 200 OK, an *UpstreamError is returned.
+func GetJSON(
+	ctx context.Context,
+	client *http.Client,
+	req *http.Request,
+	v interface{},
+) (err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		fmt.Sprintf("%s %s", req.Method, req.URL.Host),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.Redacted()),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "error requesting %s", req.URL.Redacted())
+	}
+	defer res.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	if res.StatusCode != http.StatusOK {
+		return &UpstreamError{
+			URL:        req.URL.Redacted(),
+			StatusCode: res.StatusCode,
+		}
+	}
+	if err = json.NewDecoder(
+		io.LimitReader(res.Body, maxUpstreamResponseSize),
+	).Decode(v); err != nil {
+		return errors.Wrapf(
+			err,
+			"error unmarshaling response from %s",
+			req.URL.Redacted(),
+		)
+	}
+	return nil
+}
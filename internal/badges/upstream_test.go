@@ -0,0 +1,66 @@
+package badges
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetJSON(t *testing.T) {
+	testCases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		assertions func(map[string]string, error)
+	}{
+		{
+			name: "unsuccessful status",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			assertions: func(_ map[string]string, err error) {
+				require.Error(t, err)
+				upstreamErr := &UpstreamError{}
+				require.ErrorAs(t, err, &upstreamErr)
+				require.Equal(t, http.StatusNotFound, upstreamErr.StatusCode)
+			},
+		},
+		{
+			name: "malformed response",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(w, "{")
+			},
+			assertions: func(_ map[string]string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error unmarshaling response")
+			},
+		},
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "application/json", r.Header.Get("Accept"))
+				require.Equal(t, "bar", r.Header.Get("X-Foo"))
+				fmt.Fprint(w, `{"foo":"bar"}`)
+			},
+			assertions: func(v map[string]string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, map[string]string{"foo": "bar"}, v)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Foo", "bar")
+			v := map[string]string{}
+			err = GetJSON(context.Background(), server.Client(), req, &v)
+			testCase.assertions(v, err)
+		})
+	}
+}
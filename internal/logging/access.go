@@ -0,0 +1,196 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogConfig represents configuration options for the access log.
+type AccessLogConfig struct {
+	// SampleRate is the fraction, between 0 and 1, of requests that should be
+	// logged. Requests that result in a server error are always logged.
+	SampleRate float64
+	// LogHealthz specifies whether requests to the /healthz and /readyz
+	// endpoints should be logged. These are typically numerous and
+	// uninteresting.
+	LogHealthz bool
+	// TrustedProxies specifies proxies whose X-Forwarded-For headers can be
+	// trusted to identify the client.
+	TrustedProxies []net.IPNet
+}
+
+// accessLog is an http.Handler that writes an entry to the access log for each
+// request it delegates to another http.Handler.
+type accessLog struct {
+	config  AccessLogConfig
+	handler http.Handler
+	// randFn is overridable for testing purposes
+	randFn func() float64
+}
+
+// AccessLog returns an http.Handler that delegates to the provided
+// http.Handler and writes an entry to the access log for each request using
+// the logger carried by the request's context.
+func AccessLog(handler http.Handler, config AccessLogConfig) http.Handler {
+	return &accessLog{
+		config:  config,
+		handler: handler,
+		randFn:  rand.Float64,
+	}
+}
+
+func (a *accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.URL.Path == "/healthz" || r.URL.Path == "/readyz") &&
+		!a.config.LogHealthz {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+	start := time.Now()
+	attrs := &accessLogAttrs{}
+	clientIP := a.clientIP(r)
+	ctx := context.WithValue(r.Context(), accessLogAttrsKey{}, attrs)
+	ctx = context.WithValue(ctx, clientIPKey{}, clientIP)
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	a.handler.ServeHTTP(rw, r.WithContext(ctx))
+	if rw.status < http.StatusInternalServerError &&
+		a.randFn() >= a.config.SampleRate {
+		return
+	}
+	args := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", rw.status,
+	}
+	if location := rw.Header().Get("Location"); location != "" {
+		args = append(args, "location", location)
+	} else {
+		args = append(args, "bytes", rw.bytes)
+	}
+	args = append(
+		args,
+		"latencySeconds", time.Since(start).Seconds(),
+		"clientIP", clientIP,
+		"userAgent", r.UserAgent(),
+	)
+	for _, attr := range attrs.get() {
+		args = append(args, attr)
+	}
+	LoggerFromContext(r.Context()).Info("access", args...)
+}
+
+// clientIP determines the IP address of the client that made the request. If
+// the request was received from a trusted proxy, the X-Forwarded-For header is
+// consulted, from right to left, for the first address that does not belong to
+// a trusted proxy.
+func (a *accessLog) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !a.trusted(ip) {
+		return ip
+	}
+	forwardedFor := strings.Split(
+		strings.Join(r.Header.Values("X-Forwarded-For"), ","),
+		",",
+	)
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedIP := strings.TrimSpace(forwardedFor[i])
+		if forwardedIP == "" {
+			continue
+		}
+		ip = forwardedIP
+		if !a.trusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// trusted returns a bool indicating whether the provided IP address belongs to
+// a trusted proxy.
+func (a *accessLog) trusted(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, trustedProxy := range a.config.TrustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPKey struct{}
+
+// ClientIPFromContext returns the IP address, as determined by the access log,
+// of the client that made the request whose context is provided. An empty
+// string is returned if the context does not belong to a request that is being
+// logged.
+func ClientIPFromContext(ctx context.Context) string {
+	clientIP, _ := ctx.Value(clientIPKey{}).(string)
+	return clientIP
+}
+
+type accessLogAttrsKey struct{}
+
+// accessLogAttrs accumulates attributes that handlers wish to add to a
+// request's access log entry. It is safe for concurrent use.
+type accessLogAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (a *accessLogAttrs) add(attrs ...slog.Attr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attrs = append(a.attrs, attrs...)
+}
+
+func (a *accessLogAttrs) get() []slog.Attr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.attrs
+}
+
+// AddAccessLogAttrs adds the provided attributes to the access log entry for
+// the request whose context is provided. This permits handlers to record
+// information, such as whether a result was served from cache, that the access
+// log could not otherwise know. If the context does not belong to a request
+// that is being logged, this is a no-op.
+func AddAccessLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if a, ok := ctx.Value(accessLogAttrsKey{}).(*accessLogAttrs); ok {
+		a.add(attrs...)
+	}
+}
+
+// responseWriter is an http.ResponseWriter that captures the status code and
+// the number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *responseWriter) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseWriter) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
@@ -0,0 +1,196 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     AccessLogConfig
+		random     float64
+		handler    http.HandlerFunc
+		setup      func(*http.Request)
+		assertions func(entry map[string]interface{})
+	}{
+		{
+			name:   "redirect",
+			config: AccessLogConfig{SampleRate: 1},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				AddAccessLogAttrs(r.Context(), slog.String("cache", "warm"))
+				// The client IP should be available to handlers
+				require.Equal(t, "192.0.2.1", ClientIPFromContext(r.Context()))
+				http.Redirect(w, r, "https://example.com", http.StatusSeeOther)
+			},
+			setup: func(r *http.Request) {
+				r.Header.Set("User-Agent", "test-agent")
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.NotNil(t, entry)
+				require.Equal(t, http.MethodGet, entry["method"])
+				require.Equal(t, "/foo", entry["path"])
+				require.Equal(t, float64(http.StatusSeeOther), entry["status"])
+				require.Equal(t, "https://example.com", entry["location"])
+				require.NotContains(t, entry, "bytes")
+				require.Contains(t, entry, "latencySeconds")
+				require.Equal(t, "192.0.2.1", entry["clientIP"])
+				require.Equal(t, "test-agent", entry["userAgent"])
+				require.Equal(t, "warm", entry["cache"])
+			},
+		},
+		{
+			name:   "content",
+			config: AccessLogConfig{SampleRate: 1},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.NotNil(t, entry)
+				require.Equal(t, float64(http.StatusOK), entry["status"])
+				require.Equal(t, float64(5), entry["bytes"])
+				require.NotContains(t, entry, "location")
+			},
+		},
+		{
+			name:   "not sampled",
+			config: AccessLogConfig{SampleRate: 0.5},
+			random: 0.7,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.Nil(t, entry)
+			},
+		},
+		{
+			name:   "sampled",
+			config: AccessLogConfig{SampleRate: 0.5},
+			random: 0.3,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.NotNil(t, entry)
+			},
+		},
+		{
+			name:   "server error not sampled but logged anyway",
+			config: AccessLogConfig{SampleRate: 0},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.NotNil(t, entry)
+				require.Equal(
+					t,
+					float64(http.StatusInternalServerError),
+					entry["status"],
+				)
+			},
+		},
+		{
+			name:   "healthz not logged",
+			config: AccessLogConfig{SampleRate: 1},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			setup: func(r *http.Request) {
+				r.URL.Path = "/healthz"
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.Nil(t, entry)
+			},
+		},
+		{
+			name:   "healthz logged",
+			config: AccessLogConfig{SampleRate: 1, LogHealthz: true},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			setup: func(r *http.Request) {
+				r.URL.Path = "/healthz"
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.NotNil(t, entry)
+				require.Equal(t, "/healthz", entry["path"])
+			},
+		},
+		{
+			name:   "X-Forwarded-For from untrusted client ignored",
+			config: AccessLogConfig{SampleRate: 1},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Forwarded-For", "203.0.113.1")
+			},
+			assertions: func(entry map[string]interface{}) {
+				require.Equal(t, "192.0.2.1", entry["clientIP"])
+			},
+		},
+		{
+			name: "X-Forwarded-For from trusted proxies honored",
+			config: AccessLogConfig{
+				SampleRate:     1,
+				TrustedProxies: []net.IPNet{mustParseCIDR(t, "192.0.2.0/24")},
+			},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			setup: func(r *http.Request) {
+				r.Header.Add("X-Forwarded-For", "198.51.100.1, 203.0.113.1")
+				r.Header.Add("X-Forwarded-For", "192.0.2.2")
+			},
+			assertions: func(entry map[string]interface{}) {
+				// 198.51.100.1 could have been forged by 203.0.113.1, which is not
+				// a trusted proxy
+				require.Equal(t, "203.0.113.1", entry["clientIP"])
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			handler := &accessLog{
+				config:  testCase.config,
+				handler: testCase.handler,
+				randFn: func() float64 {
+					return testCase.random
+				},
+			}
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.RemoteAddr = "192.0.2.1:12345"
+			if testCase.setup != nil {
+				testCase.setup(req)
+			}
+			req = req.WithContext(
+				ContextWithLogger(req.Context(), newLogger(Config{}, logs)),
+			)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			var entry map[string]interface{}
+			if logs.Len() > 0 {
+				require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			}
+			testCase.assertions(entry)
+		})
+	}
+}
+
+func mustParseCIDR(t *testing.T, cidr string) net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return *ipNet
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	require.Empty(t, ClientIPFromContext(context.Background()))
+}
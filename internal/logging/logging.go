@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// RequestIDHeader is the name of the HTTP header used to propagate a request
+// ID.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID that will be
+// accepted from a client. Longer request IDs are replaced.
+const maxRequestIDLength = 128
+
+// Config represents configuration options for logging.
+type Config struct {
+	// Level is the minimum level of messages that should be logged.
+	Level slog.Level
+}
+
+// NewLogger returns a logger that writes structured JSON log messages to
+// stdout.
+func NewLogger(config Config) *slog.Logger {
+	return newLogger(config, os.Stdout)
+}
+
+func newLogger(config Config, out io.Writer) *slog.Logger {
+	return slog.New(
+		slog.NewJSONHandler(out, &slog.HandlerOptions{Level: config.Level}),
+	)
+}
+
+type loggerContextKey struct{}
+
+// ContextWithLogger returns a copy of the provided context that carries the
+// provided logger.
+func ContextWithLogger(
+	ctx context.Context,
+	logger *slog.Logger,
+) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the logger carried by the provided context. If the
+// context does not carry a logger, the default logger is returned.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns an http.Handler that assigns an ID to every request before
+// delegating to the provided http.Handler. An ID supplied by the client using
+// the X-Request-ID header is propagated. Otherwise, a new one is generated.
+// Either way, the ID is returned to the client using the same header and is
+// attached to every message logged using the logger carried by the request's
+// context.
+func RequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		logger := LoggerFromContext(r.Context()).With("requestID", requestID)
+		handler.ServeHTTP(
+			w,
+			r.WithContext(ContextWithLogger(r.Context(), logger)),
+		)
+	})
+}
+
+// validRequestID returns a bool indicating whether a request ID supplied by a
+// client is acceptable. To keep log output sane, acceptable IDs are non-empty,
+// reasonably short, and composed only of printable ASCII characters.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(requestID) {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a new, random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := newLogger(Config{Level: slog.LevelWarn}, buf)
+	logger.Info("ignored")
+	logger.Warn("something happened", "owner", "brigadecore")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "WARN", entry["level"])
+	require.Equal(t, "something happened", entry["msg"])
+	require.Equal(t, "brigadecore", entry["owner"])
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	require.Same(t, slog.Default(), LoggerFromContext(context.Background()))
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+	require.Same(
+		t,
+		logger,
+		LoggerFromContext(ContextWithLogger(context.Background(), logger)),
+	)
+}
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		name       string
+		requestID  string
+		assertions func(responseID string, loggedID string)
+	}{
+		{
+			name: "no request ID supplied",
+			assertions: func(responseID string, loggedID string) {
+				require.Len(t, responseID, 32)
+				require.Equal(t, responseID, loggedID)
+			},
+		},
+		{
+			name:      "invalid request ID supplied",
+			requestID: "foo bar",
+			assertions: func(responseID string, loggedID string) {
+				require.NotEqual(t, "foo bar", responseID)
+				require.Len(t, responseID, 32)
+				require.Equal(t, responseID, loggedID)
+			},
+		},
+		{
+			name:      "request ID too long",
+			requestID: strings.Repeat("a", maxRequestIDLength+1),
+			assertions: func(responseID string, loggedID string) {
+				require.Len(t, responseID, 32)
+				require.Equal(t, responseID, loggedID)
+			},
+		},
+		{
+			name:      "valid request ID supplied",
+			requestID: "abc-123",
+			assertions: func(responseID string, loggedID string) {
+				require.Equal(t, "abc-123", responseID)
+				require.Equal(t, "abc-123", loggedID)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			logger := newLogger(Config{}, buf)
+			handler := RequestID(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					LoggerFromContext(r.Context()).Info("serving")
+				}),
+			)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if testCase.requestID != "" {
+				req.Header.Set(RequestIDHeader, testCase.requestID)
+			}
+			req = req.WithContext(ContextWithLogger(req.Context(), logger))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			entry := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+			loggedID, _ := entry["requestID"].(string)
+			testCase.assertions(rr.Header().Get(RequestIDHeader), loggedID)
+		})
+	}
+}
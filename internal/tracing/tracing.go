@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/brigadecore/brigade-foundations/version"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const (
+	// ExporterNone indicates that spans should not be exported.
+	ExporterNone = "none"
+	// ExporterOTLP indicates that spans should be exported over OTLP/HTTP.
+	ExporterOTLP = "otlp"
+	// ExporterStdout indicates that spans should be written to stdout. This is
+	// mainly useful for testing and debugging.
+	ExporterStdout = "stdout"
+)
+
+// Config represents configuration options for tracing.
+type Config struct {
+	// Exporter specifies where spans should be exported to. Valid values are
+	// ExporterNone, ExporterOTLP, and ExporterStdout.
+	Exporter string
+	// OTLPEndpoint is the host and port of the OTLP/HTTP collector spans should
+	// be exported to. If unspecified, the OpenTelemetry SDK's defaults,
+	// including its standard environment variables, apply.
+	OTLPEndpoint string
+	// OTLPInsecure specifies whether spans should be exported to the OTLP/HTTP
+	// collector without TLS.
+	OTLPInsecure bool
+}
+
+// Setup configures the global OpenTelemetry tracer provider according to the
+// provided Config and configures the global propagator to propagate W3C trace
+// context. It returns a function that flushes any buffered spans and releases
+// resources. That function should be called before the program exits.
+func Setup(
+	ctx context.Context,
+	config Config,
+) (func(context.Context) error, error) {
+	return setup(ctx, config, os.Stdout)
+}
+
+func setup(
+	ctx context.Context,
+	config Config,
+	stdout io.Writer,
+) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(
+		propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		),
+	)
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	default:
+		return nil, errors.Errorf("unrecognized trace exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"error initializing %q trace exporter",
+			config.Exporter,
+		)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(
+			resource.NewWithAttributes(
+				semconv.SchemaURL,
+				semconv.ServiceName("badgr"),
+				semconv.ServiceVersion(version.Version()),
+			),
+		),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider.Shutdown, nil
+}
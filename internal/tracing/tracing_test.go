@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     Config
+		assertions func(shutdown func(context.Context) error, err error)
+	}{
+		{
+			name:   "unrecognized exporter",
+			config: Config{Exporter: "bogus"},
+			assertions: func(_ func(context.Context) error, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unrecognized trace exporter")
+			},
+		},
+		{
+			name:   "no exporter",
+			config: Config{Exporter: ExporterNone},
+			assertions: func(shutdown func(context.Context) error, err error) {
+				require.NoError(t, err)
+				require.NoError(t, shutdown(context.Background()))
+			},
+		},
+		{
+			name: "otlp exporter",
+			config: Config{
+				Exporter:     ExporterOTLP,
+				OTLPEndpoint: "localhost:4318",
+				OTLPInsecure: true,
+			},
+			assertions: func(shutdown func(context.Context) error, err error) {
+				require.NoError(t, err)
+				// Nothing was exported, so this shouldn't need to reach a collector
+				require.NoError(t, shutdown(context.Background()))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(
+				setup(context.Background(), testCase.config, &bytes.Buffer{}),
+			)
+		})
+	}
+}
+
+func TestSetupStdoutExporter(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	shutdown, err := setup(
+		context.Background(),
+		Config{Exporter: ExporterStdout},
+		stdout,
+	)
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	require.Contains(t, stdout.String(), "test-span")
+	require.Contains(t, stdout.String(), "badgr")
+}
@@ -1,52 +1,190 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/brigadecore/badgr/internal/badges"
 	"github.com/brigadecore/badgr/internal/badges/redis"
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/brigadecore/badgr/internal/tracing"
 	libHTTP "github.com/brigadecore/brigade-foundations/http"
 	"github.com/brigadecore/brigade-foundations/signals"
 	"github.com/brigadecore/brigade-foundations/version"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// aliasRoute is the route of badges requested by the name of an alias.
+const aliasRoute = "/v1/alias/{name}/badge.svg"
+
 func main() {
-	log.Printf(
-		"Starting Badgr -- version %s -- commit %s",
-		version.Version(),
-		version.Commit(),
-	)
+	// Settings are read from the config file, if any, with environment
+	// variables taking precedence
+	s := settings{}
+	var configFile *configFile
+	if filename := os.Getenv("CONFIG_FILE"); filename != "" {
+		configFile = newConfigFile(filename)
+		if _, err := configFile.load(false, func(fileSettings settings) error {
+			s = fileSettings
+			return nil
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		if err := sign(os.Args[2:], s, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	cacheConfig, err := redisCacheConfig()
+	loggingConfig, err := loggingConfig(s)
 	if err != nil {
 		log.Fatal(err)
 	}
+	slog.SetDefault(logging.NewLogger(loggingConfig))
 
-	handler := badges.NewHandler(
-		badges.NewService(),
-		redis.NewCache(cacheConfig),
+	slog.Info(
+		"Starting Badgr",
+		"version", version.Version(),
+		"commit", version.Commit(),
 	)
 
+	ctx := signals.Context()
+
+	startup, err := loadStartupConfig(s)
+	if err != nil {
+		fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, startup.tracing)
+	if err != nil {
+		fatal(err)
+	}
+	defer func() {
+		// The signals context has been canceled by the time we get here
+		shutdownCtx, cancel :=
+			context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("error shutting down tracing", "error", err)
+		}
+	}()
+
+	rateLimits := badges.NewRateLimits()
+	breaker := badges.NewCircuitBreaker(startup.circuitBreaker)
+	cache := redis.NewCache(startup.cache)
+	apiKeys := redis.NewAPIKeyStore(startup.cache)
+
 	router := mux.NewRouter()
 	router.StrictSlash(true)
-	router.HandleFunc(
-		"/v1/github/checks/{owner}/{repo}/badge.svg",
-		handler.ServeHTTP,
-	).Methods(http.MethodGet)
-	router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
 
-	serverConfig, err := serverConfig()
+	builder := &badgeHandlerBuilder{
+		rateLimits: rateLimits,
+		breaker:    breaker,
+		cache:      cache,
+		limiter:    redis.NewLimiter(startup.cache),
+		apiKeys:    apiKeys,
+	}
+
+	// Popular badges are refreshed by replaying requests for them through the
+	// router.
+	if startup.prewarm.TopN > 0 {
+		builder.prewarmer = badges.NewPrewarmer(
+			startup.prewarm,
+			redis.NewPopularity(startup.cache, startup.popularity),
+			cache,
+			rateLimits,
+			router,
+		)
+		go builder.prewarmer.Run(ctx)
+	}
+
+	badgeHandler := &reloadableHandler{}
+	handler, err := builder.build(s)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	badgeHandler.set(handler)
+
+	readinessHandler := &reloadableHandler{}
+	handler, err = builder.readiness(s)
+	if err != nil {
+		fatal(err)
+	}
+	readinessHandler.set(handler)
+
+	if configFile != nil {
+		go configFile.watch(ctx, func(s settings) error {
+			return reloadConfig(
+				s,
+				startup,
+				builder,
+				badgeHandler,
+				readinessHandler,
+			)
+		})
 	}
 
-	log.Println(
+	// Badges from every provider, and badges requested by alias, are routed by
+	// the badge handler itself, since the routes may change when configuration
+	// is reloaded
+	router.PathPrefix("/v1/").Handler(badgeHandler)
+	router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
+	router.Handle("/readyz", readinessHandler).Methods(http.MethodGet)
+
+	// Metrics and debug endpoints are served by the main server unless a
+	// separate port has been configured for them.
+	metricsRouter := router
+	if startup.metricsServer.Port != 0 {
+		metricsRouter = mux.NewRouter()
+		metricsRouter.StrictSlash(true)
+		go func() {
+			slog.Info(
+				"metrics server stopped",
+				"error",
+				libHTTP.NewServer(
+					metricsRouter,
+					&startup.metricsServer,
+				).ListenAndServe(ctx),
+			)
+		}()
+	}
+	metricsRouter.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	metricsRouter.Handle(
+		"/debug/github/rate-limit",
+		rateLimits,
+	).Methods(http.MethodGet)
+	if startup.apiKeys.AdminToken != "" {
+		apiKeysHandler := badges.NewAPIKeysHandler(startup.apiKeys, apiKeys)
+		metricsRouter.Handle(
+			"/admin/api-keys",
+			apiKeysHandler,
+		).Methods(http.MethodPost)
+		metricsRouter.Handle(
+			"/admin/api-keys/{name}",
+			apiKeysHandler,
+		).Methods(http.MethodDelete)
+	}
+
+	slog.Info(
+		"server stopped",
+		"error",
 		libHTTP.NewServer(
-			router,
-			&serverConfig,
-		).ListenAndServe(signals.Context()),
+			logging.RequestID(logging.AccessLog(router, startup.accessLog)),
+			&startup.server,
+		).ListenAndServe(ctx),
 	)
+}
 
+// fatal logs the provided error and exits.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
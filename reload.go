@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	// Providers other than GitHub register themselves with every Registry
+	_ "github.com/brigadecore/badgr/internal/badges/brigade"
+	_ "github.com/brigadecore/badgr/internal/badges/gitea"
+	_ "github.com/brigadecore/badgr/internal/badges/gitlab"
+	_ "github.com/brigadecore/badgr/internal/badges/jenkins"
+	"github.com/brigadecore/badgr/internal/logging"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// reloadableHandler is an http.Handler that delegates to another http.Handler
+// that may be replaced at any time. Requests in flight when the handler is
+// replaced are completed by the handler that began serving them.
+type reloadableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+// set replaces the handler that requests are delegated to.
+func (h *reloadableHandler) set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+// badgeHandlerBuilder builds the handlers that serve badges, and that report
+// readiness to serve them, from long-lived components and whatever
+// configuration is current, so that the handlers can be rebuilt whenever
+// configuration is reloaded.
+type badgeHandlerBuilder struct {
+	rateLimits *badges.RateLimits
+	breaker    *badges.CircuitBreaker
+	cache      badges.Cache
+	limiter    badges.Limiter
+	apiKeys    badges.APIKeyStore
+	// prewarmer, if non-nil, tracks requests for badges
+	prewarmer *badges.Prewarmer
+}
+
+// registry returns a new Registry of every badge provider, configured from the
+// provided settings.
+func (b *badgeHandlerBuilder) registry(s settings) (*badges.Registry, error) {
+	serviceConfig, err := serviceConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	registry := badges.NewRegistry()
+	if err = registry.Register(
+		badges.NewGitHubChecksProvider(
+			badges.NewService(serviceConfig, b.rateLimits, b.breaker),
+			badges.NewRepoVisibility(serviceConfig, b.rateLimits),
+		),
+	); err != nil {
+		return nil, err
+	}
+	if err = registry.RegisterConfigured(s); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// build returns a new handler that routes requests for badges from every
+// provider, and for badges requested by alias, configured from the provided
+// settings.
+func (b *badgeHandlerBuilder) build(s settings) (http.Handler, error) {
+	registry, err := b.registry(s)
+	if err != nil {
+		return nil, err
+	}
+	accessConfig, err := accessConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	signingConfig, err := signingConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := configuredAPIKeys(s)
+	if err != nil {
+		return nil, err
+	}
+	clientRateLimitConfig, err := clientRateLimitConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	aliasConfig, err := aliasConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	handlerConfig, err := handlerConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	themeConfig, err := themeConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	for name, target := range aliasConfig.Aliases {
+		req := &http.Request{Method: http.MethodGet, URL: target}
+		if _, ok := registry.Match(req); !ok {
+			return nil, errors.Errorf(
+				"alias %q stands for %q, which is not a badge URL",
+				name,
+				target,
+			)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	for _, provider := range registry.Providers() {
+		handler := badges.NewHandler(
+			handlerConfig,
+			badges.NewClientRateLimitedProvider(
+				clientRateLimitConfig,
+				provider,
+				b.limiter,
+			),
+			b.cache,
+		)
+
+		if b.prewarmer != nil {
+			handler = b.prewarmer.Track(handler)
+		}
+
+		// Signatures are checked after access control so that forbidden requests
+		// never prompt us to ask whether a repository is private. Signatures can
+		// only be required by providers that can tell us that.
+		visibility, ok := provider.(badges.RepoVisibility)
+		if ok && len(signingConfig.Owners) > 0 {
+			handler = badges.NewSignatureVerifier(
+				signingConfig,
+				visibility,
+				handler,
+			)
+		}
+
+		// API keys are checked before signatures so that a key whose scopes
+		// include a repository can stand in for a signature, but after access
+		// control, so that no key can grant access to a forbidden repository
+		handler = badges.NewAPIKeyAuthenticator(apiKeys, b.apiKeys, handler)
+
+		// Access control comes first so that forbidden requests touch neither
+		// the cache nor the provider. Everything from here on learns what each
+		// badge is about from the provider itself.
+		router.Handle(
+			provider.Route(),
+			badges.NewSubjectHandler(
+				provider,
+				badges.NewAccessControl(accessConfig, handler),
+			),
+		).Methods(http.MethodGet)
+	}
+
+	// Badges requested by alias are served by replaying the request for the
+	// badge the alias stands for through the same router
+	router.Handle(
+		aliasRoute,
+		badges.NewAliasHandler(aliasConfig, router),
+	).Methods(http.MethodGet)
+
+	// Every badge, including those served in place of a refused request, is
+	// rendered in the configured theme
+	router.Use(func(handler http.Handler) http.Handler {
+		return badges.NewThemeHandler(themeConfig, handler)
+	})
+
+	return router, nil
+}
+
+// readiness returns a new handler that reports whether Badgr is ready to serve
+// badges, configured from the provided settings.
+func (b *badgeHandlerBuilder) readiness(s settings) (http.Handler, error) {
+	readinessConfig, err := readinessConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	serviceConfig, err := serviceConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	return badges.NewReadinessHandler(
+		readinessConfig,
+		b.cache,
+		badges.NewService(serviceConfig, b.rateLimits, b.breaker),
+		b.breaker,
+	), nil
+}
+
+// reloadConfig applies reloaded settings. The log level and the settings
+// affecting how badges are served, and how readiness to serve them is
+// determined, take effect immediately. Other settings configure components
+// created only once, when Badgr starts, so the reloaded settings are refused in
+// their entirety if they change any of those from the provided startupConfig.
+// Reloaded settings are likewise refused in their entirety if they are invalid,
+// leaving the previous configuration in effect.
+func reloadConfig(
+	s settings,
+	startup startupConfig,
+	builder *badgeHandlerBuilder,
+	badgeHandler *reloadableHandler,
+	readinessHandler *reloadableHandler,
+) error {
+	reloadedStartup, err := loadStartupConfig(s)
+	if err != nil {
+		return err
+	}
+	if changes := startup.changes(reloadedStartup); len(changes) > 0 {
+		return errors.Errorf(
+			"changes to the following settings require a restart: %s",
+			strings.Join(changes, ", "),
+		)
+	}
+	loggingConfig, err := loggingConfig(s)
+	if err != nil {
+		return err
+	}
+	handler, err := builder.build(s)
+	if err != nil {
+		return err
+	}
+	readiness, err := builder.readiness(s)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logging.NewLogger(loggingConfig))
+	badgeHandler.set(handler)
+	readinessHandler.set(readiness)
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brigadecore/badgr/internal/badges"
+	"github.com/brigadecore/badgr/internal/badges/brigade"
+	"github.com/brigadecore/badgr/internal/badges/gitea"
+	"github.com/brigadecore/badgr/internal/badges/gitlab"
+	"github.com/brigadecore/badgr/internal/badges/jenkins"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadableHandler(t *testing.T) {
+	h := &reloadableHandler{}
+	h.set(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	h.set(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusTeapot, rr.Code)
+}
+
+func TestBadgeHandlerBuilderRegistry(t *testing.T) {
+	builder := &badgeHandlerBuilder{
+		rateLimits: badges.NewRateLimits(),
+		breaker:    badges.NewCircuitBreaker(badges.CircuitBreakerConfig{}),
+	}
+
+	registry, err := builder.registry(settings{})
+	require.NoError(t, err)
+	require.Len(t, registry.Providers(), 1)
+	require.Equal(
+		t,
+		badges.GitHubChecksRoute,
+		registry.Providers()[0].Route(),
+	)
+
+	// Other providers follow GitHub in order of name
+	t.Setenv("BRIGADE_API_ADDRESS", "https://brigade.example.com")
+	registry, err = builder.registry(settings{})
+	require.NoError(t, err)
+	require.Len(t, registry.Providers(), 2)
+	require.Equal(t, brigade.Route, registry.Providers()[1].Route())
+
+	t.Setenv("GITEA_BASE_URL", "https://gitea.example.com")
+	registry, err = builder.registry(settings{})
+	require.NoError(t, err)
+	require.Len(t, registry.Providers(), 3)
+	require.Equal(t, gitea.Route, registry.Providers()[2].Route())
+
+	t.Setenv("GITLAB_BASE_URL", "https://gitlab.example.com")
+	registry, err = builder.registry(settings{})
+	require.NoError(t, err)
+	require.Len(t, registry.Providers(), 4)
+	require.Equal(t, gitlab.Route, registry.Providers()[3].Route())
+
+	t.Setenv("JENKINS_BASE_URL", "https://jenkins.example.com")
+	registry, err = builder.registry(settings{})
+	require.NoError(t, err)
+	require.Len(t, registry.Providers(), 5)
+	require.Equal(t, jenkins.Route, registry.Providers()[4].Route())
+
+	t.Setenv("JENKINS_BASE_URL", "jenkins.example.com")
+	_, err = builder.registry(settings{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `error configuring provider "jenkins"`)
+	t.Setenv("JENKINS_BASE_URL", "https://jenkins.example.com")
+
+	t.Setenv("GITHUB_BACKEND", "foo")
+	_, err = builder.registry(settings{})
+	require.Error(t, err)
+}
+
+func TestBadgeHandlerBuilderBuild(t *testing.T) {
+	builder := &badgeHandlerBuilder{
+		rateLimits: badges.NewRateLimits(),
+		breaker:    badges.NewCircuitBreaker(badges.CircuitBreakerConfig{}),
+	}
+
+	handler, err := builder.build(settings{})
+	require.NoError(t, err)
+	require.NotNil(t, handler)
+
+	// Badges from providers and aliases should be routed; nothing else should be
+	router, ok := handler.(*mux.Router)
+	require.True(t, ok)
+	for url, expected := range map[string]bool{
+		"/v1/github/checks/krancour/foo/badge.svg": true,
+		"/v1/alias/foo/badge.svg":                  true,
+		"/v1/foo/badge.svg":                        false,
+	} {
+		match := mux.RouteMatch{}
+		require.Equal(
+			t,
+			expected,
+			router.Match(
+				httptest.NewRequest(http.MethodGet, url, nil),
+				&match,
+			),
+			url,
+		)
+	}
+
+	t.Setenv("BADGE_ALIASES", "foo=/healthz")
+	_, err = builder.build(settings{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not a badge URL")
+
+	t.Setenv(
+		"BADGE_ALIASES",
+		"foo=/v1/github/checks/krancour/foo/badge.svg",
+	)
+	t.Setenv("REPO_ALLOWLIST", "brigadecore/[")
+	_, err = builder.build(settings{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid access rule")
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("REDIS_HOST", "localhost")
+	t.Setenv("REDIS_PASSWORD", "foo")
+	startup, err := loadStartupConfig(settings{})
+	require.NoError(t, err)
+	builder := &badgeHandlerBuilder{
+		rateLimits: badges.NewRateLimits(),
+		breaker:    badges.NewCircuitBreaker(badges.CircuitBreakerConfig{}),
+	}
+	badgeHandler := &reloadableHandler{}
+	readinessHandler := &reloadableHandler{}
+
+	// Invalid settings should be refused
+	err = reloadConfig(
+		settings{file: map[string]string{"BADGE_STYLE": "foo"}},
+		startup,
+		builder,
+		badgeHandler,
+		readinessHandler,
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid badge style")
+	require.Nil(t, badgeHandler.handler.Load())
+	require.Nil(t, readinessHandler.handler.Load())
+
+	// Settings that take effect only on restart should be refused
+	err = reloadConfig(
+		settings{
+			file: map[string]string{
+				"BADGE_STYLE":    "flat-square",
+				"CACHE_WARM_TTL": "2m",
+				"METRICS_PORT":   "9090",
+			},
+		},
+		startup,
+		builder,
+		badgeHandler,
+		readinessHandler,
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "require a restart: metrics, cache")
+	require.Nil(t, badgeHandler.handler.Load())
+	require.Nil(t, readinessHandler.handler.Load())
+
+	err = reloadConfig(
+		settings{file: map[string]string{"BADGE_STYLE": "flat-square"}},
+		startup,
+		builder,
+		badgeHandler,
+		readinessHandler,
+	)
+	require.NoError(t, err)
+	require.NotNil(t, badgeHandler.handler.Load())
+	require.NotNil(t, readinessHandler.handler.Load())
+}
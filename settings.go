@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// settings supplies the value of each of Badgr's settings by the name of the
+// environment variable that stands in for it. Environment variables take
+// precedence over values from the config file. The process environment is only
+// ever read, so settings removed from the config file simply cease to apply.
+type settings struct {
+	// file holds the values of settings from the config file, if any, keyed by
+	// the environment variable each stands in for
+	file map[string]string
+}
+
+// lookup returns the value of the specified setting, or an empty string if it
+// is unset.
+func (s settings) lookup(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return s.file[name]
+}
+
+// Setting returns the value of the specified setting, or an empty string if it
+// is unset. This permits badge providers to configure themselves.
+func (s settings) Setting(name string) string {
+	return s.lookup(name)
+}
+
+// get returns the value of the specified setting, or the provided default if
+// it is unset.
+func (s settings) get(name, defaultValue string) string {
+	if value := s.lookup(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getRequired returns the value of the specified setting, which must be set.
+func (s settings) getRequired(name string) (string, error) {
+	value := s.lookup(name)
+	if value == "" {
+		return "", errors.Errorf(
+			"value not found for required environment variable %s",
+			name,
+		)
+	}
+	return value, nil
+}
+
+// getStringSlice returns the comma-delimited value of the specified setting,
+// or the provided default if it is unset.
+func (s settings) getStringSlice(name string, defaultValue []string) []string {
+	value := s.lookup(name)
+	if value == "" {
+		return defaultValue
+	}
+	return strings.Split(value, ",")
+}
+
+// getInt returns the value of the specified setting as an int, or the provided
+// default if it is unset.
+func (s settings) getInt(name string, defaultValue int) (int, error) {
+	valueStr := s.lookup(name)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, errors.Errorf(
+			"value %q for environment variable %s was not parsable as an int",
+			valueStr,
+			name,
+		)
+	}
+	return value, nil
+}
+
+// getBool returns the value of the specified setting as a bool, or the
+// provided default if it is unset.
+func (s settings) getBool(name string, defaultValue bool) (bool, error) {
+	valueStr := s.lookup(name)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, errors.Errorf(
+			"value %q for environment variable %s was not parsable as a bool",
+			valueStr,
+			name,
+		)
+	}
+	return value, nil
+}
+
+// getDuration returns the value of the specified setting as a duration, or the
+// provided default if it is unset.
+func (s settings) getDuration(
+	name string,
+	defaultValue time.Duration,
+) (time.Duration, error) {
+	valueStr := s.lookup(name)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return 0, errors.Errorf(
+			"value %q for environment variable %s was not parsable as a duration",
+			valueStr,
+			name,
+		)
+	}
+	return value, nil
+}
+
+// getIPNetSlice returns the comma-delimited value of the specified setting as
+// CIDR addresses, or the provided default if it is unset.
+func (s settings) getIPNetSlice(
+	name string,
+	defaultValue []net.IPNet,
+) ([]net.IPNet, error) {
+	valueStr := s.lookup(name)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	valueStrs := strings.Split(valueStr, ",")
+	nets := make([]net.IPNet, len(valueStrs))
+	for i, cidr := range valueStrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"value %q for environment variable %s was not parsable as a slice "+
+					"of CIDR address",
+				cidr,
+				name,
+			)
+		}
+		nets[i] = *ipNet
+	}
+	return nets, nil
+}
+
+// getURL returns the value of the specified setting, which, if set, must be an
+// absolute URL.
+func (s settings) getURL(name string) (string, error) {
+	value := s.lookup(name)
+	if value == "" {
+		return "", nil
+	}
+	if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+		return "", errors.Errorf(
+			"value %q for environment variable %s is not an absolute URL",
+			value,
+			name,
+		)
+	}
+	return value, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettings(t *testing.T) {
+	s := settings{
+		file: map[string]string{
+			"FOO": "file",
+			"BAR": "file",
+			"BAT": "1m",
+		},
+	}
+	t.Setenv("FOO", "env")
+	t.Setenv("BAZ", "env")
+
+	// Environment variables take precedence over the file
+	require.Equal(t, "env", s.get("FOO", "default"))
+	require.Equal(t, "file", s.get("BAR", "default"))
+	require.Equal(t, "env", s.get("BAZ", "default"))
+	require.Equal(t, "default", s.get("QUX", "default"))
+
+	_, err := s.getRequired("QUX")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "QUX")
+
+	duration, err := s.getDuration("BAT", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, duration)
+
+	_, err = s.getInt("BAR", 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "was not parsable as an int")
+}
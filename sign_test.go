@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		args       []string
+		assertions func(output string, err error)
+	}{
+		{
+			name: "no URL specified",
+			assertions: func(_ string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "exactly one badge URL")
+			},
+		},
+		{
+			name: "SIGNING_KEY not set",
+			args: []string{
+				"https://badgr.example.com/v1/github/checks/a/b/badge.svg",
+			},
+			assertions: func(_ string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "SIGNING_KEY must be set")
+			},
+		},
+		{
+			name: "not a badge URL",
+			setup: func() {
+				t.Setenv("SIGNING_KEY", "foo")
+			},
+			args: []string{"https://badgr.example.com/healthz"},
+			assertions: func(_ string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is not a badge URL")
+			},
+		},
+		{
+			name: "success",
+			args: []string{
+				"-ttl", "1h",
+				"https://badgr.example.com/v1/github/checks/a/b/badge.svg?branch=v2",
+			},
+			assertions: func(output string, err error) {
+				require.NoError(t, err)
+				require.True(
+					t,
+					strings.HasPrefix(
+						output,
+						"https://badgr.example.com/v1/github/checks/a/b/badge.svg?"+
+							"branch=v2&expires=",
+					),
+				)
+				require.Contains(t, output, "&sig=")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			out := &bytes.Buffer{}
+			err := sign(testCase.args, settings{}, out)
+			testCase.assertions(out.String(), err)
+		})
+	}
+}